@@ -15,7 +15,11 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/huhenry/hej/pkg/config"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/auth"
+	"github.com/huhenry/hej/pkg/handler/license"
 	"github.com/huhenry/hej/pkg/log"
+	"github.com/huhenry/hej/pkg/token"
 	"github.com/huhenry/hej/pkg/version"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -54,6 +58,11 @@ func main() {
 
 			// logger
 			log.InitFromViper(v)
+			auth.InitFromViper(v)
+			if err := token.InitFromViper(v); err != nil {
+				return err
+			}
+			license.InitFromViper(v)
 
 			///////////////////////////////////////
 			////  http service
@@ -74,13 +83,19 @@ func main() {
 				logger.Errorf("init backend client failed %s", err)
 				return err
 			}
+			// the audit dispatcher replays its spool through the backend client
+			audit.InitFromViper(v)
 
 			// http service init
-			router.Api().ConfigDefault().
+			api := router.Api().ConfigDefault().
 				WithManager(mgr).
 				SetTimeout(time.Duration(httptimeout) * time.Second).
-				InitRouter().
-				Runapi(cfg.GetString("http.http_addr"))
+				InitRouter()
+			if err := api.CheckAuthorization(); err != nil {
+				logger.Errorf("check route authorization failed %s", err)
+				return err
+			}
+			api.Runapi(cfg.GetString("http.http_addr"))
 
 			prometheusmetrics.RegisterInternalMetrics()
 
@@ -112,9 +127,15 @@ func main() {
 		config.AddConfigFileFlag,
 		config.AddBaseFlags,
 		log.AddFlags,
+		auth.AddFlags,
+		token.AddFlags,
+		license.AddFlags,
+		audit.AddFlags,
 		clientOptions.AddFlags,
 	)
 
+	command.AddCommand(newRoutesCommand())
+
 	if error := command.Execute(); error != nil {
 		fmt.Println(error.Error())
 		os.Exit(1)
@@ -148,6 +169,7 @@ func GracefulQuit() {
 	logger.Infof("service make a graceful quit !!!!!!!!!!!!!!")
 	router.Api().Shutdown() // close http service
 	// close your service here
+	audit.Flush(5 * time.Second)
 
 	time.Sleep(1 * time.Second)
 }
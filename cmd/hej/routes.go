@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/huhenry/hej/pkg/router"
+	"github.com/spf13/cobra"
+)
+
+func newRoutesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "routes",
+		Short: "Print every route with its permissions and handler.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			routes := router.Api().Routes()
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "METHOD\tPATH\tPERMISSIONS\tHANDLER")
+			for _, route := range routes {
+				permissions := strings.Join(route.PermissionNames(), ",")
+				if len(permissions) == 0 {
+					permissions = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", route.Method, route.FullPath(), permissions, route.HandlerName())
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			return router.VerifyRoutes(routes)
+		},
+	}
+}
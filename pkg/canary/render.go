@@ -0,0 +1,22 @@
+package canary
+
+import (
+	"github.com/huhenry/hej/pkg/common"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RenderCanary returns the canary resource of the spec.
+func RenderCanary(namespace string, canarySpec *CanarySpec) (*unstructured.Unstructured, error) {
+	spec := map[string]interface{}{}
+	if err := common.JsonConvert(canarySpec, &spec); err != nil {
+		return nil, err
+	}
+
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	cr.SetAPIVersion(schema.GroupVersion{Group: common.ServiceMeshGroup, Version: common.ServiceMeshVersion}.String())
+	cr.SetKind(common.CanaryKind)
+	cr.SetName(canarySpec.Name)
+	cr.SetNamespace(namespace)
+	return cr, nil
+}
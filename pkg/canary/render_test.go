@@ -0,0 +1,26 @@
+package canary_test
+
+import (
+	"encoding/json"
+
+	. "github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RenderCanary", func() {
+	It("should render the spec as a canary resource", func() {
+		spec := &CanarySpec{}
+		spec.Name = "reviews-v2"
+		spec.MicroService = "reviews"
+		Expect(json.Unmarshal([]byte(`{"weightStrategy":[{"version":"v1","weight":90},{"version":"v2","weight":10}]}`), &spec.Policy)).To(Succeed())
+
+		cr, err := RenderCanary("shop", spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cr.GetKind()).To(Equal(common.CanaryKind))
+		Expect(cr.GetName()).To(Equal("reviews-v2"))
+		Expect(cr.GetNamespace()).To(Equal("shop"))
+		Expect(cr.Object["spec"]).NotTo(BeEmpty())
+	})
+})
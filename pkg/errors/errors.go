@@ -11,6 +11,7 @@ type ErrorReason int
 const (
 	CodeParameterRequiredErr = 40001
 	CodeBadParametersErr     = 40002
+	CodeUnauthorizedErr      = 40101
 	CodeDynamicClientErr     = 50001
 	CodeCustomClientErr      = 50002
 	CodeOriginErr            = 50010
@@ -138,6 +139,16 @@ func BadParametersErr(err error) *HttpRespError {
 	}
 }
 
+// UnauthorizedErr .
+func UnauthorizedErr(msg string, err error) *HttpRespError {
+	return &HttpRespError{
+		HTTPStatus: http.StatusUnauthorized,
+		Code:       CodeUnauthorizedErr,
+		Err:        err,
+		Message:    msg,
+	}
+}
+
 // DynamicClientErr .
 func DynamicClientErr(err error) *HttpRespError {
 
@@ -73,4 +73,8 @@ const (
 	StatusCodeLicenseInvalid int = 800
 	// 801   license is overload
 	StatusCodeLicenseOverload int = 801
+	// 802   license is missing
+	StatusCodeLicenseMissing int = 802
+	// 803   license can not be fetched from the backend
+	StatusCodeLicenseUnavailable int = 803
 )
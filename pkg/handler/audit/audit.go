@@ -24,14 +24,37 @@ const (
 	ActionDelete           = "删除"
 	ActionGOOFFLINE        = "下线版本"
 	ActionPut              = "编辑"
+	ActionStartAnalysis    = "开启自动灰度"
+	ActionStopAnalysis     = "停止自动灰度"
+	ActionPause            = "暂停"
+	ActionResume           = "恢复"
+	ActionPromote          = "全量发布"
+	ActionRollback         = "回滚"
+	ActionUpgrade          = "升级"
+	ActionImport           = "导入"
+	ActionClone            = "克隆"
 )
 
+// Send records the entries in the local index and hands them to the audit
+// dispatcher, they are sent synchronously when the dispatcher is not started.
 func Send(audits []*v1.Audit) {
+	if index := DefaultIndex(); index != nil {
+		index.Add(audits...)
+	}
+
+	if dispatcher, ok := defaultDispatcher.Load().(*Dispatcher); ok {
+		dispatcher.Enqueue(audits...)
+		return
+	}
+
 	for i := range audits {
-		audit := audits[i]
-		executor := backend.GetClient().V1().AdminExecutor()
-		if err := backend.GetClient().V1().Audit().Create(executor, audit); err != nil {
+		if err := create(audits[i]); err != nil {
 			logger.Errorf("invoke audit interface failed due to: %v", err)
 		}
 	}
 }
+
+func create(audit *v1.Audit) error {
+	executor := backend.GetClient().V1().AdminExecutor()
+	return backend.GetClient().V1().Audit().Create(executor, audit)
+}
@@ -0,0 +1,388 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/huhenry/hej/pkg/backend/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+)
+
+const (
+	auditQueueSize     = "audit.queue_size"
+	auditBatchSize     = "audit.batch_size"
+	auditFlushInterval = "audit.flush_interval"
+	auditMaxRetries    = "audit.max_retries"
+	auditRetryBackoff  = "audit.retry_backoff"
+	auditSpoolFile     = "audit.spool_file"
+	auditReplayPeriod  = "audit.replay_interval"
+	auditIndexFile     = "audit.index_file"
+	auditIndexSize     = "audit.index_size"
+
+	maxRetryBackoff = 30 * time.Second
+)
+
+var auditEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "hej_audit_entries_total",
+	Help: "Audit entries handled by the audit dispatcher, by result queued, sent, spooled or dropped.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(auditEntries)
+}
+
+// AddFlags adds the audit dispatcher and index flags
+func AddFlags(flagSet *flag.FlagSet) {
+	flagSet.Int(auditQueueSize, 1000, "Maximal number of audit entries waiting to be sent, entries beyond are spooled.")
+	flagSet.Int(auditBatchSize, 50, "Maximal number of audit entries collected before they are sent, one by one and in order.")
+	flagSet.Duration(auditFlushInterval, time.Second, "How long audit entries wait for the batch to fill before they are sent.")
+	flagSet.Int(auditMaxRetries, 5, "How many times a failed audit entry is retried before the batch is spooled.")
+	flagSet.Duration(auditRetryBackoff, 500*time.Millisecond, "Initial delay between retries, it doubles on each retry.")
+	flagSet.String(auditSpoolFile, "audit.spool", "File keeping the audit entries the backend did not accept, replayed on start. Empty disables the spool.")
+	flagSet.Duration(auditReplayPeriod, maxRetryBackoff, "How often the spool is replayed while it holds entries.")
+	flagSet.String(auditIndexFile, "audit.index", "File keeping the recent audit entries queried locally. Empty disables the index.")
+	flagSet.Int(auditIndexSize, 10000, "Maximal number of audit entries kept in the local index, the oldest are dropped first.")
+}
+
+// InitFromViper starts the audit dispatcher and opens the index with
+// properties from viper
+func InitFromViper(v *viper.Viper) {
+	initIndex(v.GetString(auditIndexFile), v.GetInt(auditIndexSize))
+
+	dispatcher := NewDispatcher(Options{
+		QueueSize:     v.GetInt(auditQueueSize),
+		BatchSize:     v.GetInt(auditBatchSize),
+		FlushInterval: v.GetDuration(auditFlushInterval),
+		MaxRetries:    v.GetInt(auditMaxRetries),
+		RetryBackoff:  v.GetDuration(auditRetryBackoff),
+		SpoolFile:     v.GetString(auditSpoolFile),
+		ReplayPeriod:  v.GetDuration(auditReplayPeriod),
+	}, create)
+	dispatcher.Start()
+	defaultDispatcher.Store(dispatcher)
+}
+
+var defaultDispatcher atomic.Value
+
+// Flush sends the queued entries and stops the dispatcher, entries which are
+// not sent before the timeout are spooled. The index writes what it has left.
+func Flush(timeout time.Duration) {
+	if dispatcher, ok := defaultDispatcher.Load().(*Dispatcher); ok {
+		dispatcher.Stop(timeout)
+	}
+	if index := DefaultIndex(); index != nil {
+		index.Close()
+	}
+}
+
+type Options struct {
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	SpoolFile     string
+	// ReplayPeriod is how often the spool is replayed while it holds entries.
+	ReplayPeriod time.Duration
+}
+
+// Dispatcher sends audit entries to the backend in the background. Entries
+// are collected for up to BatchSize or FlushInterval and then sent one by one
+// in order, the backend takes a single entry per call. A failed entry is
+// retried with backoff and once the retries are exhausted the rest of the
+// batch is appended to the spool file. The spool is replayed when the
+// dispatcher starts and then periodically whenever it was written to.
+type Dispatcher struct {
+	opts    Options
+	send    func(audit *v1.Audit) error
+	queue   chan *v1.Audit
+	spoolMu sync.Mutex
+	// dirty is set when the spool holds entries which are not replayed yet
+	dirty   int32
+	stopped int32
+	done    chan struct{}
+	exited  chan struct{}
+}
+
+func NewDispatcher(opts Options, send func(audit *v1.Audit) error) *Dispatcher {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.ReplayPeriod <= 0 {
+		opts.ReplayPeriod = maxRetryBackoff
+	}
+	return &Dispatcher{
+		opts:   opts,
+		send:   send,
+		queue:  make(chan *v1.Audit, opts.QueueSize),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Enqueue never blocks the request, entries are spooled when the queue is
+// full or the dispatcher is stopped.
+func (d *Dispatcher) Enqueue(audits ...*v1.Audit) {
+	for _, audit := range audits {
+		if atomic.LoadInt32(&d.stopped) == 0 {
+			select {
+			case d.queue <- audit:
+				auditEntries.WithLabelValues("queued").Inc()
+				continue
+			default:
+				logger.Warnf("audit queue is full, spool the entry")
+			}
+		}
+		d.spool([]*v1.Audit{audit})
+	}
+}
+
+// Stop drains the queue and waits for the dispatcher to exit.
+func (d *Dispatcher) Stop(timeout time.Duration) {
+	if !atomic.CompareAndSwapInt32(&d.stopped, 0, 1) {
+		return
+	}
+	close(d.done)
+
+	select {
+	case <-d.exited:
+	case <-time.After(timeout):
+		logger.Warnf("audit dispatcher did not flush in %s", timeout)
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.exited)
+
+	d.replay()
+	lastReplay := time.Now()
+
+	ticker := time.NewTicker(d.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*v1.Audit, 0, d.opts.BatchSize)
+	for {
+		if atomic.LoadInt32(&d.dirty) == 1 && time.Since(lastReplay) >= d.opts.ReplayPeriod {
+			d.replay()
+			lastReplay = time.Now()
+		}
+
+		select {
+		case audit := <-d.queue:
+			batch = append(batch, audit)
+			if len(batch) >= d.opts.BatchSize {
+				d.dispatch(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				d.dispatch(batch)
+				batch = batch[:0]
+			}
+		case <-d.done:
+		drain:
+			for {
+				select {
+				case audit := <-d.queue:
+					batch = append(batch, audit)
+				default:
+					break drain
+				}
+			}
+			d.dispatch(batch)
+			return
+		}
+	}
+}
+
+// dispatch sends the batch in order, once an entry exhausts its retries the
+// backend is considered down and the remaining entries are spooled. No retry
+// is made after the dispatcher is stopped.
+func (d *Dispatcher) dispatch(batch []*v1.Audit) {
+	for i, audit := range batch {
+		backoff := d.opts.RetryBackoff
+		for attempt := 0; ; attempt++ {
+			err := d.send(audit)
+			if err == nil {
+				auditEntries.WithLabelValues("sent").Inc()
+				break
+			}
+			logger.Errorf("invoke audit interface failed due to: %v", err)
+
+			if attempt >= d.opts.MaxRetries || d.isStopped() {
+				d.spool(batch[i:])
+				return
+			}
+			select {
+			case <-time.After(backoff):
+			case <-d.done:
+			}
+			if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) isStopped() bool {
+	return atomic.LoadInt32(&d.stopped) == 1
+}
+
+func (d *Dispatcher) spool(audits []*v1.Audit) {
+	if len(audits) == 0 {
+		return
+	}
+	if len(d.opts.SpoolFile) == 0 {
+		logger.Errorf("audit spool is disabled, drop %d entries", len(audits))
+		auditEntries.WithLabelValues("dropped").Add(float64(len(audits)))
+		return
+	}
+
+	d.spoolMu.Lock()
+	defer d.spoolMu.Unlock()
+
+	if err := appendSpool(d.opts.SpoolFile, audits); err != nil {
+		logger.Errorf("spool %d audit entries failed, drop them err: %s", len(audits), err)
+		auditEntries.WithLabelValues("dropped").Add(float64(len(audits)))
+		return
+	}
+	atomic.StoreInt32(&d.dirty, 1)
+	auditEntries.WithLabelValues("spooled").Add(float64(len(audits)))
+}
+
+// replay sends the entries spooled so far, each is tried once so the live
+// entries do not wait behind a backend which is down. It stops at the first
+// failure and keeps the entries not sent, with the ones spooled meanwhile, in
+// the spool, which is then marked to be replayed again.
+func (d *Dispatcher) replay() {
+	if len(d.opts.SpoolFile) == 0 {
+		return
+	}
+
+	d.spoolMu.Lock()
+	atomic.StoreInt32(&d.dirty, 0)
+	audits, offset, err := readSpool(d.opts.SpoolFile, 0)
+	d.spoolMu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		logger.Errorf("replay audit spool %s failed err: %s", d.opts.SpoolFile, err)
+		atomic.StoreInt32(&d.dirty, 1)
+		return
+	}
+	if len(audits) == 0 {
+		return
+	}
+
+	logger.Infof("replay %d spooled audit entries", len(audits))
+	sent := 0
+	for ; sent < len(audits) && !d.isStopped(); sent++ {
+		if err := d.send(audits[sent]); err != nil {
+			logger.Errorf("replay audit entries failed, %d are left err: %v", len(audits)-sent, err)
+			break
+		}
+		auditEntries.WithLabelValues("sent").Inc()
+	}
+
+	d.spoolMu.Lock()
+	defer d.spoolMu.Unlock()
+	appended, _, err := readSpool(d.opts.SpoolFile, offset)
+	if err != nil {
+		logger.Errorf("read audit spool %s failed err: %s", d.opts.SpoolFile, err)
+		atomic.StoreInt32(&d.dirty, 1)
+		return
+	}
+	left := append(audits[sent:], appended...)
+	if err := rewriteSpool(d.opts.SpoolFile, left); err != nil {
+		logger.Errorf("rewrite audit spool %s failed err: %s", d.opts.SpoolFile, err)
+		atomic.StoreInt32(&d.dirty, 1)
+		return
+	}
+	if len(left) > 0 {
+		atomic.StoreInt32(&d.dirty, 1)
+	}
+}
+
+func appendSpool(path string, audits []*v1.Audit) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	for _, audit := range audits {
+		if err := encoder.Encode(audit); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rewriteSpool replaces the spool with the entries, the new file replaces
+// the old one atomically.
+func rewriteSpool(path string, audits []*v1.Audit) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if len(audits) > 0 {
+		if err := appendSpool(tmp, audits); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	} else if err := os.WriteFile(tmp, nil, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readSpool reads one entry per line from offset, broken lines are skipped.
+// It returns the offset of the end of the file.
+func readSpool(path string, offset int64) ([]*v1.Audit, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	audits := []*v1.Audit{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		offset += int64(len(scanner.Bytes())) + 1
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		audit := &v1.Audit{}
+		if err := json.Unmarshal(scanner.Bytes(), audit); err != nil {
+			logger.Warnf("skip broken spooled audit entry err: %s", err)
+			continue
+		}
+		audits = append(audits, audit)
+	}
+	return audits, offset, scanner.Err()
+}
@@ -0,0 +1,171 @@
+package audit_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "github.com/huhenry/hej/pkg/backend/v1"
+	. "github.com/huhenry/hej/pkg/handler/audit"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeBackend struct {
+	mu      sync.Mutex
+	down    bool
+	targets []string
+}
+
+func (b *fakeBackend) create(audit *v1.Audit) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return errors.New("backend unavailable")
+	}
+	b.targets = append(b.targets, audit.Target)
+	return nil
+}
+
+func (b *fakeBackend) setDown(down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.down = down
+}
+
+func (b *fakeBackend) sent() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string{}, b.targets...)
+}
+
+func entries(n int) []*v1.Audit {
+	audits := []*v1.Audit{}
+	for i := 0; i < n; i++ {
+		audits = append(audits, &v1.Audit{Module: ModuleCanary, Action: ActionCreate, Target: fmt.Sprintf("canary-%d", i)})
+	}
+	return audits
+}
+
+var _ = Describe("Dispatcher", func() {
+	var (
+		backend *fakeBackend
+		opts    Options
+		dir     string
+	)
+
+	BeforeEach(func() {
+		backend = &fakeBackend{}
+		var err error
+		dir, err = os.MkdirTemp("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+
+		opts = Options{
+			QueueSize:     10,
+			BatchSize:     2,
+			FlushInterval: 10 * time.Millisecond,
+			MaxRetries:    2,
+			RetryBackoff:  time.Millisecond,
+			SpoolFile:     filepath.Join(dir, "audit.spool"),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should send the entries in the background", func() {
+		dispatcher := NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		defer dispatcher.Stop(time.Second)
+
+		dispatcher.Enqueue(entries(3)...)
+		Eventually(backend.sent).Should(Equal([]string{"canary-0", "canary-1", "canary-2"}))
+	})
+
+	It("should flush the queue on stop", func() {
+		opts.FlushInterval = time.Hour
+		opts.BatchSize = 10
+		dispatcher := NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+
+		dispatcher.Enqueue(entries(3)...)
+		dispatcher.Stop(time.Second)
+		Expect(backend.sent()).To(HaveLen(3))
+	})
+
+	It("should spool entries while the backend is down and replay them on start", func() {
+		backend.setDown(true)
+		dispatcher := NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		dispatcher.Enqueue(entries(3)...)
+		dispatcher.Stop(time.Second)
+		Expect(backend.sent()).To(BeEmpty())
+
+		backend.setDown(false)
+		dispatcher = NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		defer dispatcher.Stop(time.Second)
+
+		Eventually(backend.sent).Should(ConsistOf("canary-0", "canary-1", "canary-2"))
+		Eventually(func() (int64, error) {
+			info, err := os.Stat(opts.SpoolFile)
+			if err != nil {
+				return -1, err
+			}
+			return info.Size(), nil
+		}).Should(BeZero())
+	})
+
+	It("should keep the spool while the backend is down and send live entries meanwhile", func() {
+		backend.setDown(true)
+		dispatcher := NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		dispatcher.Enqueue(entries(3)...)
+		dispatcher.Stop(time.Second)
+
+		opts.ReplayPeriod = 20 * time.Millisecond
+		dispatcher = NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		defer dispatcher.Stop(time.Second)
+		Expect(opts.SpoolFile).To(BeAnExistingFile())
+
+		backend.setDown(false)
+		dispatcher.Enqueue(&v1.Audit{Module: ModuleCanary, Action: ActionCreate, Target: "live"})
+		Eventually(backend.sent).Should(ConsistOf("live", "canary-0", "canary-1", "canary-2"))
+		Consistently(backend.sent, 100*time.Millisecond).Should(HaveLen(4))
+	})
+
+	It("should replay entries spooled after a clean start once the backend is back", func() {
+		opts.ReplayPeriod = 20 * time.Millisecond
+		dispatcher := NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		defer dispatcher.Stop(time.Second)
+
+		dispatcher.Enqueue(entries(1)...)
+		Eventually(backend.sent).Should(Equal([]string{"canary-0"}))
+
+		backend.setDown(true)
+		dispatcher.Enqueue(&v1.Audit{Module: ModuleCanary, Action: ActionCreate, Target: "outage"})
+		Eventually(opts.SpoolFile).Should(BeAnExistingFile())
+
+		backend.setDown(false)
+		Eventually(backend.sent).Should(Equal([]string{"canary-0", "outage"}))
+	})
+
+	It("should spool entries enqueued after stop", func() {
+		dispatcher := NewDispatcher(opts, backend.create)
+		dispatcher.Start()
+		dispatcher.Stop(time.Second)
+
+		dispatcher.Enqueue(entries(1)...)
+		Expect(opts.SpoolFile).To(BeAnExistingFile())
+		Expect(backend.sent()).To(BeEmpty())
+	})
+})
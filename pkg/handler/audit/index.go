@@ -0,0 +1,294 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/huhenry/hej/pkg/backend/v1"
+)
+
+func initIndex(path string, size int) {
+	if len(path) == 0 {
+		return
+	}
+	index, err := OpenIndex(path, size)
+	if err != nil {
+		logger.Errorf("open audit index %s failed, audits are not queryable err: %s", path, err)
+		return
+	}
+	defaultIndex.Store(index)
+}
+
+var defaultIndex atomic.Value
+
+// DefaultIndex is the index fed by Send, it is nil when the index is disabled.
+func DefaultIndex() *Index {
+	index, _ := defaultIndex.Load().(*Index)
+	return index
+}
+
+// Record is an audit entry as kept by the local index.
+type Record struct {
+	Timestamp   int64  `json:"timestamp"`
+	Module      string `json:"module"`
+	Action      string `json:"action"`
+	Target      string `json:"target"`
+	User        string `json:"user"`
+	UserIp      string `json:"userIp"`
+	AppId       int64  `json:"appId"`
+	Cluster     string `json:"cluster"`
+	NamespaceId int64  `json:"namespaceId"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+func newRecord(audit *v1.Audit, now time.Time) Record {
+	return Record{
+		Timestamp:   now.Unix(),
+		Module:      audit.Module,
+		Action:      audit.Action,
+		Target:      audit.Target,
+		User:        audit.User,
+		UserIp:      audit.UserIp,
+		AppId:       audit.AppId,
+		Cluster:     audit.Cluster,
+		NamespaceId: audit.NamespaceId,
+		Detail:      audit.Detail,
+	}
+}
+
+// Query selects records, empty fields match everything and the time range is
+// inclusive in unix seconds.
+type Query struct {
+	AppId     int64
+	Cluster   string
+	Module    string
+	Action    string
+	User      string
+	Target    string
+	StartTime int64
+	EndTime   int64
+}
+
+func (q *Query) match(record *Record) bool {
+	switch {
+	case q.AppId != 0 && record.AppId != q.AppId:
+		return false
+	case len(q.Cluster) > 0 && record.Cluster != q.Cluster:
+		return false
+	case len(q.Module) > 0 && record.Module != q.Module:
+		return false
+	case len(q.Action) > 0 && record.Action != q.Action:
+		return false
+	case len(q.User) > 0 && record.User != q.User:
+		return false
+	case len(q.Target) > 0 && record.Target != q.Target:
+		return false
+	case q.StartTime > 0 && record.Timestamp < q.StartTime:
+		return false
+	case q.EndTime > 0 && record.Timestamp > q.EndTime:
+		return false
+	}
+	return true
+}
+
+// Index keeps the latest audit records in memory and in an append only file,
+// the file is compacted once it holds twice the records kept. The file is
+// written by a goroutine of its own so Add does not wait on the disk.
+type Index struct {
+	mu      sync.RWMutex
+	path    string
+	size    int
+	records []Record
+	// pending are the records added and not written yet, once they are more
+	// than the records kept the file is compacted instead.
+	pending  []Record
+	overflow bool
+	now      func() time.Time
+
+	// lines is owned by the writer
+	lines     int
+	wake      chan struct{}
+	closed    chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenIndex loads the records of the file, a missing file is an empty index.
+func OpenIndex(path string, size int) (*Index, error) {
+	if size <= 0 {
+		size = 1
+	}
+	index := &Index{
+		path:   path,
+		size:   size,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			index.lines++
+			record := Record{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				logger.Warnf("skip broken audit record err: %s", err)
+				continue
+			}
+			index.records = append(index.records, record)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(index.records) > size {
+		index.records = index.records[len(index.records)-size:]
+	}
+	go index.write()
+	return index, nil
+}
+
+// Add records the entries, they are searchable at once and written to the
+// file in the background. A failure to persist is only logged since the
+// entries are still sent to the backend.
+func (i *Index) Add(audits ...*v1.Audit) {
+	i.mu.Lock()
+	now := i.now()
+	records := make([]Record, 0, len(audits))
+	for _, audit := range audits {
+		records = append(records, newRecord(audit, now))
+	}
+	i.records = append(i.records, records...)
+	if len(i.records) > i.size {
+		i.records = append([]Record{}, i.records[len(i.records)-i.size:]...)
+	}
+	i.pending = append(i.pending, records...)
+	if len(i.pending) > i.size {
+		i.pending, i.overflow = nil, true
+	}
+	i.mu.Unlock()
+
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close writes the pending records and stops the writer.
+func (i *Index) Close() {
+	i.closeOnce.Do(func() {
+		close(i.closed)
+	})
+	<-i.exited
+}
+
+func (i *Index) write() {
+	defer close(i.exited)
+	for {
+		select {
+		case <-i.wake:
+			i.flush()
+		case <-i.closed:
+			i.flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending records, the records kept are taken along with
+// them so a compaction holds exactly what was added so far.
+func (i *Index) flush() {
+	i.mu.Lock()
+	pending, overflow := i.pending, i.overflow
+	i.pending, i.overflow = nil, false
+	var records []Record
+	if overflow || i.lines+len(pending) > 2*i.size {
+		records = append([]Record{}, i.records...)
+	}
+	i.mu.Unlock()
+
+	if records != nil {
+		err := i.compact(records)
+		if err == nil {
+			return
+		}
+		logger.Errorf("compact audit index %s failed err: %s", i.path, err)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	if err := i.append(pending); err != nil {
+		logger.Errorf("append audit index %s failed err: %s", i.path, err)
+	}
+}
+
+func (i *Index) append(records []Record) error {
+	f, err := os.OpenFile(i.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, record := range records {
+		if err := encoder.Encode(&record); err != nil {
+			f.Close()
+			return err
+		}
+		i.lines++
+	}
+	return f.Close()
+}
+
+// compact rewrites the file with the records kept, the new file replaces the
+// old one atomically.
+func (i *Index) compact(records []Record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(i.path), filepath.Base(i.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := json.NewEncoder(tmp)
+	for _, record := range records {
+		if err := encoder.Encode(&record); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), i.path); err != nil {
+		return err
+	}
+	i.lines = len(records)
+	return nil
+}
+
+// Search returns the matching records, newest first.
+func (i *Index) Search(query Query) []Record {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	result := []Record{}
+	for j := len(i.records) - 1; j >= 0; j-- {
+		if query.match(&i.records[j]) {
+			result = append(result, i.records[j])
+		}
+	}
+	return result
+}
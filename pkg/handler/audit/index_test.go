@@ -0,0 +1,81 @@
+package audit_test
+
+import (
+	"os"
+	"path/filepath"
+
+	v1 "github.com/huhenry/hej/pkg/backend/v1"
+	. "github.com/huhenry/hej/pkg/handler/audit"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Index", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "audit.index")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	targets := func(records []Record) []string {
+		result := []string{}
+		for _, record := range records {
+			result = append(result, record.Target)
+		}
+		return result
+	}
+
+	It("should filter records and return the newest first", func() {
+		index, err := OpenIndex(path, 10)
+		Expect(err).NotTo(HaveOccurred())
+
+		index.Add(
+			&v1.Audit{Module: ModuleCanary, Action: ActionCreate, Target: "a", User: "alice", AppId: 1, Cluster: "c1"},
+			&v1.Audit{Module: ModuleCanary, Action: ActionUpdate, Target: "b", User: "bob", AppId: 1, Cluster: "c1"},
+			&v1.Audit{Module: ModuleMicroService, Action: ActionTrafficPolicy, Target: "c", User: "alice", AppId: 1, Cluster: "c1"},
+			&v1.Audit{Module: ModuleCanary, Action: ActionCreate, Target: "d", User: "alice", AppId: 2, Cluster: "c1"},
+		)
+
+		Expect(targets(index.Search(Query{AppId: 1, Cluster: "c1"}))).To(Equal([]string{"c", "b", "a"}))
+		Expect(targets(index.Search(Query{AppId: 1, Module: ModuleCanary}))).To(Equal([]string{"b", "a"}))
+		Expect(targets(index.Search(Query{AppId: 1, User: "alice"}))).To(Equal([]string{"c", "a"}))
+		Expect(targets(index.Search(Query{AppId: 1, Target: "b", Action: ActionUpdate}))).To(Equal([]string{"b"}))
+		Expect(index.Search(Query{AppId: 1, EndTime: 1})).To(BeEmpty())
+		index.Close()
+	})
+
+	It("should reload the records kept in the file", func() {
+		index, err := OpenIndex(path, 10)
+		Expect(err).NotTo(HaveOccurred())
+		index.Add(&v1.Audit{Target: "a"}, &v1.Audit{Target: "b"})
+		index.Close()
+
+		index, err = OpenIndex(path, 10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets(index.Search(Query{}))).To(Equal([]string{"b", "a"}))
+	})
+
+	It("should keep only the latest records", func() {
+		index, err := OpenIndex(path, 2)
+		Expect(err).NotTo(HaveOccurred())
+		for _, target := range []string{"a", "b", "c", "d", "e", "f"} {
+			index.Add(&v1.Audit{Target: target})
+		}
+		Expect(targets(index.Search(Query{}))).To(Equal([]string{"f", "e"}))
+		index.Close()
+
+		index, err = OpenIndex(path, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets(index.Search(Query{}))).To(Equal([]string{"f", "e"}))
+	})
+})
@@ -0,0 +1,62 @@
+package auditlog
+
+import (
+	"github.com/huhenry/hej/pkg/common/page"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/microapp/filter"
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	QueryParameterModule = "module"
+	QueryParameterAction = "action"
+	QueryParameterUser   = "user"
+	QueryParameterTarget = "target"
+)
+
+// recordSchema is what the audit records are filtered and sorted on, the
+// create time is when the record was made.
+var recordSchema = filter.Schema{
+	filter.FieldCreateTime: func(item interface{}) interface{} { return item.(*audit.Record).Timestamp },
+	filter.FieldCreator:    func(item interface{}) interface{} { return item.(*audit.Record).User },
+	"module":               func(item interface{}) interface{} { return item.(*audit.Record).Module },
+	"action":               func(item interface{}) interface{} { return item.(*audit.Record).Action },
+	"target":               func(item interface{}) interface{} { return item.(*audit.Record).Target },
+	"userIp":               func(item interface{}) interface{} { return item.(*audit.Record).UserIp },
+}
+
+// ListAudits answers from the local audit index, only the entries recorded
+// by this replica since the index was enabled are found.
+func ListAudits(ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+	paramQuery := handler.ExtractQueryParam(ctx)
+
+	index := audit.DefaultIndex()
+	if index == nil {
+		handler.Response(ctx, customErrors.StatusCodeServiceError, "审计索引未启用")
+		return
+	}
+
+	query, err := filter.ParserParams(ctx, recordSchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+
+	records := index.Search(audit.Query{
+		AppId:   appCtx.AppId,
+		Cluster: appCtx.ClusterName,
+		Module:  ctx.URLParam(QueryParameterModule),
+		Action:  ctx.URLParam(QueryParameterAction),
+		User:    ctx.URLParam(QueryParameterUser),
+		Target:  ctx.URLParam(QueryParameterTarget),
+	})
+
+	data := make([]interface{}, 0, len(records))
+	for i := range records {
+		data = append(data, &records[i])
+	}
+	handler.ResponseOk(ctx, page.PageInfo(query.Apply(recordSchema, data), paramQuery))
+}
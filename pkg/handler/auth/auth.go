@@ -41,8 +41,10 @@ func CheckPermissions(namespaceId int, appId int64, usrCtx *handler.UserContext,
 		return true
 	}
 
-	perMap, err := backend.GetClient().V1().Auth().
-		GetPermission(namespaceId, appId, usrCtx.Token)
+	perMap, err := permissionCache.Get(usrCtx.Token, namespaceId, appId, func() (PermissionMap, error) {
+		return backend.GetClient().V1().Auth().
+			GetPermission(namespaceId, appId, usrCtx.Token)
+	})
 	if err != nil {
 		logger.Errorf("occurs error when getting user permissions :%v", err)
 		return false
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"container/list"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/huhenry/hej/pkg/backend/v1"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	permissionCacheTTL  = "auth.permission_cache_ttl"
+	permissionCacheSize = "auth.permission_cache_size"
+
+	defaultPermissionCacheTTL  = 30 * time.Second
+	defaultPermissionCacheSize = 1024
+)
+
+var permissionCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "hej_permission_cache_requests_total",
+	Help: "Permission lookups served by the permission cache, by result hit or miss.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(permissionCacheRequests)
+	handler.OnTokenRejected(permissionCache.EvictToken)
+}
+
+// AddFlags adds the permission cache flags
+func AddFlags(flagSet *flag.FlagSet) {
+	flagSet.Duration(permissionCacheTTL, defaultPermissionCacheTTL, "How long user permissions are cached, 0 disables the cache.")
+	flagSet.Int(permissionCacheSize, defaultPermissionCacheSize, "Maximal number of (token, namespace, app) permission entries kept in cache.")
+}
+
+// InitFromViper configures the permission cache with properties from viper
+func InitFromViper(v *viper.Viper) {
+	permissionCache.Configure(v.GetDuration(permissionCacheTTL), v.GetInt(permissionCacheSize))
+}
+
+type PermissionMap = map[string][]v1.Permission
+
+type permissionKey struct {
+	token       string
+	namespaceId int
+	appId       int64
+}
+
+type permissionEntry struct {
+	key       permissionKey
+	value     PermissionMap
+	expiredAt time.Time
+}
+
+// PermissionCache is a TTL and LRU bounded cache of user permissions,
+// concurrent lookups of the same key share one backend call.
+type PermissionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	ll      *list.List
+	entries map[permissionKey]*list.Element
+	group   singleflight.Group
+	now     func() time.Time
+}
+
+var permissionCache = NewPermissionCache(defaultPermissionCacheTTL, defaultPermissionCacheSize)
+
+func NewPermissionCache(ttl time.Duration, size int) *PermissionCache {
+	return &PermissionCache{
+		ttl:     ttl,
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[permissionKey]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Configure changes ttl and size, the cached entries are dropped.
+func (c *PermissionCache) Configure(ttl time.Duration, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+	c.size = size
+	c.ll.Init()
+	c.entries = make(map[permissionKey]*list.Element)
+}
+
+// Get returns the cached permissions or calls load once for all concurrent
+// callers of the same key.
+func (c *PermissionCache) Get(token string, namespaceId int, appId int64, load func() (PermissionMap, error)) (PermissionMap, error) {
+	key := permissionKey{token: token, namespaceId: namespaceId, appId: appId}
+	if value, ok := c.lookup(key); ok {
+		permissionCacheRequests.WithLabelValues("hit").Inc()
+		return value, nil
+	}
+	permissionCacheRequests.WithLabelValues("miss").Inc()
+
+	value, err, _ := c.group.Do(fmt.Sprintf("%s/%d/%d", token, namespaceId, appId), func() (interface{}, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, value)
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(PermissionMap), nil
+}
+
+func (c *PermissionCache) lookup(key permissionKey) (PermissionMap, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*permissionEntry)
+	if c.now().After(entry.expiredAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.ll.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *PermissionCache) store(key permissionKey, value PermissionMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	expiredAt := c.now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*permissionEntry)
+		entry.value = value
+		entry.expiredAt = expiredAt
+		c.ll.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.ll.PushFront(&permissionEntry{key: key, value: value, expiredAt: expiredAt})
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// EvictToken drops every entry of the token, it is called when the token is
+// rejected.
+func (c *PermissionCache) EvictToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.entries {
+		if key.token == token {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *PermissionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *PermissionCache) remove(element *list.Element) {
+	entry := c.ll.Remove(element).(*permissionEntry)
+	delete(c.entries, entry.key)
+}
@@ -0,0 +1,118 @@
+package auth_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/huhenry/hej/pkg/backend/v1"
+	. "github.com/huhenry/hej/pkg/handler/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PermissionCache", func() {
+	var (
+		calls int32
+		load  func() (PermissionMap, error)
+	)
+
+	BeforeEach(func() {
+		calls = 0
+		load = func() (PermissionMap, error) {
+			atomic.AddInt32(&calls, 1)
+			return PermissionMap{"canary": []v1.Permission{}}, nil
+		}
+	})
+
+	It("should serve repeated lookups from cache", func() {
+		cache := NewPermissionCache(time.Minute, 10)
+
+		for i := 0; i < 3; i++ {
+			perMap, err := cache.Get("token", 1, 2, load)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(perMap).To(HaveKey("canary"))
+		}
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("should key entries by token, namespace and app", func() {
+		cache := NewPermissionCache(time.Minute, 10)
+
+		_, _ = cache.Get("token", 1, 2, load)
+		_, _ = cache.Get("token", 1, 3, load)
+		_, _ = cache.Get("other", 1, 2, load)
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(3))
+		Expect(cache.Len()).To(Equal(3))
+	})
+
+	It("should reload expired entries", func() {
+		cache := NewPermissionCache(10*time.Millisecond, 10)
+
+		_, _ = cache.Get("token", 1, 2, load)
+		time.Sleep(20 * time.Millisecond)
+		_, _ = cache.Get("token", 1, 2, load)
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+
+	It("should drop the least recently used entry", func() {
+		cache := NewPermissionCache(time.Minute, 2)
+
+		_, _ = cache.Get("a", 1, 1, load)
+		_, _ = cache.Get("b", 1, 1, load)
+		_, _ = cache.Get("a", 1, 1, load)
+		_, _ = cache.Get("c", 1, 1, load)
+		Expect(cache.Len()).To(Equal(2))
+
+		_, _ = cache.Get("a", 1, 1, load)
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(3))
+		_, _ = cache.Get("b", 1, 1, load)
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(4))
+	})
+
+	It("should not cache failures", func() {
+		cache := NewPermissionCache(time.Minute, 10)
+
+		_, err := cache.Get("token", 1, 2, func() (PermissionMap, error) {
+			return nil, fmt.Errorf("backend unavailable")
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(cache.Len()).To(Equal(0))
+	})
+
+	It("should evict every entry of a rejected token", func() {
+		cache := NewPermissionCache(time.Minute, 10)
+
+		_, _ = cache.Get("token", 1, 2, load)
+		_, _ = cache.Get("token", 1, 3, load)
+		_, _ = cache.Get("other", 1, 2, load)
+		cache.EvictToken("token")
+		Expect(cache.Len()).To(Equal(1))
+	})
+
+	It("should share one lookup between concurrent callers", func() {
+		cache := NewPermissionCache(time.Minute, 10)
+		release := make(chan struct{})
+		slow := func() (PermissionMap, error) {
+			<-release
+			return load()
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				_, err := cache.Get("token", 1, 2, slow)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+})
@@ -0,0 +1,265 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/common"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/canary/analysis"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/huhenry/hej/pkg/prometheus"
+	"github.com/kataras/iris/v12"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+// AnalysisAnnotation keeps the last status of the automated analysis on the
+// canary resource, next to its phase.
+const AnalysisAnnotation = "tpaas.troila.com/canary.analysis"
+
+// canaryExecutor shifts the traffic of one canary like the policy, takeover
+// and offline handlers do. The weights it sets are recorded as revisions of
+// the user who started the analysis.
+type canaryExecutor struct {
+	client    dynamic.NamespaceableResourceInterface
+	namespace string
+	name      string
+	version   string
+	user      string
+}
+
+func (e *canaryExecutor) SetWeight(ctx context.Context, weight int32) error {
+	_, err := updateCanaryPolicy(ctx, e.client, e.namespace, e.name, e.user, func(spec *canary.CanarySpec) (canary.CanaryPolicy, error) {
+		if spec.Policy == nil || len(spec.Policy.WeightStrategy) == 0 {
+			return canary.CanaryPolicy{}, fmt.Errorf("canary %s.%s has no weight strategy", e.name, e.namespace)
+		}
+
+		policy := *spec.Policy
+		policy.WeightStrategy = append(policy.WeightStrategy[:0:0], policy.WeightStrategy...)
+		stableAssigned := false
+		for i := range policy.WeightStrategy {
+			switch {
+			case policy.WeightStrategy[i].Version == e.version:
+				policy.WeightStrategy[i].Weight = weight
+			case !stableAssigned:
+				policy.WeightStrategy[i].Weight = analysis.MaxWeight - weight
+				stableAssigned = true
+			default:
+				policy.WeightStrategy[i].Weight = 0
+			}
+		}
+		return policy, nil
+	})
+	return err
+}
+
+func (e *canaryExecutor) Promote(ctx context.Context) error {
+	if err := canary.TakeOverAllTraffic(ctx, e.client, e.namespace, e.name, e.version); err != nil {
+		return err
+	}
+	markCanaryPhase(ctx, e.client, e.namespace, e.name, PhasePromoted)
+	return nil
+}
+
+func (e *canaryExecutor) Abort(ctx context.Context) error {
+	if err := canary.GoOffline(ctx, e.client, e.namespace, e.name, e.version); err != nil {
+		return err
+	}
+	markCanaryPhase(ctx, e.client, e.namespace, e.name, PhaseAborted)
+	return nil
+}
+
+func (e *canaryExecutor) AwaitApproval(ctx context.Context) error {
+	return transitCanaryPhase(ctx, e.client, e.namespace, e.name, PhaseAwaitingApproval)
+}
+
+func (e *canaryExecutor) Paused(ctx context.Context) (bool, error) {
+	phase, err := getCanaryPhase(ctx, e.client, e.namespace, e.name)
+	if err != nil {
+		return false, err
+	}
+	return phase == PhasePaused, nil
+}
+
+func (e *canaryExecutor) Record(ctx context.Context, status analysis.Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		un, err := e.client.Namespace(e.namespace).Get(ctx, e.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := un.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[AnalysisAnnotation] = string(data)
+		un.SetAnnotations(annotations)
+
+		_, err = e.client.Namespace(e.namespace).Update(ctx, un, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// recordedAnalysis reads the status kept on the canary by the last analysis,
+// only a finished one is returned since an unfinished status without a
+// running loop is stale.
+func recordedAnalysis(annotations map[string]string) (*analysis.Status, bool) {
+	data, ok := annotations[AnalysisAnnotation]
+	if !ok || len(data) == 0 {
+		return nil, false
+	}
+
+	status := &analysis.Status{}
+	if err := json.Unmarshal([]byte(data), status); err != nil {
+		logger.Warnf("decode canary analysis status failed err: %s", err)
+		return nil, false
+	}
+	if !status.Finished() {
+		return nil, false
+	}
+	return status, true
+}
+
+func fetchCanary(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) (*unstructured.Unstructured, *canary.CanarySpec, error) {
+	un, err := client.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec := &canary.CanarySpec{}
+	if err := common.JsonConvert(un.Object["spec"], spec); err != nil {
+		return nil, nil, err
+	}
+
+	return un, spec, nil
+}
+
+func startAnalysis(mgr multiCluster.Manager, ctx iris.Context, canaryName string, spec analysis.Spec) error {
+	if err := spec.Validate(); err != nil {
+		return customErrors.BadRequest(err.Error())
+	}
+
+	appCtx := handler.ExtractAppContext(ctx)
+	clusterName := appCtx.ClusterName
+	namespace := appCtx.KubeNamespace
+
+	canaryClient, err := mgr.DynamicClient(clusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		return customErrors.DynamicClientErr(err)
+	}
+
+	_, canarySpec, err := fetchCanary(ctx.Request().Context(), canaryClient, namespace, canaryName)
+	if err != nil {
+		logger.Errorf("fetch canary %s.%s failed err: %s", canaryName, namespace, err)
+		return err
+	}
+	if canarySpec.CanaryType != canary.CanaryTypeCanary || len(canarySpec.Services) == 0 {
+		return customErrors.BadRequest("仅金丝雀类型的灰度发布支持自动灰度")
+	}
+	if canarySpec.Policy == nil || len(canarySpec.Policy.WeightStrategy) == 0 {
+		return customErrors.BadRequest("自动灰度需要按权重分配流量的灰度规则")
+	}
+	if err := ensureNotFrozen(ctx.Request().Context(), canaryClient, namespace, canaryName); err != nil {
+		return err
+	}
+
+	p8sClient, err := prometheus.NewP8sClient(mgr, clusterName)
+	if err != nil {
+		logger.Errorf("prometheus Newclient err %v", err)
+		return customErrors.CustomClientErr("Prometheus 连接失败", err)
+	}
+
+	version := canarySpec.Services[0].Version
+	target := analysis.Target{
+		Namespace: namespace,
+		Service:   canarySpec.MicroService,
+		Version:   version,
+	}
+	executor := &canaryExecutor{
+		client:    canaryClient,
+		namespace: namespace,
+		name:      canaryName,
+		version:   version,
+		user:      handler.ExtractUserContext(ctx).Name,
+	}
+	analyzer := analysis.NewAnalyzer(spec, target, &analysis.PrometheusProber{API: p8sClient.Api}, executor)
+
+	if err := analysis.DefaultController().Start(analysis.Key(clusterName, namespace, canaryName), analyzer); err != nil {
+		return customErrors.Conflict(err.Error())
+	}
+
+	return nil
+}
+
+func StartCanaryAnalysis(mgr multiCluster.Manager, ctx iris.Context) {
+	spec := analysis.Spec{}
+	if err := ctx.ReadJSON(&spec); err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+
+	canaryName := ctx.Params().GetString("canary")
+	if err := startAnalysis(mgr, ctx, canaryName, spec); err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.SendAudit(audit.ModuleCanary, audit.ActionStartAnalysis, canaryName, ctx)
+	handler.ResponseOk(ctx, nil)
+}
+
+func GetCanaryAnalysis(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+	canaryName := ctx.Params().GetString("canary")
+
+	status, ok := analysis.DefaultController().Status(analysis.Key(appCtx.ClusterName, appCtx.KubeNamespace, canaryName))
+	if ok {
+		handler.ResponseOk(ctx, status)
+		return
+	}
+
+	canaryClient, err := mgr.DynamicClient(appCtx.ClusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+	un, err := canaryClient.Namespace(appCtx.KubeNamespace).Get(ctx.Request().Context(), canaryName, metav1.GetOptions{})
+	if err != nil {
+		logger.Errorf("get canary %s.%s failed err: %s", canaryName, appCtx.KubeNamespace, err)
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	if recorded, ok := recordedAnalysis(un.GetAnnotations()); ok {
+		handler.ResponseOk(ctx, recorded)
+		return
+	}
+
+	handler.ResponseOk(ctx, nil)
+}
+
+func StopCanaryAnalysis(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+	canaryName := ctx.Params().GetString("canary")
+
+	if !analysis.DefaultController().Stop(analysis.Key(appCtx.ClusterName, appCtx.KubeNamespace, canaryName)) {
+		handler.ResponseErr(ctx, customErrors.BadRequest(fmt.Sprintf("灰度发布任务%s未开启自动灰度", canaryName)))
+		return
+	}
+
+	handler.SendAudit(audit.ModuleCanary, audit.ActionStopAnalysis, canaryName, ctx)
+	handler.ResponseOk(ctx, nil)
+}
@@ -0,0 +1,247 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/huhenry/hej/pkg/log"
+)
+
+var logger = log.RegisterScope("canary-analysis")
+
+type Phase string
+
+const (
+	PhaseProgressing Phase = "Progressing"
+	PhaseSucceeded   Phase = "Succeeded"
+	PhaseFailed      Phase = "Failed"
+	// PhaseAwaitingApproval ends the analysis without promotion, the canary
+	// waits for a manual promotion.
+	PhaseAwaitingApproval Phase = "AwaitingApproval"
+
+	DefaultIntervalSeconds = 60
+	DefaultNoTrafficChecks = 10
+	MaxWeight              = 100
+)
+
+// Spec declares how a canary is shifted step by step and which metrics gate
+// every step.
+type Spec struct {
+	// StepWeights is the canary weight of each step, the last step is followed by promotion.
+	StepWeights []int32 `json:"stepWeights"`
+	// IntervalSeconds is the time between two checks, it is also the prometheus query window.
+	IntervalSeconds int64 `json:"interval,omitempty"`
+	// SuccessRate is the minimal percentage of non 5xx responses.
+	SuccessRate float64 `json:"successRate,omitempty"`
+	// Latency is the maximal P99 latency in milliseconds, zero disables the check.
+	Latency float64 `json:"latency,omitempty"`
+	// RequireApproval stops before promotion and waits for a manual promotion.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// NoTrafficChecks is how many checks in a row may see no traffic on the
+	// canary before the analysis fails, zero means DefaultNoTrafficChecks.
+	NoTrafficChecks int `json:"noTrafficChecks,omitempty"`
+}
+
+func (s *Spec) Interval() time.Duration {
+	if s.IntervalSeconds <= 0 {
+		return DefaultIntervalSeconds * time.Second
+	}
+	return time.Duration(s.IntervalSeconds) * time.Second
+}
+
+func (s *Spec) NoTrafficLimit() int {
+	if s.NoTrafficChecks <= 0 {
+		return DefaultNoTrafficChecks
+	}
+	return s.NoTrafficChecks
+}
+
+func (s *Spec) Validate() error {
+	if len(s.StepWeights) == 0 {
+		return fmt.Errorf("自动灰度步长不可为空")
+	}
+	var last int32 = 0
+	for _, weight := range s.StepWeights {
+		if weight <= last || weight > MaxWeight {
+			return fmt.Errorf("自动灰度步长必须递增且在1到100之间")
+		}
+		last = weight
+	}
+	if s.IntervalSeconds < 0 {
+		return fmt.Errorf("自动灰度间隔不可为负数")
+	}
+	if s.SuccessRate < 0 || s.SuccessRate > 100 {
+		return fmt.Errorf("成功率阈值必须在0到100之间")
+	}
+	if s.Latency < 0 {
+		return fmt.Errorf("延迟阈值不可为负数")
+	}
+	if s.NoTrafficChecks < 0 {
+		return fmt.Errorf("无流量检查次数不可为负数")
+	}
+	return nil
+}
+
+// Executor applies the decisions of the analysis to the canary.
+type Executor interface {
+	SetWeight(ctx context.Context, weight int32) error
+	Promote(ctx context.Context) error
+	Abort(ctx context.Context) error
+	// AwaitApproval marks the canary as waiting for a manual promotion.
+	AwaitApproval(ctx context.Context) error
+	// Paused reports whether the canary has been frozen from outside the analysis.
+	Paused(ctx context.Context) (bool, error)
+	// Record keeps the status on the canary, so it outlives the analysis loop.
+	Record(ctx context.Context, status Status) error
+}
+
+type Status struct {
+	Phase   Phase    `json:"phase"`
+	Step    int      `json:"step"`
+	Weight  int32    `json:"weight"`
+	Metrics *Metrics `json:"metrics,omitempty"`
+	// NoTrafficChecks counts the checks in a row which saw no traffic.
+	NoTrafficChecks int    `json:"noTrafficChecks,omitempty"`
+	Message         string `json:"message,omitempty"`
+	UpdatedAt       int64  `json:"updatedAt"`
+}
+
+// NewStatus returns the status of an analysis which has not shifted any traffic yet.
+func NewStatus() Status {
+	return Status{
+		Phase: PhaseProgressing,
+		Step:  -1,
+	}
+}
+
+func (s Status) Finished() bool {
+	return s.Phase == PhaseSucceeded || s.Phase == PhaseFailed || s.Phase == PhaseAwaitingApproval
+}
+
+type Analyzer struct {
+	Spec     Spec
+	Target   Target
+	Prober   Prober
+	Executor Executor
+
+	now func() time.Time
+}
+
+func NewAnalyzer(spec Spec, target Target, prober Prober, executor Executor) *Analyzer {
+	return &Analyzer{
+		Spec:     spec,
+		Target:   target,
+		Prober:   prober,
+		Executor: executor,
+		now:      time.Now,
+	}
+}
+
+// Advance runs one check of the analysis and returns the next status. The
+// first call shifts the canary to the first step, every later call probes
+// the canary metrics and either aborts, moves to the next step or promotes.
+func (a *Analyzer) Advance(ctx context.Context, status Status) (Status, error) {
+	if status.Finished() {
+		return status, nil
+	}
+	now := a.now()
+	status.UpdatedAt = now.Unix()
+
+	paused, err := a.Executor.Paused(ctx)
+	if err != nil {
+		status.Message = fmt.Sprintf("read canary phase failed: %s", err)
+		return status, err
+	}
+	if paused {
+		status.Message = "canary is paused, waiting for resume"
+		return status, nil
+	}
+
+	if status.Step < 0 {
+		weight := a.Spec.StepWeights[0]
+		if err := a.Executor.SetWeight(ctx, weight); err != nil {
+			status.Message = fmt.Sprintf("set weight %d failed: %s", weight, err)
+			return status, err
+		}
+		status.Step = 0
+		status.Weight = weight
+		status.Message = ""
+		return status, nil
+	}
+
+	metrics, err := a.Prober.Probe(ctx, a.Target, a.Spec.Interval(), now)
+	if err != nil {
+		status.Message = fmt.Sprintf("query metrics failed: %s", err)
+		return status, err
+	}
+	status.Metrics = metrics
+
+	if !metrics.HasTraffic {
+		status.NoTrafficChecks++
+		if status.NoTrafficChecks < a.Spec.NoTrafficLimit() {
+			status.Message = "no traffic on canary version, waiting for next check"
+			return status, nil
+		}
+		if err := a.Executor.Abort(ctx); err != nil {
+			status.Message = fmt.Sprintf("abort canary failed: %s", err)
+			return status, err
+		}
+		status.Phase = PhaseFailed
+		status.Weight = 0
+		status.Message = fmt.Sprintf("no traffic on canary version in %d checks", status.NoTrafficChecks)
+		return status, nil
+	}
+	status.NoTrafficChecks = 0
+
+	if reason, breached := a.breached(metrics); breached {
+		if err := a.Executor.Abort(ctx); err != nil {
+			status.Message = fmt.Sprintf("abort canary failed: %s", err)
+			return status, err
+		}
+		status.Phase = PhaseFailed
+		status.Weight = 0
+		status.Message = reason
+		return status, nil
+	}
+
+	if status.Step >= len(a.Spec.StepWeights)-1 {
+		if a.Spec.RequireApproval {
+			if err := a.Executor.AwaitApproval(ctx); err != nil {
+				status.Message = fmt.Sprintf("await approval failed: %s", err)
+				return status, err
+			}
+			status.Phase = PhaseAwaitingApproval
+			status.Message = ""
+			return status, nil
+		}
+		if err := a.Executor.Promote(ctx); err != nil {
+			status.Message = fmt.Sprintf("promote canary failed: %s", err)
+			return status, err
+		}
+		status.Phase = PhaseSucceeded
+		status.Weight = MaxWeight
+		status.Message = ""
+		return status, nil
+	}
+
+	weight := a.Spec.StepWeights[status.Step+1]
+	if err := a.Executor.SetWeight(ctx, weight); err != nil {
+		status.Message = fmt.Sprintf("set weight %d failed: %s", weight, err)
+		return status, err
+	}
+	status.Step++
+	status.Weight = weight
+	status.Message = ""
+	return status, nil
+}
+
+func (a *Analyzer) breached(metrics *Metrics) (string, bool) {
+	if metrics.SuccessRate < a.Spec.SuccessRate {
+		return fmt.Sprintf("success rate %.2f%% is below threshold %.2f%%", metrics.SuccessRate, a.Spec.SuccessRate), true
+	}
+	if a.Spec.Latency > 0 && metrics.Latency > a.Spec.Latency {
+		return fmt.Sprintf("latency %.2fms is above threshold %.2fms", metrics.Latency, a.Spec.Latency), true
+	}
+	return "", false
+}
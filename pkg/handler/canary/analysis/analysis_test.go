@@ -0,0 +1,283 @@
+package analysis_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/huhenry/hej/pkg/handler/canary/analysis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	prom_v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+type fakeExecutor struct {
+	weights  []int32
+	promoted bool
+	aborted  bool
+	awaiting bool
+	paused   bool
+
+	mu       sync.Mutex
+	recorded []Status
+}
+
+func (e *fakeExecutor) SetWeight(ctx context.Context, weight int32) error {
+	e.weights = append(e.weights, weight)
+	return nil
+}
+
+func (e *fakeExecutor) Promote(ctx context.Context) error {
+	e.promoted = true
+	return nil
+}
+
+func (e *fakeExecutor) Abort(ctx context.Context) error {
+	e.aborted = true
+	return nil
+}
+
+func (e *fakeExecutor) AwaitApproval(ctx context.Context) error {
+	e.awaiting = true
+	return nil
+}
+
+func (e *fakeExecutor) Paused(ctx context.Context) (bool, error) {
+	return e.paused, nil
+}
+
+func (e *fakeExecutor) Record(ctx context.Context, status Status) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.recorded = append(e.recorded, status)
+	return nil
+}
+
+func (e *fakeExecutor) lastRecorded() *Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.recorded) == 0 {
+		return nil
+	}
+	return &e.recorded[len(e.recorded)-1]
+}
+
+type fakeProber struct {
+	metrics *Metrics
+}
+
+func (p *fakeProber) Probe(ctx context.Context, target Target, window time.Duration, now time.Time) (*Metrics, error) {
+	return p.metrics, nil
+}
+
+type fakeAPI struct {
+	prom_v1.API
+	results map[bool]model.Value
+	queries []string
+}
+
+func (f *fakeAPI) Query(ctx context.Context, query string, ts time.Time, opts ...prom_v1.Option) (model.Value, prom_v1.Warnings, error) {
+	f.queries = append(f.queries, query)
+	return f.results[len(f.queries) == 1], nil, nil
+}
+
+var _ = Describe("Analysis", func() {
+	var (
+		ctx      context.Context
+		spec     Spec
+		prober   *fakeProber
+		executor *fakeExecutor
+		analyzer *Analyzer
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		spec = Spec{StepWeights: []int32{10, 50}, SuccessRate: 99, Latency: 500}
+		prober = &fakeProber{metrics: &Metrics{HasTraffic: true, SuccessRate: 100, Latency: 20}}
+		executor = &fakeExecutor{}
+		analyzer = NewAnalyzer(spec, Target{Namespace: "default", Service: "reviews", Version: "v2"}, prober, executor)
+	})
+
+	Describe("Spec", func() {
+		It("should reject weights which are not increasing", func() {
+			spec.StepWeights = []int32{50, 10}
+			Expect(spec.Validate()).To(HaveOccurred())
+		})
+
+		It("should reject weights above 100", func() {
+			spec.StepWeights = []int32{10, 120}
+			Expect(spec.Validate()).To(HaveOccurred())
+		})
+
+		It("should default the interval", func() {
+			Expect(spec.Validate()).NotTo(HaveOccurred())
+			Expect(spec.Interval()).To(Equal(DefaultIntervalSeconds * time.Second))
+		})
+	})
+
+	Describe("Advance", func() {
+		It("should walk through every step and promote", func() {
+			status := NewStatus()
+			for i := 0; i < 3; i++ {
+				var err error
+				status, err = analyzer.Advance(ctx, status)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(executor.weights).To(Equal([]int32{10, 50}))
+			Expect(executor.promoted).To(BeTrue())
+			Expect(status.Phase).To(Equal(PhaseSucceeded))
+			Expect(status.Weight).To(BeEquivalentTo(MaxWeight))
+		})
+
+		It("should hold the weight when the canary has no traffic", func() {
+			status, err := analyzer.Advance(ctx, NewStatus())
+			Expect(err).NotTo(HaveOccurred())
+
+			prober.metrics = &Metrics{}
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(status.Phase).To(Equal(PhaseProgressing))
+			Expect(status.Step).To(Equal(0))
+			Expect(executor.weights).To(Equal([]int32{10}))
+		})
+
+		It("should fail once the canary has no traffic for too many checks", func() {
+			analyzer.Spec.NoTrafficChecks = 2
+			status, err := analyzer.Advance(ctx, NewStatus())
+			Expect(err).NotTo(HaveOccurred())
+
+			prober.metrics = &Metrics{}
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(PhaseProgressing))
+			Expect(executor.aborted).To(BeFalse())
+
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(executor.aborted).To(BeTrue())
+			Expect(status.Phase).To(Equal(PhaseFailed))
+			Expect(status.NoTrafficChecks).To(Equal(2))
+		})
+
+		It("should reset the no traffic count when traffic comes back", func() {
+			analyzer.Spec.NoTrafficChecks = 2
+			status, err := analyzer.Advance(ctx, NewStatus())
+			Expect(err).NotTo(HaveOccurred())
+
+			prober.metrics = &Metrics{}
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.NoTrafficChecks).To(Equal(1))
+
+			prober.metrics = &Metrics{HasTraffic: true, SuccessRate: 100, Latency: 20}
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.NoTrafficChecks).To(BeZero())
+			Expect(status.Step).To(Equal(1))
+		})
+
+		It("should abort when the success rate is below threshold", func() {
+			status, err := analyzer.Advance(ctx, NewStatus())
+			Expect(err).NotTo(HaveOccurred())
+
+			prober.metrics = &Metrics{HasTraffic: true, SuccessRate: 90}
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(executor.aborted).To(BeTrue())
+			Expect(status.Phase).To(Equal(PhaseFailed))
+			Expect(status.Finished()).To(BeTrue())
+		})
+
+		It("should hold every step while the canary is paused", func() {
+			status, err := analyzer.Advance(ctx, NewStatus())
+			Expect(err).NotTo(HaveOccurred())
+
+			executor.paused = true
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(status.Phase).To(Equal(PhaseProgressing))
+			Expect(status.Step).To(Equal(0))
+			Expect(executor.weights).To(Equal([]int32{10}))
+		})
+
+		It("should wait for approval instead of promoting", func() {
+			analyzer.Spec.RequireApproval = true
+			status := NewStatus()
+			for i := 0; i < 3; i++ {
+				var err error
+				status, err = analyzer.Advance(ctx, status)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(executor.awaiting).To(BeTrue())
+			Expect(executor.promoted).To(BeFalse())
+			Expect(status.Phase).To(Equal(PhaseAwaitingApproval))
+			Expect(status.Finished()).To(BeTrue())
+		})
+
+		It("should abort when the latency is above threshold", func() {
+			status, err := analyzer.Advance(ctx, NewStatus())
+			Expect(err).NotTo(HaveOccurred())
+
+			prober.metrics = &Metrics{HasTraffic: true, SuccessRate: 100, Latency: 800}
+			status, err = analyzer.Advance(ctx, status)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(executor.aborted).To(BeTrue())
+			Expect(status.Phase).To(Equal(PhaseFailed))
+		})
+	})
+
+	Describe("Controller", func() {
+		It("should record the status and drop the run once finished", func() {
+			analyzer.Spec.StepWeights = []int32{50}
+			analyzer.Spec.IntervalSeconds = 1
+			controller := NewController()
+			key := Key("cluster", "default", "reviews")
+
+			Expect(controller.Start(key, analyzer)).To(Succeed())
+			Eventually(func() bool {
+				_, ok := controller.Status(key)
+				return ok
+			}, 5*time.Second).Should(BeFalse())
+
+			recorded := executor.lastRecorded()
+			Expect(recorded).NotTo(BeNil())
+			Expect(recorded.Phase).To(Equal(PhaseSucceeded))
+		})
+	})
+
+	Describe("PrometheusProber", func() {
+		It("should read success rate and latency", func() {
+			api := &fakeAPI{results: map[bool]model.Value{
+				true:  model.Vector{&model.Sample{Value: 99.5}},
+				false: model.Vector{&model.Sample{Value: 120}},
+			}}
+			prober := &PrometheusProber{API: api}
+
+			metrics, err := prober.Probe(ctx, Target{Namespace: "default", Service: "reviews", Version: "v2"}, time.Minute, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metrics.HasTraffic).To(BeTrue())
+			Expect(metrics.SuccessRate).To(Equal(99.5))
+			Expect(metrics.Latency).To(Equal(120.0))
+			Expect(api.queries[0]).To(ContainSubstring(`destination_version="v2"`))
+		})
+
+		It("should report no traffic on empty result", func() {
+			api := &fakeAPI{results: map[bool]model.Value{true: model.Vector{}}}
+			prober := &PrometheusProber{API: api}
+
+			metrics, err := prober.Probe(ctx, Target{Namespace: "default", Service: "reviews", Version: "v2"}, time.Minute, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metrics.HasTraffic).To(BeFalse())
+			Expect(api.queries).To(HaveLen(1))
+		})
+	})
+})
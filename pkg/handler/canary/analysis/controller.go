@@ -0,0 +1,132 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var defaultController = NewController()
+
+// DefaultController returns the controller shared by all canary handlers.
+func DefaultController() *Controller {
+	return defaultController
+}
+
+type run struct {
+	analyzer *Analyzer
+	status   Status
+	cancel   context.CancelFunc
+}
+
+// Controller drives one analysis loop per canary, keyed by cluster, namespace
+// and name. A run is dropped once it is finished, its last status is kept on
+// the canary by the executor.
+type Controller struct {
+	mu   sync.Mutex
+	runs map[string]*run
+}
+
+func NewController() *Controller {
+	return &Controller{
+		runs: make(map[string]*run),
+	}
+}
+
+func Key(cluster, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", cluster, namespace, name)
+}
+
+func (c *Controller) Start(key string, analyzer *Analyzer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.runs[key]; ok && !r.status.Finished() {
+		return fmt.Errorf("灰度发布任务已在自动灰度中")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{
+		analyzer: analyzer,
+		status:   NewStatus(),
+		cancel:   cancel,
+	}
+	c.runs[key] = r
+
+	go c.loop(ctx, key, r)
+	return nil
+}
+
+// Stop cancels the analysis loop, the canary keeps its current weight.
+func (c *Controller) Stop(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.runs[key]
+	if !ok {
+		return false
+	}
+	r.cancel()
+	delete(c.runs, key)
+	return true
+}
+
+func (c *Controller) Status(key string) (Status, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.runs[key]
+	if !ok {
+		return Status{}, false
+	}
+	return r.status, true
+}
+
+func (c *Controller) loop(ctx context.Context, key string, r *run) {
+	ticker := time.NewTicker(r.analyzer.Spec.Interval())
+	defer ticker.Stop()
+
+	for {
+		if finished := c.advance(ctx, key, r); finished {
+			r.cancel()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Controller) advance(ctx context.Context, key string, r *run) bool {
+	c.mu.Lock()
+	current := r.status
+	c.mu.Unlock()
+
+	next, err := r.analyzer.Advance(ctx, current)
+	if err != nil {
+		logger.Errorf("canary %s analysis step failed: %s", key, err)
+	}
+	if ctx.Err() == nil {
+		if err := r.analyzer.Executor.Record(ctx, next); err != nil {
+			logger.Warnf("record canary %s analysis status failed: %s", key, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctx.Err() != nil {
+		return true
+	}
+	r.status = next
+	if next.Finished() {
+		logger.Infof("canary %s analysis finished with %s %s", key, next.Phase, next.Message)
+		if c.runs[key] == r {
+			delete(c.runs, key)
+		}
+	}
+	return next.Finished()
+}
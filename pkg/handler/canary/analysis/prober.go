@@ -0,0 +1,96 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	prom_v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	successRateQuery = `sum(rate(istio_requests_total{%s,response_code!~"5.*"}[%s])) / sum(rate(istio_requests_total{%s}[%s])) * 100`
+	latencyQuery     = `histogram_quantile(0.99, sum(rate(istio_request_duration_milliseconds_bucket{%s}[%s])) by (le))`
+)
+
+// Target identifies the canary version whose inbound traffic is analysed.
+type Target struct {
+	Namespace string
+	Service   string
+	Version   string
+}
+
+func (t Target) selector() string {
+	return fmt.Sprintf(`reporter="destination",destination_workload_namespace="%s",destination_service_name="%s",destination_version="%s"`,
+		t.Namespace, t.Service, t.Version)
+}
+
+type Metrics struct {
+	HasTraffic  bool    `json:"hasTraffic"`
+	SuccessRate float64 `json:"successRate"`
+	Latency     float64 `json:"latency"`
+}
+
+type Prober interface {
+	Probe(ctx context.Context, target Target, window time.Duration, now time.Time) (*Metrics, error)
+}
+
+// PrometheusProber reads the istio standard metrics, the same data the canary
+// metrics summary is built from.
+type PrometheusProber struct {
+	API prom_v1.API
+}
+
+func (p *PrometheusProber) Probe(ctx context.Context, target Target, window time.Duration, now time.Time) (*Metrics, error) {
+	selector := target.selector()
+	rangeStr := model.Duration(window).String()
+
+	successRate, ok, err := p.scalar(ctx, fmt.Sprintf(successRateQuery, selector, rangeStr, selector, rangeStr), now)
+	if err != nil {
+		return nil, err
+	}
+	metrics := &Metrics{}
+	if !ok {
+		return metrics, nil
+	}
+	metrics.HasTraffic = true
+	metrics.SuccessRate = successRate
+
+	latency, ok, err := p.scalar(ctx, fmt.Sprintf(latencyQuery, selector, rangeStr), now)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		metrics.Latency = latency
+	}
+
+	return metrics, nil
+}
+
+// scalar returns the first sample of an instant query, the boolean is false
+// when prometheus has no sample or the sample is not a number.
+func (p *PrometheusProber) scalar(ctx context.Context, query string, ts time.Time) (float64, bool, error) {
+	value, warnings, err := p.API.Query(ctx, query, ts)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(warnings) > 0 {
+		logger.Warnf("query %s warnings: %v", query, warnings)
+	}
+
+	vector, ok := value.(model.Vector)
+	if !ok {
+		return 0, false, fmt.Errorf("unexpected result type %s for query %s", value.Type(), query)
+	}
+	if len(vector) == 0 {
+		return 0, false, nil
+	}
+	sample := float64(vector[0].Value)
+	if math.IsNaN(sample) || math.IsInf(sample, 0) {
+		return 0, false, nil
+	}
+
+	return sample, true, nil
+}
@@ -0,0 +1,48 @@
+package canary
+
+import (
+	"fmt"
+
+	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/kataras/iris/v12"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	QueryParameterDryRun = "dryRun"
+)
+
+// DryRunResult is the answer of a canary creation which is not persisted:
+// the validation failures and the canary resource which would be created.
+// The routing objects are rendered by the canary controller once the canary
+// exists, they are not previewed here.
+type DryRunResult struct {
+	Valid  bool                       `json:"valid"`
+	Errors handler.FieldErrors        `json:"errors,omitempty"`
+	Canary *unstructured.Unstructured `json:"canary,omitempty"`
+}
+
+func dryRunCanary(mgr multiCluster.Manager, ctx iris.Context, creation *CanaryCreation, errs handler.FieldErrors) {
+	appCtx := handler.ExtractAppContext(ctx)
+	namespace := appCtx.KubeNamespace
+
+	result := &DryRunResult{
+		Valid:  len(errs) == 0,
+		Errors: errs,
+	}
+
+	cr, err := canary.RenderCanary(namespace, &creation.CanarySpec)
+	if err != nil {
+		logger.Errorf("render canary %s.%s failed err: %s", creation.Name, namespace, err)
+		result.Valid = false
+		result.Errors = append(result.Errors, handler.FieldError{Message: fmt.Sprintf("灰度发布任务%s渲染失败: %s", creation.Name, err)})
+		handler.ResponseOk(ctx, result)
+		return
+	}
+	cr.SetAnnotations(map[string]string{PhaseAnnotation: string(PhaseProgressing)})
+	result.Canary = cr
+
+	handler.ResponseOk(ctx, result)
+}
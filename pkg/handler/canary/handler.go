@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
 
 	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/handler/canary/analysis"
+	"github.com/huhenry/hej/pkg/handler/microapp/filter"
 	microappcommon "github.com/huhenry/hej/pkg/microapp/common"
 
 	microV1beta1 "github.com/huhenry/hej/pkg/microapp/v1beta1"
@@ -27,6 +30,7 @@ import (
 	"github.com/huhenry/hej/pkg/multiCluster"
 	"github.com/kataras/iris/v12"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 
 	"github.com/huhenry/hej/pkg/log"
 
@@ -66,90 +70,41 @@ type ValidResult struct {
 	Message string `json:"message,omitempty"`
 }
 
-func valideCanary(canarySpec *canary.CanarySpec) error {
-	if canarySpec.Services == nil || len(canarySpec.Services) == 0 {
-
-		err := fmt.Errorf("灰度发布任务%s的灰度版本不可为空", canarySpec.Name)
-
-		return err
-	}
-	for _, serviceRef := range canarySpec.Services {
-		if !handler.IsDNS1123Label(serviceRef.Version) {
-			err := fmt.Errorf("灰度发布任务%s的灰度版本%s不是有效的版本号", canarySpec.Name, serviceRef.Version)
-
-			return err
-		}
-
-		if serviceRef.CopiedTarget != nil && *serviceRef.CopiedTarget.Replicas == 0 {
-			err := fmt.Errorf("灰度发布任务%s工作负载副本数不可为0", canarySpec.Name)
-
-			return err
-
-		}
-		if serviceRef.SelectedTarget != nil && *serviceRef.SelectedTarget.Replicas == 0 {
-			err := fmt.Errorf("灰度发布任务%s工作负载副本数不可为0", canarySpec.Name)
-
-			return err
-
-		}
-	}
-
-	return ValidePolicy(canarySpec.Policy)
-
+type CanaryCreation struct {
+	canary.CanarySpec
+	// Analysis starts a progressive rollout right after the canary is created.
+	Analysis *analysis.Spec `json:"analysis,omitempty"`
 }
 
 func CreateCanary(mgr multiCluster.Manager, ctx iris.Context) {
 
-	canarySpec := &canary.CanarySpec{}
-	err := ctx.ReadJSON(canarySpec)
+	creation := &CanaryCreation{}
+	canarySpec := &creation.CanarySpec
+	err := ctx.ReadJSON(creation)
 	if err != nil {
 
 		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
 		return
 	}
 
-	if err := valideCanary(canarySpec); err != nil {
-
-		//logger.Error(err)
+	microapp := ctx.Params().GetString("application")
+	canarySpec.MicroApplication = microapp
 
-		handler.RespondWithError(ctx, customErrors.StatusCodeHTTPRequestErrorCode, err)
+	errs := validateCanaryCreation(mgr, ctx, creation)
+	if ctx.URLParamBoolDefault(QueryParameterDryRun, false) {
+		dryRunCanary(mgr, ctx, creation, errs)
 		return
 	}
-
-	if valid := microServiceValidation(mgr, ctx, canarySpec.MicroService); valid != nil && !valid.Valid {
-
-		handler.RespondWithError(ctx, customErrors.StatusCodeHTTPRequestErrorCode, fmt.Errorf(valid.Message))
+	if len(errs) > 0 {
+		handler.ResponseFieldErrors(ctx, customErrors.StatusCodeHTTPRequestErrorCode, errs)
 		return
 	}
 
-	for _, serviceRef := range canarySpec.Services {
-		if serviceRef.SelectedTarget != nil && serviceRef.SelectedTarget.Workload != nil {
-
-			if valid := workloadValidation(mgr, ctx, serviceRef.SelectedTarget.Workload.Name, canarySpec.MicroService); valid != nil && !valid.Valid {
-				handler.RespondWithError(ctx, customErrors.StatusCodeHTTPRequestErrorCode, fmt.Errorf(valid.Message))
-				return
-			}
-		}
-
-	}
-
-	ms, err := fetchMicroservice(mgr, ctx, canarySpec.MicroService)
-	if err != nil {
-		handler.RespondWithError(ctx, customErrors.StatusCodeHTTPRequestErrorCode, fmt.Errorf("服务%s不存在", canarySpec.MicroService))
-		return
-	}
-
-	if err := valideFailedStatus(ms, canarySpec); err != nil {
-		handler.RespondWithError(ctx, customErrors.StatusCodeHTTPRequestErrorCode, fmt.Errorf(err.Error()))
-		return
-	}
 	appCtx := handler.ExtractAppContext(ctx)
 	userCtx := handler.ExtractUserContext(ctx)
 
 	clusterName := appCtx.ClusterName
 	namespace := appCtx.KubeNamespace
-	microapp := ctx.Params().GetString("application")
-	canarySpec.MicroApplication = microapp
 
 	canaryClient, err := mgr.DynamicClient(clusterName, canaryGVK)
 	if err != nil {
@@ -168,29 +123,81 @@ func CreateCanary(mgr multiCluster.Manager, ctx iris.Context) {
 		return
 	}
 
+	if canarySpec.Policy != nil {
+		logRevision(ctx.Request().Context(), canaryClient, namespace, canarySpec.Name, userCtx.Name, nil, *canarySpec.Policy)
+	}
+
 	handler.SendAudit(audit.ModuleCanary, audit.ActionCreate, canarySpec.Name, ctx)
 
+	if creation.Analysis != nil {
+		if err := startAnalysis(mgr, ctx, canarySpec.Name, *creation.Analysis); err != nil {
+			logger.Errorf("start analysis for canary %s failed err: %s", canarySpec.Name, err)
+
+			handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("灰度任务已创建，自动灰度开启失败！", err))
+			return
+		}
+		handler.SendAudit(audit.ModuleCanary, audit.ActionStartAnalysis, canarySpec.Name, ctx)
+	}
+
 	handler.ResponseOk(ctx, nil)
 
 }
 
-func valideFailedStatus(ms *microV1beta1.MicroService, cay *canary.CanarySpec) error {
+// validateCanaryCreation runs every check of the canary creation and returns
+// all failures, checks depending on the microservice are skipped when it does
+// not exist.
+func validateCanaryCreation(mgr multiCluster.Manager, ctx iris.Context, creation *CanaryCreation) handler.FieldErrors {
+	canarySpec := &creation.CanarySpec
+	errs := ValidateCanary(canarySpec)
+
+	if creation.Analysis != nil {
+		if err := creation.Analysis.Validate(); err != nil {
+			errs = append(errs, handler.FieldError{Field: "analysis", Message: err.Error()})
+		}
+	}
+
+	if valid := microServiceValidation(mgr, ctx, canarySpec.MicroService); valid != nil && !valid.Valid {
+		errs = append(errs, handler.FieldError{Field: "microService", Message: valid.Message})
+	}
+
+	for i, serviceRef := range canarySpec.Services {
+		if serviceRef.SelectedTarget != nil && serviceRef.SelectedTarget.Workload != nil {
+
+			if valid := workloadValidation(mgr, ctx, serviceRef.SelectedTarget.Workload.Name, canarySpec.MicroService); valid != nil && !valid.Valid {
+				errs = append(errs, handler.FieldError{Field: fmt.Sprintf("services[%d].selectedTarget.workload", i), Message: valid.Message})
+			}
+		}
+
+	}
+
+	ms, err := fetchMicroservice(mgr, ctx, canarySpec.MicroService)
+	if err != nil {
+		return append(errs, handler.FieldError{Field: "microService", Message: fmt.Sprintf("服务%s不存在", canarySpec.MicroService)})
+	}
+
+	return append(errs, valideFailedStatus(ms, canarySpec)...)
+}
+
+// valideFailedStatus returns every check of the canary which the protocols of
+// the microservice do not support.
+func valideFailedStatus(ms *microV1beta1.MicroService, cay *canary.CanarySpec) handler.FieldErrors {
+	errs := handler.FieldErrors{}
 
 	//udp check
 	if _, isAllUdp := microappcommon.CovertPorts(ms.Spec.Ports); isAllUdp {
-		return fmt.Errorf("灰度发布暂不支持UDP协议的服务")
+		errs = append(errs, handler.FieldError{Field: "microService", Message: "灰度发布暂不支持UDP协议的服务"})
 	}
 
 	//noHttp mirroing check
 	if cay.CanaryType == canary.CanaryTypeMirroring && !ms.IsHaveHTTP() {
-		return fmt.Errorf("镜像流量类型的灰度发布，仅支持端口协议为http、http2、grpc的服务")
+		errs = append(errs, handler.FieldError{Field: "canaryType", Message: "镜像流量类型的灰度发布，仅支持端口协议为http、http2、grpc的服务"})
 	}
 
 	//noHttp requestHeader check
 	if cay.Policy != nil && cay.Policy.RequestStrategy != nil && (!ms.IsHaveHTTP() || ms.IsGrpcNoHttp()) && cay.CanaryType == canary.CanaryTypeCanary {
-		return fmt.Errorf("金丝雀类型的灰度发布，按请求内容分配流量，仅支持端口协议为http、http2的服务")
+		errs = append(errs, handler.FieldError{Field: "policy.requestStrategy", Message: "金丝雀类型的灰度发布，按请求内容分配流量，仅支持端口协议为http、http2的服务"})
 	}
-	return nil
+	return errs
 }
 
 type ParamsOptions struct {
@@ -233,6 +240,14 @@ func ListCanary(mgr multiCluster.Manager, ctx iris.Context) {
 
 	}
 
+	crs := map[string]*unstructured.Unstructured{}
+	listSchema := canaryListSchema(crs)
+	query, err := filter.ParserParams(ctx, listSchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+
 	selectorlabel := make(map[string]string)
 	selectorlabel[define.LabelTpaasApp] = strconv.FormatInt(appCtx.AppId, 10)
 	if len(microapp) > 0 {
@@ -240,19 +255,21 @@ func ListCanary(mgr multiCluster.Manager, ctx iris.Context) {
 		selectorlabel[common.LabelMicroApplicationKey] = microapp
 	}
 
+	// status is the lifecycle phase, the query filters it
 	listOptions := canary.ParamsOptions{
-		Name:         ctx.URLParam("name"),
 		CanaryType:   ctx.URLParam("canarytype"),
 		MicroApp:     ctx.URLParam("microapp"),
 		MicroService: ctx.URLParam("microservice"),
-		Status:       ctx.URLParam("status"),
 		ListOptions: metav1.ListOptions{
 
 			LabelSelector: labels.Set(selectorlabel).String(),
 		},
 	}
 
-	list, err := canary.ListCanary(ctx.Request().Context(), canaryClient, microServiceClient, namespace, listOptions)
+	// the list items carry neither labels nor phase, they are read from the
+	// canaries the items are built from
+	recorder := &listRecorder{NamespaceableResourceInterface: canaryClient}
+	list, err := canary.ListCanary(ctx.Request().Context(), recorder, microServiceClient, namespace, listOptions)
 	if err != nil {
 
 		logger.Errorf("ListCanary %s,%s, err: %s", microapp, namespace, err)
@@ -260,11 +277,86 @@ func ListCanary(mgr multiCluster.Manager, ctx iris.Context) {
 		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("获取灰度列表失败！", err))
 		return
 	}
+	for _, un := range recorder.listed {
+		crs[un.GetName()] = un
+	}
+
+	items := make([]map[string]interface{}, 0)
+	if err := common.JsonConvert(list, &items); err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	data := make([]interface{}, 0, len(items))
+	for i := range items {
+		data = append(data, items[i])
+	}
 
-	handler.ResponseOk(ctx, list)
+	handler.ResponseOk(ctx, query.Apply(listSchema, data))
 
 }
 
+// canaryListSchema reads the fields of the items of ListCanary, which are
+// json objects named after their canary, the rest comes from the canaries.
+func canaryListSchema(crs map[string]*unstructured.Unstructured) filter.Schema {
+	cr := func(item interface{}) *unstructured.Unstructured {
+		name, _ := item.(map[string]interface{})["name"].(string)
+		return crs[name]
+	}
+	return filter.Schema{
+		filter.FieldName: func(item interface{}) interface{} {
+			return item.(map[string]interface{})["name"]
+		},
+		filter.FieldCreateTime: func(item interface{}) interface{} {
+			if un := cr(item); un != nil {
+				return un.GetCreationTimestamp().Unix()
+			}
+			return nil
+		},
+		filter.FieldLabels: func(item interface{}) interface{} {
+			if un := cr(item); un != nil {
+				return un.GetLabels()
+			}
+			return nil
+		},
+		filter.FieldStatus: func(item interface{}) interface{} {
+			if un := cr(item); un != nil {
+				return string(canaryPhase(un.GetAnnotations()))
+			}
+			return nil
+		},
+	}
+}
+
+// listRecorder keeps the objects listed through the client, so a list built
+// by another package can be joined with the objects it was built from.
+type listRecorder struct {
+	dynamic.NamespaceableResourceInterface
+	mu     sync.Mutex
+	listed []*unstructured.Unstructured
+}
+
+func (r *listRecorder) Namespace(namespace string) dynamic.ResourceInterface {
+	return &recordingResource{ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace), recorder: r}
+}
+
+type recordingResource struct {
+	dynamic.ResourceInterface
+	recorder *listRecorder
+}
+
+func (r *recordingResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.ResourceInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	for i := range list.Items {
+		r.recorder.listed = append(r.recorder.listed, &list.Items[i])
+	}
+	return list, nil
+}
+
 func DeleteCanary(mgr multiCluster.Manager, ctx iris.Context) {
 
 	appCtx := handler.ExtractAppContext(ctx)
@@ -383,9 +475,9 @@ func UpdateCanaryPolicy(mgr multiCluster.Manager, ctx iris.Context) {
 		return
 	}
 
-	if err = ValidePolicy(policy); err != nil {
+	if errs := ValidatePolicy(policy); len(errs) > 0 {
 
-		handler.RespondWithError(ctx, customErrors.StatusCodeHTTPRequestErrorCode, err)
+		handler.ResponseFieldErrors(ctx, customErrors.StatusCodeHTTPRequestErrorCode, errs)
 		return
 
 	}
@@ -414,63 +506,33 @@ func UpdateCanaryPolicy(mgr multiCluster.Manager, ctx iris.Context) {
 
 	}
 
-	err = canary.UpdateCanaryPolicy(ctx.Request().Context(), canaryClient, namespace, canaryName, *policy)
-	if err != nil {
-
-		logger.Errorf("GetCanaryPolicy %s.%s failed err: %s", canaryName, namespace, err)
+	if err := ApplyCanaryPolicy(ctx.Request().Context(), canaryClient, namespace, canaryName, *policy, ctx); err != nil {
+		logger.Errorf("UpdateCanaryPolicy %s.%s failed err: %s", canaryName, namespace, err)
 
 		handler.ResponseErr(ctx, err)
 		return
 	}
 
-	handler.SendAudit(audit.ModuleCanary, audit.ActionUpdate, canaryName, ctx)
-
 	handler.ResponseOk(ctx, nil)
 
 }
 
-func ValidePolicy(policy *canary.CanaryPolicy) error {
-	if policy == nil || (len(policy.WeightStrategy) == 0 && len(policy.RequestStrategy) == 0 && len(policy.TrafficMirroring) == 0) {
-		err := fmt.Errorf("缺少灰度规则设置.")
-
+// ApplyCanaryPolicy replaces the policy of a running canary: it refuses a
+// frozen canary, records the revision and audits the change. The console and
+// the import of a bundle both go through it.
+func ApplyCanaryPolicy(c context.Context, canaryClient dynamic.NamespaceableResourceInterface, namespace, canaryName string, policy canary.CanaryPolicy, ctx iris.Context) error {
+	if err := ensureNotFrozen(c, canaryClient, namespace, canaryName); err != nil {
 		return err
 	}
 
-	if len(policy.RequestStrategy) > 0 {
-		for _, requestHead := range policy.RequestStrategy {
-			for _, cookie := range requestHead.HttpCookies {
-				if len(cookie.MatchValue) == 0 || len(cookie.MatchType) == 0 {
-					err := fmt.Errorf("缺少灰度规则设置.")
-
-					return err
-
-				}
-			}
-
-			for _, header := range requestHead.HttpHeader {
-				if len(header.MatchValue) == 0 || len(header.MatchType) == 0 {
-
-					err := fmt.Errorf("缺少灰度规则设置.")
-
-					return err
-				}
-
-			}
-		}
-	}
-	if len(policy.WeightStrategy) > 0 {
-		var totalWeight int32 = 0
-		for _, weightPolicy := range policy.WeightStrategy {
-			totalWeight = totalWeight + weightPolicy.Weight
-		}
-
-		if totalWeight != 100 {
-			err := fmt.Errorf("流量规则比重大于或者是不等于100.")
-
-			return err
-		}
+	previous, err := updateCanaryPolicy(c, canaryClient, namespace, canaryName, handler.ExtractUserContext(ctx).Name, func(*canary.CanarySpec) (canary.CanaryPolicy, error) {
+		return policy, nil
+	})
+	if err != nil {
+		return err
 	}
 
+	handler.SendAuditWithDiff(audit.ModuleCanary, audit.ActionUpdate, canaryName, previous, &policy, ctx)
 	return nil
 }
 
@@ -673,6 +735,8 @@ func TakeOverAllTraffic(mgr multiCluster.Manager, ctx iris.Context) {
 		handler.ResponseErr(ctx, err)
 		return
 	}
+	analysis.DefaultController().Stop(analysis.Key(clusterName, namespace, canaryName))
+	markCanaryPhase(ctx.Request().Context(), canaryClient, namespace, canaryName, PhasePromoted)
 
 	handler.SendAudit(audit.ModuleCanary, audit.ActionUpdate, canaryName, ctx)
 
@@ -706,6 +770,8 @@ func GoOffline(mgr multiCluster.Manager, ctx iris.Context) {
 		handler.ResponseErr(ctx, err)
 		return
 	}
+	analysis.DefaultController().Stop(analysis.Key(clusterName, namespace, canaryName))
+	markCanaryPhase(ctx.Request().Context(), canaryClient, namespace, canaryName, PhaseAborted)
 
 	handler.SendAudit(audit.ModuleCanary, audit.ActionGOOFFLINE, canaryName, ctx)
 
@@ -0,0 +1,292 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/huhenry/hej/pkg/canary"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/canary/analysis"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/kataras/iris/v12"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+// PhaseAnnotation keeps the lifecycle phase on the canary resource, so every
+// replica of hej and the analysis loop see the same state.
+const PhaseAnnotation = "tpaas.troila.com/canary.phase"
+
+// PausedFromAnnotation keeps the phase a paused canary returns to on resume.
+const PausedFromAnnotation = "tpaas.troila.com/canary.paused-from"
+
+type CanaryPhase string
+
+const (
+	PhaseProgressing      CanaryPhase = "Progressing"
+	PhasePaused           CanaryPhase = "Paused"
+	PhaseAwaitingApproval CanaryPhase = "AwaitingApproval"
+	PhasePromoted         CanaryPhase = "Promoted"
+	PhaseAborted          CanaryPhase = "Aborted"
+)
+
+var phaseNames = map[CanaryPhase]string{
+	PhaseProgressing:      "灰度中",
+	PhasePaused:           "已暂停",
+	PhaseAwaitingApproval: "待确认",
+	PhasePromoted:         "已全量",
+	PhaseAborted:          "已下线",
+}
+
+// phaseTransitions lists the phases reachable from each phase, Promoted and
+// Aborted are final.
+var phaseTransitions = map[CanaryPhase][]CanaryPhase{
+	PhaseProgressing:      {PhasePaused, PhaseAwaitingApproval, PhasePromoted, PhaseAborted},
+	PhasePaused:           {PhaseProgressing, PhasePromoted, PhaseAborted},
+	PhaseAwaitingApproval: {PhasePaused, PhasePromoted, PhaseAborted},
+}
+
+func canTransit(from, to CanaryPhase) bool {
+	for _, phase := range phaseTransitions[from] {
+		if phase == to {
+			return true
+		}
+	}
+	return false
+}
+
+// canaryPhase reads the phase of a canary, canaries created before the phase
+// was introduced are considered progressing.
+func canaryPhase(annotations map[string]string) CanaryPhase {
+	if phase, ok := annotations[PhaseAnnotation]; ok && len(phase) > 0 {
+		return CanaryPhase(phase)
+	}
+	return PhaseProgressing
+}
+
+// IsActive tells whether the canary still runs, a promoted or aborted canary
+// is kept for its history only.
+func IsActive(annotations map[string]string) bool {
+	_, ok := phaseTransitions[canaryPhase(annotations)]
+	return ok
+}
+
+func getCanaryPhase(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) (CanaryPhase, error) {
+	un, err := client.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return canaryPhase(un.GetAnnotations()), nil
+}
+
+// transitCanaryPhase moves the canary to the given phase, it fails with a
+// conflict when the transition is not allowed from the current phase.
+func transitCanaryPhase(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string, to CanaryPhase) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		un, err := client.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := un.GetAnnotations()
+		from := canaryPhase(annotations)
+		if from == to {
+			return nil
+		}
+		if !canTransit(from, to) {
+			return customErrors.Conflict(fmt.Sprintf("灰度发布任务%s当前状态为%s，不可变更为%s", name, phaseNames[from], phaseNames[to]))
+		}
+
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[PhaseAnnotation] = string(to)
+		if to == PhasePaused {
+			annotations[PausedFromAnnotation] = string(from)
+		} else {
+			delete(annotations, PausedFromAnnotation)
+		}
+		un.SetAnnotations(annotations)
+
+		_, err = client.Namespace(namespace).Update(ctx, un, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// resumeCanaryPhase moves a paused canary back to the phase it was paused
+// from, canaries paused before that phase was kept resume to progressing.
+func resumeCanaryPhase(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		un, err := client.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := un.GetAnnotations()
+		if from := canaryPhase(annotations); from != PhasePaused {
+			return customErrors.Conflict(fmt.Sprintf("灰度发布任务%s当前状态为%s，不可恢复", name, phaseNames[from]))
+		}
+
+		to := CanaryPhase(annotations[PausedFromAnnotation])
+		if _, ok := phaseTransitions[to]; !ok || to == PhasePaused {
+			to = PhaseProgressing
+		}
+		annotations[PhaseAnnotation] = string(to)
+		delete(annotations, PausedFromAnnotation)
+		un.SetAnnotations(annotations)
+
+		_, err = client.Namespace(namespace).Update(ctx, un, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// ensureNotFrozen rejects traffic changes on canaries which are paused or finished.
+func ensureNotFrozen(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) error {
+	phase, err := getCanaryPhase(ctx, client, namespace, name)
+	if err != nil {
+		return err
+	}
+	return frozenErr(name, phase)
+}
+
+// FrozenErr tells why the traffic of the canary cannot be changed, nil when
+// it can.
+func FrozenErr(name string, annotations map[string]string) error {
+	return frozenErr(name, canaryPhase(annotations))
+}
+
+func frozenErr(name string, phase CanaryPhase) error {
+	switch phase {
+	case PhasePaused, PhasePromoted, PhaseAborted:
+		return customErrors.Conflict(fmt.Sprintf("灰度发布任务%s%s，不可调整流量", name, phaseNames[phase]))
+	}
+	return nil
+}
+
+type phaseTransit func(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) error
+
+func transitPhase(mgr multiCluster.Manager, ctx iris.Context, transit phaseTransit, action string) {
+	appCtx := handler.ExtractAppContext(ctx)
+
+	clusterName := appCtx.ClusterName
+	namespace := appCtx.KubeNamespace
+	canaryName := ctx.Params().GetString("canary")
+
+	canaryClient, err := mgr.DynamicClient(clusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+
+	if err := transit(ctx.Request().Context(), canaryClient, namespace, canaryName); err != nil {
+		logger.Errorf("transit canary %s.%s phase failed err: %s", canaryName, namespace, err)
+
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.SendAudit(audit.ModuleCanary, action, canaryName, ctx)
+	handler.ResponseOk(ctx, nil)
+}
+
+type PhaseResult struct {
+	Phase CanaryPhase `json:"phase"`
+}
+
+func GetCanaryPhase(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+	canaryName := ctx.Params().GetString("canary")
+
+	canaryClient, err := mgr.DynamicClient(appCtx.ClusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+
+	phase, err := getCanaryPhase(ctx.Request().Context(), canaryClient, appCtx.KubeNamespace, canaryName)
+	if err != nil {
+		logger.Errorf("get canary %s.%s phase failed err: %s", canaryName, appCtx.KubeNamespace, err)
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, &PhaseResult{Phase: phase})
+}
+
+// PauseCanary freezes the canary at its current traffic split, the policy and
+// the automated analysis are held until the canary is resumed.
+func PauseCanary(mgr multiCluster.Manager, ctx iris.Context) {
+	transitPhase(mgr, ctx, func(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) error {
+		return transitCanaryPhase(ctx, client, namespace, name, PhasePaused)
+	}, audit.ActionPause)
+}
+
+// ResumeCanary returns the canary to the phase it was paused from, so a
+// canary paused while awaiting approval awaits it again.
+func ResumeCanary(mgr multiCluster.Manager, ctx iris.Context) {
+	transitPhase(mgr, ctx, resumeCanaryPhase, audit.ActionResume)
+}
+
+// PromoteCanary shifts all traffic to the canary version, it is the manual
+// gate of canaries awaiting approval.
+func PromoteCanary(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+
+	clusterName := appCtx.ClusterName
+	namespace := appCtx.KubeNamespace
+	canaryName := ctx.Params().GetString("canary")
+	version := ctx.URLParam(PathParameterVersion)
+
+	canaryClient, err := mgr.DynamicClient(clusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+
+	un, spec, err := fetchCanary(ctx.Request().Context(), canaryClient, namespace, canaryName)
+	if err != nil {
+		logger.Errorf("fetch canary %s.%s failed err: %s", canaryName, namespace, err)
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	if phase := canaryPhase(un.GetAnnotations()); !canTransit(phase, PhasePromoted) {
+		handler.ResponseErr(ctx, customErrors.Conflict(fmt.Sprintf("灰度发布任务%s%s，不可全量发布", canaryName, phaseNames[phase])))
+		return
+	}
+	if len(version) == 0 {
+		if len(spec.Services) == 0 {
+			handler.ResponseErr(ctx, customErrors.BadRequest(fmt.Sprintf("灰度发布任务%s的灰度版本不可为空", canaryName)))
+			return
+		}
+		version = spec.Services[0].Version
+	}
+
+	if err := canary.TakeOverAllTraffic(ctx.Request().Context(), canaryClient, namespace, canaryName, version); err != nil {
+		logger.Errorf("TakeOverAllTraffic %s.%s failed err: %s", canaryName, namespace, err)
+
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	analysis.DefaultController().Stop(analysis.Key(clusterName, namespace, canaryName))
+
+	if err := transitCanaryPhase(ctx.Request().Context(), canaryClient, namespace, canaryName, PhasePromoted); err != nil {
+		logger.Errorf("transit canary %s.%s to %s failed err: %s", canaryName, namespace, PhasePromoted, err)
+	}
+
+	handler.SendAudit(audit.ModuleCanary, audit.ActionPromote, canaryName, ctx)
+	handler.ResponseOk(ctx, nil)
+}
+
+// markCanaryPhase records a phase reached as a side effect of another verb,
+// a failure is only logged since the traffic has already been changed.
+func markCanaryPhase(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string, to CanaryPhase) {
+	if err := transitCanaryPhase(ctx, client, namespace, name, to); err != nil {
+		logger.Warnf("mark canary %s.%s as %s failed err: %s", name, namespace, to, err)
+	}
+}
@@ -0,0 +1,276 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/common"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/canary/analysis"
+	"github.com/huhenry/hej/pkg/jsondiff"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/kataras/iris/v12"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// RevisionsAnnotation keeps the policy history on the canary resource.
+	RevisionsAnnotation = "tpaas.troila.com/canary.revisions"
+	// MaxRevisionsSize bounds the serialized history, the annotations of an
+	// object share 256KiB so older revisions are dropped to stay within half
+	// of it. The newest revision is always kept.
+	MaxRevisionsSize = 128 << 10
+
+	QueryParameterRevision = "revision"
+)
+
+// Revision is one version of the canary policy, Changes is the diff against
+// the previous revision.
+type Revision struct {
+	Revision  int                 `json:"revision"`
+	User      string              `json:"user,omitempty"`
+	Timestamp int64               `json:"timestamp"`
+	Policy    canary.CanaryPolicy `json:"policy"`
+	Changes   []jsondiff.Change   `json:"changes,omitempty"`
+}
+
+func canaryRevisions(annotations map[string]string) ([]Revision, error) {
+	revisions := []Revision{}
+	data, ok := annotations[RevisionsAnnotation]
+	if !ok || len(data) == 0 {
+		return revisions, nil
+	}
+	if err := json.Unmarshal([]byte(data), &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func getCanaryRevisions(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) ([]Revision, error) {
+	un, err := client.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return canaryRevisions(un.GetAnnotations())
+}
+
+// addRevision appends policy to the history kept in the annotations of un.
+// previous is the policy before the change, it seeds the history of canaries
+// created before revisions were recorded.
+func addRevision(un *unstructured.Unstructured, user string, previous *canary.CanaryPolicy, policy canary.CanaryPolicy) error {
+	annotations := un.GetAnnotations()
+	revisions, err := canaryRevisions(annotations)
+	if err != nil {
+		logger.Warnf("canary %s.%s has broken revisions, reset history err: %s", un.GetName(), un.GetNamespace(), err)
+		revisions = []Revision{}
+	}
+	if len(revisions) == 0 && previous != nil {
+		revisions = append(revisions, Revision{
+			Revision:  1,
+			Timestamp: un.GetCreationTimestamp().Unix(),
+			Policy:    *previous,
+		})
+	}
+
+	revision := Revision{
+		Revision:  1,
+		User:      user,
+		Timestamp: time.Now().Unix(),
+		Policy:    policy,
+	}
+	if len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+		revision.Revision = last.Revision + 1
+		changes, err := jsondiff.Diff(last.Policy, policy)
+		if err != nil {
+			return err
+		}
+		revision.Changes = changes
+	}
+	revisions = append(revisions, revision)
+
+	data, err := json.Marshal(revisions)
+	if err != nil {
+		return err
+	}
+	for len(data) > MaxRevisionsSize && len(revisions) > 1 {
+		revisions = revisions[1:]
+		if data, err = json.Marshal(revisions); err != nil {
+			return err
+		}
+	}
+
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[RevisionsAnnotation] = string(data)
+	un.SetAnnotations(annotations)
+	return nil
+}
+
+// recordRevision appends policy to the history of the canary on its own,
+// for the policies written by the canary package.
+func recordRevision(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name, user string, previous *canary.CanaryPolicy, policy canary.CanaryPolicy) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		un, err := client.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := addRevision(un, user, previous, policy); err != nil {
+			return err
+		}
+
+		_, err = client.Namespace(namespace).Update(ctx, un, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// policyChange returns the policy to apply to the canary of spec.
+type policyChange func(spec *canary.CanarySpec) (canary.CanaryPolicy, error)
+
+// updateCanaryPolicy writes the policy returned by change together with its
+// revision, in a single update of the canary. It returns the policy before
+// the change.
+func updateCanaryPolicy(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name, user string, change policyChange) (*canary.CanaryPolicy, error) {
+	var previous *canary.CanaryPolicy
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		un, spec, err := fetchCanary(ctx, client, namespace, name)
+		if err != nil {
+			return err
+		}
+		policy, err := change(spec)
+		if err != nil {
+			return err
+		}
+
+		value := map[string]interface{}{}
+		if err := common.JsonConvert(policy, &value); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(un.Object, value, "spec", "policy"); err != nil {
+			return err
+		}
+		if err := addRevision(un, user, spec.Policy, policy); err != nil {
+			return err
+		}
+
+		if _, err := client.Namespace(namespace).Update(ctx, un, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		previous = spec.Policy
+		return nil
+	})
+	return previous, err
+}
+
+// logRevision records a revision after the policy has been applied by the
+// canary package, a failure is only logged since the traffic has already been
+// changed.
+func logRevision(ctx context.Context, client dynamic.NamespaceableResourceInterface, namespace, name, user string, previous *canary.CanaryPolicy, policy canary.CanaryPolicy) {
+	if err := recordRevision(ctx, client, namespace, name, user, previous, policy); err != nil {
+		logger.Warnf("record revision of canary %s.%s failed err: %s", name, namespace, err)
+	}
+}
+
+func ListCanaryRevisions(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+	canaryName := ctx.Params().GetString("canary")
+
+	canaryClient, err := mgr.DynamicClient(appCtx.ClusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+
+	revisions, err := getCanaryRevisions(ctx.Request().Context(), canaryClient, appCtx.KubeNamespace, canaryName)
+	if err != nil {
+		logger.Errorf("list revisions of canary %s.%s failed err: %s", canaryName, appCtx.KubeNamespace, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("获取灰度规则历史失败！", err))
+		return
+	}
+
+	// newest first
+	for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+		revisions[i], revisions[j] = revisions[j], revisions[i]
+	}
+
+	handler.ResponseOk(ctx, revisions)
+}
+
+// RollbackCanary applies the policy of a previous revision, the rollback is
+// recorded as a new revision. A running analysis is stopped first.
+func RollbackCanary(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+	userCtx := handler.ExtractUserContext(ctx)
+
+	clusterName := appCtx.ClusterName
+	namespace := appCtx.KubeNamespace
+	canaryName := ctx.Params().GetString("canary")
+
+	revisionNumber, err := strconv.Atoi(ctx.URLParam(QueryParameterRevision))
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+
+	canaryClient, err := mgr.DynamicClient(clusterName, canaryGVK)
+	if err != nil {
+		logger.Errorf("DynamicClient %+v, %s", *canaryGVK, err)
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+
+	if err := ensureNotFrozen(ctx.Request().Context(), canaryClient, namespace, canaryName); err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	revisions, err := getCanaryRevisions(ctx.Request().Context(), canaryClient, namespace, canaryName)
+	if err != nil {
+		logger.Errorf("list revisions of canary %s.%s failed err: %s", canaryName, namespace, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("获取灰度规则历史失败！", err))
+		return
+	}
+
+	var target *Revision
+	for i := range revisions {
+		if revisions[i].Revision == revisionNumber {
+			target = &revisions[i]
+			break
+		}
+	}
+	if target == nil {
+		handler.ResponseErr(ctx, customErrors.BadRequest(fmt.Sprintf("灰度发布任务%s不存在版本%d的灰度规则", canaryName, revisionNumber)))
+		return
+	}
+
+	// the analysis would move the weights away from the revision again
+	key := analysis.Key(clusterName, namespace, canaryName)
+	if status, ok := analysis.DefaultController().Status(key); ok && !status.Finished() {
+		analysis.DefaultController().Stop(key)
+		handler.SendAudit(audit.ModuleCanary, audit.ActionStopAnalysis, canaryName, ctx)
+	}
+
+	_, err = updateCanaryPolicy(ctx.Request().Context(), canaryClient, namespace, canaryName, userCtx.Name, func(*canary.CanarySpec) (canary.CanaryPolicy, error) {
+		return target.Policy, nil
+	})
+	if err != nil {
+		logger.Errorf("rollback canary %s.%s to revision %d failed err: %s", canaryName, namespace, revisionNumber, err)
+
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.SendAudit(audit.ModuleCanary, audit.ActionRollback, canaryName, ctx)
+	handler.ResponseOk(ctx, nil)
+}
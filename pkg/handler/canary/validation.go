@@ -0,0 +1,166 @@
+package canary
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/handler"
+)
+
+const matchTypeRegex = "regex"
+
+// ValidateCanary returns every violation of the canary spec, the policy
+// violations are prefixed by policy.
+func ValidateCanary(canarySpec *canary.CanarySpec) handler.FieldErrors {
+	errs := handler.FieldErrors{}
+
+	if len(canarySpec.Services) == 0 {
+		errs = append(errs, handler.FieldError{
+			Field:   "services",
+			Message: fmt.Sprintf("灰度发布任务%s的灰度版本不可为空", canarySpec.Name),
+		})
+	}
+	for i, serviceRef := range canarySpec.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		if !handler.IsDNS1123Label(serviceRef.Version) {
+			errs = append(errs, handler.FieldError{
+				Field:   path + ".version",
+				Message: fmt.Sprintf("灰度发布任务%s的灰度版本%s不是有效的版本号", canarySpec.Name, serviceRef.Version),
+			})
+		}
+		if serviceRef.CopiedTarget != nil && serviceRef.CopiedTarget.Replicas != nil && *serviceRef.CopiedTarget.Replicas == 0 {
+			errs = append(errs, handler.FieldError{
+				Field:   path + ".copiedTarget.replicas",
+				Message: fmt.Sprintf("灰度发布任务%s工作负载副本数不可为0", canarySpec.Name),
+			})
+		}
+		if serviceRef.SelectedTarget != nil && serviceRef.SelectedTarget.Replicas != nil && *serviceRef.SelectedTarget.Replicas == 0 {
+			errs = append(errs, handler.FieldError{
+				Field:   path + ".selectedTarget.replicas",
+				Message: fmt.Sprintf("灰度发布任务%s工作负载副本数不可为0", canarySpec.Name),
+			})
+		}
+	}
+
+	for _, err := range ValidatePolicy(canarySpec.Policy) {
+		if len(err.Field) > 0 {
+			err.Field = "policy." + err.Field
+		} else {
+			err.Field = "policy"
+		}
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// ValidatePolicy returns every violation of the policy with the json path of
+// the offending field.
+func ValidatePolicy(policy *canary.CanaryPolicy) handler.FieldErrors {
+	errs := handler.FieldErrors{}
+	if policy == nil || (len(policy.WeightStrategy) == 0 && len(policy.RequestStrategy) == 0 && len(policy.TrafficMirroring) == 0) {
+		return append(errs, handler.FieldError{Field: "", Message: "缺少灰度规则设置."})
+	}
+
+	for i, request := range policy.RequestStrategy {
+		path := fmt.Sprintf("requestStrategy[%d]", i)
+		if len(request.HttpCookies) == 0 && len(request.HttpHeader) == 0 {
+			errs = append(errs, handler.FieldError{Field: path, Message: "请求规则至少需要一个Cookie或请求头匹配条件"})
+		}
+		for j := 0; j < i; j++ {
+			if reflect.DeepEqual(policy.RequestStrategy[j], request) {
+				errs = append(errs, handler.FieldError{Field: path, Message: fmt.Sprintf("与requestStrategy[%d]的请求规则重复", j)})
+				break
+			}
+		}
+
+		for j, cookie := range request.HttpCookies {
+			errs = append(errs, validateCookie(fmt.Sprintf("%s.httpCookies[%d]", path, j), cookie.MatchType, cookie.MatchValue)...)
+			for k := 0; k < j; k++ {
+				if reflect.DeepEqual(request.HttpCookies[k], cookie) {
+					errs = append(errs, handler.FieldError{Field: fmt.Sprintf("%s.httpCookies[%d]", path, j), Message: fmt.Sprintf("与httpCookies[%d]的Cookie规则重复", k)})
+					break
+				}
+			}
+		}
+		for j, header := range request.HttpHeader {
+			errs = append(errs, validateMatch(fmt.Sprintf("%s.httpHeader[%d]", path, j), header.MatchType, header.MatchValue)...)
+			for k := 0; k < j; k++ {
+				if reflect.DeepEqual(request.HttpHeader[k], header) {
+					errs = append(errs, handler.FieldError{Field: fmt.Sprintf("%s.httpHeader[%d]", path, j), Message: fmt.Sprintf("与httpHeader[%d]的请求头规则重复", k)})
+					break
+				}
+			}
+		}
+	}
+
+	if len(policy.WeightStrategy) > 0 {
+		var totalWeight int32 = 0
+		versions := map[string]int{}
+		for i, weightPolicy := range policy.WeightStrategy {
+			path := fmt.Sprintf("weightStrategy[%d]", i)
+			if weightPolicy.Weight < 0 {
+				errs = append(errs, handler.FieldError{Field: path + ".weight", Message: "流量比重不可为负数"})
+			}
+			if j, ok := versions[weightPolicy.Version]; ok {
+				errs = append(errs, handler.FieldError{Field: path + ".version", Message: fmt.Sprintf("与weightStrategy[%d]的版本重复", j)})
+			} else {
+				versions[weightPolicy.Version] = i
+			}
+			totalWeight = totalWeight + weightPolicy.Weight
+		}
+
+		if totalWeight != 100 {
+			errs = append(errs, handler.FieldError{Field: "weightStrategy", Message: "流量规则比重大于或者是不等于100."})
+		}
+	}
+
+	for i, mirroring := range policy.TrafficMirroring {
+		path := fmt.Sprintf("trafficMirroring[%d]", i)
+		if len(strings.TrimSpace(mirroring.Version)) == 0 {
+			errs = append(errs, handler.FieldError{Field: path + ".version", Message: "镜像版本不可为空"})
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if reflect.DeepEqual(policy.TrafficMirroring[j], mirroring) {
+				errs = append(errs, handler.FieldError{Field: path, Message: fmt.Sprintf("与trafficMirroring[%d]的镜像规则重复", j)})
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateCookie checks a cookie rule, unless it is a regex its value is
+// name=value and the cookie name cannot be empty.
+func validateCookie(path, matchType, matchValue string) handler.FieldErrors {
+	errs := validateMatch(path, matchType, matchValue)
+	if len(matchValue) == 0 || strings.EqualFold(matchType, matchTypeRegex) {
+		return errs
+	}
+	if name := strings.SplitN(matchValue, "=", 2); len(name) != 2 || len(strings.TrimSpace(name[0])) == 0 {
+		errs = append(errs, handler.FieldError{Field: path + ".matchValue", Message: fmt.Sprintf("Cookie规则%s须为name=value格式且name不可为空", matchValue)})
+	}
+	return errs
+}
+
+func validateMatch(path, matchType, matchValue string) handler.FieldErrors {
+	errs := handler.FieldErrors{}
+	if len(matchType) == 0 {
+		errs = append(errs, handler.FieldError{Field: path + ".matchType", Message: "匹配方式不可为空"})
+	}
+	if len(matchValue) == 0 {
+		errs = append(errs, handler.FieldError{Field: path + ".matchValue", Message: "匹配值不可为空"})
+		return errs
+	}
+	if strings.EqualFold(matchType, matchTypeRegex) {
+		if _, err := regexp.Compile(matchValue); err != nil {
+			errs = append(errs, handler.FieldError{Field: path + ".matchValue", Message: fmt.Sprintf("正则表达式%s无效: %s", matchValue, err)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,94 @@
+package canary_test
+
+import (
+	"encoding/json"
+
+	pkgcanary "github.com/huhenry/hej/pkg/canary"
+	. "github.com/huhenry/hej/pkg/handler/canary"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func fields(policyJSON string) []string {
+	var policy *pkgcanary.CanaryPolicy
+	Expect(json.Unmarshal([]byte(policyJSON), &policy)).To(Succeed())
+
+	result := []string{}
+	for _, err := range ValidatePolicy(policy) {
+		Expect(err.Message).NotTo(BeEmpty())
+		result = append(result, err.Field)
+	}
+	return result
+}
+
+var _ = Describe("ValidatePolicy", func() {
+	table.DescribeTable("reports every violation with its field path",
+		func(policyJSON string, expected []string) {
+			Expect(fields(policyJSON)).To(Equal(expected))
+		},
+		table.Entry("valid weights",
+			`{"weightStrategy":[{"version":"v1","weight":90},{"version":"v2","weight":10}]}`,
+			[]string{}),
+		table.Entry("valid headers",
+			`{"requestStrategy":[{"httpHeader":[{"matchType":"exact","matchValue":"tester"}]}]}`,
+			[]string{}),
+		table.Entry("missing policy",
+			`null`,
+			[]string{""}),
+		table.Entry("empty policy",
+			`{}`,
+			[]string{""}),
+		table.Entry("negative weight and wrong total",
+			`{"weightStrategy":[{"version":"v1","weight":-10},{"version":"v2","weight":100}]}`,
+			[]string{"weightStrategy[0].weight", "weightStrategy"}),
+		table.Entry("duplicated version",
+			`{"weightStrategy":[{"version":"v1","weight":50},{"version":"v1","weight":50}]}`,
+			[]string{"weightStrategy[1].version"}),
+		table.Entry("every header and cookie problem",
+			`{"requestStrategy":[
+				{"httpHeader":[{"matchType":"exact","matchValue":"a"}]},
+				{"httpHeader":[{"matchType":"exact","matchValue":""},{"matchType":"","matchValue":"b"}],
+				 "httpCookies":[{"matchType":"exact"}]}
+			]}`,
+			[]string{
+				"requestStrategy[1].httpCookies[0].matchValue",
+				"requestStrategy[1].httpHeader[0].matchValue",
+				"requestStrategy[1].httpHeader[1].matchType",
+			}),
+		table.Entry("invalid regex",
+			`{"requestStrategy":[{"httpHeader":[{"matchType":"regex","matchValue":"(user"}]}]}`,
+			[]string{"requestStrategy[0].httpHeader[0].matchValue"}),
+		table.Entry("valid regex",
+			`{"requestStrategy":[{"httpHeader":[{"matchType":"regex","matchValue":"^user-[0-9]+$"}]}]}`,
+			[]string{}),
+		table.Entry("duplicated rules",
+			`{"requestStrategy":[
+				{"httpHeader":[{"matchType":"exact","matchValue":"a"},{"matchType":"exact","matchValue":"a"}]},
+				{"httpHeader":[{"matchType":"exact","matchValue":"a"},{"matchType":"exact","matchValue":"a"}]}
+			]}`,
+			[]string{
+				"requestStrategy[0].httpHeader[1]",
+				"requestStrategy[1]",
+				"requestStrategy[1].httpHeader[1]",
+			}),
+		table.Entry("request rule without condition",
+			`{"requestStrategy":[{}]}`,
+			[]string{"requestStrategy[0]"}),
+		table.Entry("valid cookies",
+			`{"requestStrategy":[{"httpCookies":[{"matchType":"exact","matchValue":"user=tester"},{"matchType":"regex","matchValue":"user=test.*"}]}]}`,
+			[]string{}),
+		table.Entry("cookie without name",
+			`{"requestStrategy":[{"httpCookies":[{"matchType":"exact","matchValue":"tester"},{"matchType":"prefix","matchValue":" =tester"}]}]}`,
+			[]string{
+				"requestStrategy[0].httpCookies[0].matchValue",
+				"requestStrategy[0].httpCookies[1].matchValue",
+			}),
+		table.Entry("mirroring without version",
+			`{"trafficMirroring":[{}, {"version":" "}]}`,
+			[]string{"trafficMirroring[0].version", "trafficMirroring[1].version"}),
+		table.Entry("duplicated mirroring",
+			`{"trafficMirroring":[{"version":"v2"}, {"version":"v2"}]}`,
+			[]string{"trafficMirroring[1]"}),
+	)
+})
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/huhenry/hej/pkg/common/page"
@@ -55,6 +56,34 @@ type RespMsgListJson struct {
 	Detail string      `json:"detail,omitempty""`
 }
 
+// FieldError is a validation failure of one field of the request body, Field
+// is the json path of the field, e.g. requestStrategy[1].httpHeader[0].matchValue
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// FieldErrors collects every validation failure of a request body.
+type FieldErrors []FieldError
+
+func (errs FieldErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		if len(err.Field) > 0 {
+			messages = append(messages, err.Field+": "+err.Message)
+		} else {
+			messages = append(messages, err.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+type RespFieldErrorsJson struct {
+	Status int         `json:"code"`
+	Msg    string      `json:"message"`
+	Errors FieldErrors `json:"errors"`
+}
+
 // //////////////////////////////////////////////////////
 func GetNulString(ctx iris.Context, k string) string {
 	return ctx.FormValue(k)
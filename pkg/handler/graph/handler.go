@@ -33,7 +33,6 @@ const (
 	PathParameterStep    = "step"
 
 	DefaultInstallNamespace = "istio-system"
-	DefaultTag              = "1.6.0"
 	DefaultProfile          = "default"
 
 	JaegerPort = "5066"
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/installation/demo"
+	"github.com/huhenry/hej/pkg/log"
 
 	"github.com/huhenry/hej/pkg/common/translate"
 
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 
 	customErrors "github.com/huhenry/hej/pkg/errors"
 
@@ -21,13 +25,87 @@ import (
 	"github.com/kataras/iris/v12"
 )
 
+var logger = log.RegisterScope("bookinfo.handler")
+
+const PathParameterTemplate = "template"
+
+// manifestBuilder is what the installer builds the objects of a demo with.
+type manifestBuilder interface {
+	Build(params bookinfo.Params) ([]*unstructured.Unstructured, error)
+}
+
+// builders are the templates built in code, the others are rendered from
+// their bundle.
+var builders = map[string]manifestBuilder{
+	demo.TemplateBookinfo: &bookinfo.ManifestBuilder{},
+}
+
+// bundleBuilder builds a demo from the bundle of its template.
+type bundleBuilder struct {
+	template *demo.Template
+}
+
+func (b *bundleBuilder) Build(params bookinfo.Params) ([]*unstructured.Unstructured, error) {
+	return b.template.Render(demo.Values{
+		Name:      params.MicroAppName,
+		Namespace: params.Namespace,
+		Hub:       params.Hub,
+		App:       params.AppName,
+		Creator:   params.Creator,
+	})
+}
+
+func builderOf(template *demo.Template) manifestBuilder {
+	if builder, ok := builders[template.Name]; ok {
+		return builder
+	}
+	return &bundleBuilder{template: template}
+}
+
 func InstallHandler(mgr multiCluster.Manager) func(ctx iris.Context) {
 	return func(ctx iris.Context) {
 		Install(mgr, ctx)
 	}
 }
 
+func demoRegistry(mgr multiCluster.Manager, clusterName string) (*demo.Registry, error) {
+	kube, err := mgr.Client(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return &demo.Registry{
+		Kube: kube,
+		Resources: func(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error) {
+			return mgr.DynamicClient(clusterName, &gvk)
+		},
+	}, nil
+}
+
+// Catalog lists the templates a demo can be installed from.
+func Catalog(ctx iris.Context) {
+	handler.ResponseOk(ctx, demo.Catalog())
+}
+
+// Install creates the bookinfo demo.
 func Install(mgr multiCluster.Manager, ctx iris.Context) {
+	template, _ := demo.Lookup(demo.TemplateBookinfo)
+	install(mgr, ctx, template)
+}
+
+// InstallTemplate creates a demo from the template of the path.
+func InstallTemplate(mgr multiCluster.Manager, ctx iris.Context) {
+	name := ctx.Params().Get(PathParameterTemplate)
+	template, ok := demo.Lookup(name)
+	if !ok {
+		handler.Response(ctx, customErrors.StatusCodeResourceNotFound, fmt.Sprintf("演示应用模板%s不存在", name))
+		return
+	}
+	install(mgr, ctx, template)
+}
+
+// install creates a demo from the template, the objects built for it are
+// recorded first so a demo failing halfway can still be removed.
+func install(mgr multiCluster.Manager, ctx iris.Context, template *demo.Template) {
 	appCtx := handler.ExtractAppContext(ctx)
 	userCtx := handler.ExtractUserContext(ctx)
 	m := make(map[string]string)
@@ -58,21 +136,50 @@ func Install(mgr multiCluster.Manager, ctx iris.Context) {
 	dao := &bookinfo.DefaultDao{
 		Mgr: mgr,
 	}
-	installer := bookinfo.NewInstaller(params, &bookinfo.ManifestBuilder{}, dao)
+	builder := builderOf(template)
 	context := context.Background()
 
+	manifests, err := builder.Build(params)
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	registry, err := demoRegistry(mgr, appCtx.ClusterName)
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	entry := &demo.Demo{
+		Name:      name,
+		Template:  template.Name,
+		Namespace: appCtx.KubeNamespace,
+		Creator:   userCtx.Name,
+	}
+	for _, manifest := range manifests {
+		entry.Resources = append(entry.Resources, demo.RefOf(manifest))
+	}
+	if err := registry.Record(context, entry); err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	installer := bookinfo.NewInstaller(params, builder, dao)
 	err = installer.Install(context)
 
 	if err != nil {
 		if existErr, ok := err.(*bookinfo.ResourceAlreadyExistErr); ok {
+			// nothing was created, the name stays free
+			if err := registry.Forget(context, entry.Namespace, name); err != nil {
+				logger.Errorf("forget demo %s failed err: %s", name, err)
+			}
+
 			msgs := make([]string, 0)
 			for _, resource := range existErr.Resource {
 				msgs = append(msgs, buildExistsError(resource))
 			}
-
-			msg := strings.Join(msgs, " ")
-			handler.Response(ctx, customErrors.StatusCodeUnProcessableEntity, msg)
+			handler.ResponseMessageList(ctx, customErrors.StatusCodeUnProcessableEntity, msgs)
 		} else {
+			logger.Errorf("install demo %s failed, it is left for removal err: %s", name, err)
 			handler.ResponseErr(ctx, err)
 		}
 
@@ -86,3 +193,69 @@ func Install(mgr multiCluster.Manager, ctx iris.Context) {
 func buildExistsError(resource *unstructured.Unstructured) string {
 	return fmt.Sprintf("%s %s在集群中已存在", translate.ToChinese(resource.GetObjectKind().GroupVersionKind()), resource.GetName())
 }
+
+// ListDemos lists the demos installed in the namespace of the application.
+func ListDemos(mgr multiCluster.Manager, ctx iris.Context) {
+	appCtx := handler.ExtractAppContext(ctx)
+
+	registry, err := demoRegistry(mgr, appCtx.ClusterName)
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+	demos, err := registry.List(ctx.Request().Context(), appCtx.KubeNamespace)
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, demos)
+}
+
+func getDemo(mgr multiCluster.Manager, ctx iris.Context) (*demo.Registry, *demo.Demo, bool) {
+	appCtx := handler.ExtractAppContext(ctx)
+	name := ctx.Params().Get("name")
+
+	registry, err := demoRegistry(mgr, appCtx.ClusterName)
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return nil, nil, false
+	}
+	entry, err := registry.Get(ctx.Request().Context(), appCtx.KubeNamespace, name)
+	if k8serror.IsNotFound(err) {
+		handler.Response(ctx, customErrors.StatusCodeResourceNotFound, fmt.Sprintf("演示应用%s不存在", name))
+		return nil, nil, false
+	}
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return nil, nil, false
+	}
+	return registry, entry, true
+}
+
+// GetDemo reports the rollout of every object of the demo.
+func GetDemo(mgr multiCluster.Manager, ctx iris.Context) {
+	registry, entry, ok := getDemo(mgr, ctx)
+	if !ok {
+		return
+	}
+
+	handler.ResponseOk(ctx, registry.Status(ctx.Request().Context(), entry))
+}
+
+// DeleteDemo removes the objects created for the demo and nothing else.
+func DeleteDemo(mgr multiCluster.Manager, ctx iris.Context) {
+	registry, entry, ok := getDemo(mgr, ctx)
+	if !ok {
+		return
+	}
+
+	if err := registry.Uninstall(ctx.Request().Context(), entry); err != nil {
+		logger.Errorf("uninstall demo %s failed err: %s", entry.Name, err)
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.SendAudit(audit.ModuleMicroApplication, audit.ActionDelete, entry.Name, ctx)
+	handler.ResponseOk(ctx, nil)
+}
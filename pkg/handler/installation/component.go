@@ -0,0 +1,114 @@
+package installation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/huhenry/hej/pkg/handler/installation/health"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	istiov1alpha1 "istio.io/istio/operator/pkg/apis/istio/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	StepInstall      = "install"
+	StepUninstall    = "uninstall"
+	StepEgress       = "egress"
+	StepRollback     = "rollback"
+	StepCRDs         = "crds"
+	StepControlPlane = "control-plane"
+	StepGateways     = "gateways"
+	StepZookeeper    = "zookeeper"
+
+	componentPollInterval = 5 * time.Second
+	componentReadyTimeout = 5 * time.Minute
+)
+
+// Component is a deployment of the mesh installed by the operator.
+type Component struct {
+	Name       string
+	Namespace  string
+	Deployment string
+}
+
+var (
+	ComponentIstiod         = Component{Name: "istiod", Namespace: DefaultInstallNamespace, Deployment: "istiod"}
+	ComponentIngressGateway = Component{Name: "ingressgateway", Namespace: DefaultInstallNamespace, Deployment: "istio-ingressgateway"}
+	ComponentEgressGateway  = Component{Name: "egressgateway", Namespace: DefaultInstallNamespace, Deployment: "istio-egressgateway"}
+	ComponentZookeeper      = Component{Name: "zookeeper", Namespace: DefaultInstallNamespace, Deployment: "zookeeper"}
+)
+
+// poll calls check until it is done, fails or the timeout elapses.
+func poll(ctx context.Context, timeout time.Duration, check func(ctx context.Context) (bool, string, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(componentPollInterval)
+	defer ticker.Stop()
+
+	lastMessage := ""
+	for {
+		done, message, err := check(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		lastMessage = message
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout after %s: %s", timeout, lastMessage)
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitComponents waits for the deployments of the components to be ready, a
+// missing deployment is waited for since the operator creates it later.
+func waitComponents(mgr multiCluster.Manager, clusterName, name string, components ...Component) Step {
+	return Step{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			client, err := mgr.Client(clusterName)
+			if err != nil {
+				return err
+			}
+			for _, component := range components {
+				err := poll(ctx, componentReadyTimeout, func(ctx context.Context) (bool, string, error) {
+					deployment, err := client.AppsV1().Deployments(component.Namespace).Get(ctx, component.Deployment, metav1.GetOptions{})
+					if err != nil {
+						return false, err.Error(), nil
+					}
+					ready, message := health.DeploymentReady(deployment)
+					return ready, message, nil
+				})
+				if err != nil {
+					return fmt.Errorf("%s is not ready: %s", component.Name, err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// waitCRDs waits for the IstioOperator resource to be served.
+func waitCRDs(mgr multiCluster.Manager, clusterName string) Step {
+	return Step{
+		Name: StepCRDs,
+		Run: func(ctx context.Context) error {
+			client, err := mgr.DynamicClient(clusterName, &istiov1alpha1.IstioOperatorGVK)
+			if err != nil {
+				return err
+			}
+			return poll(ctx, componentReadyTimeout, func(ctx context.Context) (bool, string, error) {
+				if _, err := client.Namespace(DefaultInstallNamespace).List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+					return false, err.Error(), nil
+				}
+				return true, "", nil
+			})
+		},
+	}
+}
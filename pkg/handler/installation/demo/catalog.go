@@ -0,0 +1,149 @@
+package demo
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	TemplateBookinfo = "bookinfo"
+	TemplateGRPCHTTP = "grpc-http"
+)
+
+// bundles holds a directory per template, named after it, with the entry of
+// the catalog in template.yaml and the objects in bundle.yaml. A template
+// without bundle.yaml is built in code by its installer.
+//
+//go:embed templates
+var bundles embed.FS
+
+const (
+	templatesDir = "templates"
+	entryFile    = "template.yaml"
+	bundleFile   = "bundle.yaml"
+)
+
+// Template is an entry of the demo catalog. A template with a bundle is
+// rendered from it, the others are built in code by their installer.
+type Template struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Features    []string `json:"features"`
+	Bundle      string   `json:"-"`
+}
+
+// Values are what a bundle is rendered with, Name is the name of the demo.
+type Values struct {
+	Name      string
+	Namespace string
+	Hub       string
+	App       string
+	Creator   string
+}
+
+var catalog = mustLoad()
+
+// mustLoad reads the catalog from the embedded templates, they are part of
+// the binary so a broken one is a build error.
+func mustLoad() map[string]*Template {
+	catalog, err := load(bundles)
+	if err != nil {
+		panic(err)
+	}
+	return catalog
+}
+
+func load(fsys fs.FS) (map[string]*Template, error) {
+	entries, err := fs.ReadDir(fsys, templatesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := map[string]*Template{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := path.Join(templatesDir, entry.Name())
+		data, err := fs.ReadFile(fsys, path.Join(dir, entryFile))
+		if err != nil {
+			return nil, err
+		}
+		t := &Template{}
+		if err := yaml.Unmarshal(data, t); err != nil {
+			return nil, fmt.Errorf("decode template %s failed: %s", entry.Name(), err)
+		}
+		bundle, err := fs.ReadFile(fsys, path.Join(dir, bundleFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		t.Name = entry.Name()
+		t.Bundle = string(bundle)
+		catalog[t.Name] = t
+	}
+	return catalog, nil
+}
+
+// Lookup returns the template of the name.
+func Lookup(name string) (*Template, bool) {
+	t, ok := catalog[name]
+	return t, ok
+}
+
+// Catalog returns the templates sorted by name.
+func Catalog() []Template {
+	templates := make([]Template, 0, len(catalog))
+	for _, t := range catalog {
+		templates = append(templates, *t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Render builds the objects of the bundle in the order they are declared,
+// every object is put in the namespace of the demo.
+func (t *Template) Render(values Values) ([]*unstructured.Unstructured, error) {
+	if len(t.Bundle) == 0 {
+		return nil, fmt.Errorf("template %s has no bundle", t.Name)
+	}
+	tmpl, err := template.New(t.Name).Parse(t.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s failed: %s", t.Name, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, values); err != nil {
+		return nil, fmt.Errorf("render template %s failed: %s", t.Name, err)
+	}
+
+	manifests := []*unstructured.Unstructured{}
+	decoder := yaml.NewYAMLOrJSONDecoder(buf, 4096)
+	for {
+		un := &unstructured.Unstructured{}
+		if err := decoder.Decode(&un.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("decode template %s failed: %s", t.Name, err)
+		}
+		if len(un.Object) == 0 {
+			continue
+		}
+		if len(un.GetAPIVersion()) == 0 || len(un.GetKind()) == 0 || len(un.GetName()) == 0 {
+			return nil, fmt.Errorf("template %s has an object without apiVersion, kind or name", t.Name)
+		}
+		un.SetNamespace(values.Namespace)
+		manifests = append(manifests, un)
+	}
+	return manifests, nil
+}
@@ -0,0 +1,63 @@
+package demo_test
+
+import (
+	"github.com/huhenry/hej/pkg/handler/installation/demo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Catalog", func() {
+	It("lists the templates by name", func() {
+		templates := demo.Catalog()
+		names := []string{}
+		for _, t := range templates {
+			names = append(names, t.Name)
+		}
+		Expect(names).To(Equal([]string{demo.TemplateBookinfo, demo.TemplateGRPCHTTP}))
+		for _, t := range templates {
+			Expect(t.Title).NotTo(BeEmpty())
+			Expect(t.Features).NotTo(BeEmpty())
+		}
+		Expect(templates[0].Bundle).To(BeEmpty())
+		Expect(templates[1].Bundle).NotTo(BeEmpty())
+	})
+
+	It("renders the grpc and http sample in the namespace of the demo", func() {
+		t, ok := demo.Lookup(demo.TemplateGRPCHTTP)
+		Expect(ok).To(BeTrue())
+
+		manifests, err := t.Render(demo.Values{Name: "sample", Namespace: "training", Hub: "harbor.local/library"})
+		Expect(err).NotTo(HaveOccurred())
+
+		refs := []demo.Ref{}
+		for _, manifest := range manifests {
+			refs = append(refs, demo.RefOf(manifest))
+		}
+		Expect(refs).To(Equal([]demo.Ref{
+			{APIVersion: "v1", Kind: "Service", Namespace: "training", Name: "sample-echo"},
+			{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "training", Name: "sample-echo-v1"},
+			{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "training", Name: "sample-echo-v2"},
+			{APIVersion: "networking.istio.io/v1alpha3", Kind: "DestinationRule", Namespace: "training", Name: "sample-echo"},
+			{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "training", Name: "sample-client"},
+		}))
+		containers, _, err := unstructured.NestedSlice(manifests[1].Object, "spec", "template", "spec", "containers")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(containers).To(ConsistOf(HaveKeyWithValue("image", "harbor.local/library/fortio:1.11.3")))
+	})
+
+	It("refuses to render a template built in code", func() {
+		t, ok := demo.Lookup(demo.TemplateBookinfo)
+		Expect(ok).To(BeTrue())
+
+		_, err := t.Render(demo.Values{Name: "bookinfo", Namespace: "training"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects an object without name", func() {
+		t := &demo.Template{Name: "broken", Bundle: "apiVersion: v1\nkind: Service\nmetadata: {}\n"}
+
+		_, err := t.Render(demo.Values{Namespace: "training"})
+		Expect(err).To(HaveOccurred())
+	})
+})
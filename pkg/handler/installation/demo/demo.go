@@ -0,0 +1,266 @@
+package demo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler/installation/health"
+	"github.com/huhenry/hej/pkg/log"
+)
+
+var logger = log.RegisterScope("installation-demo")
+
+var deploymentKind = schema.GroupKind{Group: appsv1.GroupName, Kind: "Deployment"}
+
+const (
+	// DemoLabel marks the registry entries, its value is the template.
+	DemoLabel = "tpaas.troila.com/demo"
+	// NameLabel is the name of the demo of a registry entry.
+	NameLabel = "tpaas.troila.com/demo.name"
+
+	registryPrefix  = "demo-"
+	keyDemo         = "demo"
+	propagationWait = metav1.DeletePropagationForeground
+)
+
+type State string
+
+const (
+	StateReady       State = "Ready"
+	StateProgressing State = "Progressing"
+	StateMissing     State = "Missing"
+	StateUnknown     State = "Unknown"
+)
+
+var severity = map[State]int{
+	StateReady:       0,
+	StateProgressing: 1,
+	StateUnknown:     2,
+	StateMissing:     3,
+}
+
+// Ref identifies an object of the manifests.
+type Ref struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+func RefOf(u *unstructured.Unstructured) Ref {
+	return Ref{APIVersion: u.GetAPIVersion(), Kind: u.GetKind(), Namespace: u.GetNamespace(), Name: u.GetName()}
+}
+
+func (r Ref) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)
+}
+
+// Demo is an installed demo application and the objects created for it.
+type Demo struct {
+	Name      string `json:"name"`
+	Template  string `json:"template"`
+	Namespace string `json:"namespace"`
+	Creator   string `json:"creator,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	Resources []Ref  `json:"resources"`
+}
+
+// ResourceStatus is the rollout of one object of the demo.
+type ResourceStatus struct {
+	Ref
+	State   State  `json:"state"`
+	Message string `json:"message,omitempty"`
+}
+
+// Status is the rollout of a demo, State is the worst of its objects.
+type Status struct {
+	Demo
+	State     State            `json:"state"`
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// ResourceClient returns the client of a kind, as the multiCluster manager does.
+type ResourceClient func(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error)
+
+// Registry keeps a ConfigMap per demo in its namespace listing the objects
+// created for it, so the demo can be inspected and removed as a whole.
+type Registry struct {
+	Kube      kubernetes.Interface
+	Resources ResourceClient
+}
+
+func registryName(name string) string {
+	return registryPrefix + name
+}
+
+// Record registers the demo before its objects are created, a half installed
+// demo can then be removed. It fails with a conflict when the name is taken.
+func (r *Registry) Record(ctx context.Context, demo *Demo) error {
+	if demo.CreatedAt == 0 {
+		demo.CreatedAt = time.Now().Unix()
+	}
+	data, err := json.Marshal(demo)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      registryName(demo.Name),
+			Namespace: demo.Namespace,
+			Labels: map[string]string{
+				DemoLabel: demo.Template,
+				NameLabel: demo.Name,
+			},
+		},
+		Data: map[string]string{keyDemo: string(data)},
+	}
+	_, err = r.Kube.CoreV1().ConfigMaps(demo.Namespace).Create(ctx, configMap, metav1.CreateOptions{})
+	if k8serror.IsAlreadyExists(err) {
+		return customErrors.Conflict(fmt.Sprintf("演示应用%s已存在", demo.Name))
+	}
+	return err
+}
+
+// Forget drops the registry entry, the objects are left untouched.
+func (r *Registry) Forget(ctx context.Context, namespace, name string) error {
+	err := r.Kube.CoreV1().ConfigMaps(namespace).Delete(ctx, registryName(name), metav1.DeleteOptions{})
+	if err != nil && !k8serror.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func decode(configMap *corev1.ConfigMap) (*Demo, error) {
+	demo := &Demo{}
+	if err := json.Unmarshal([]byte(configMap.Data[keyDemo]), demo); err != nil {
+		return nil, fmt.Errorf("demo registry %s/%s is broken: %s", configMap.Namespace, configMap.Name, err)
+	}
+	return demo, nil
+}
+
+func (r *Registry) Get(ctx context.Context, namespace, name string) (*Demo, error) {
+	configMap, err := r.Kube.CoreV1().ConfigMaps(namespace).Get(ctx, registryName(name), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return decode(configMap)
+}
+
+// List returns the demos of the namespace sorted by name, broken entries
+// are skipped.
+func (r *Registry) List(ctx context.Context, namespace string) ([]Demo, error) {
+	configMaps, err := r.Kube.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: DemoLabel})
+	if err != nil {
+		return nil, err
+	}
+
+	demos := []Demo{}
+	for i := range configMaps.Items {
+		demo, err := decode(&configMaps.Items[i])
+		if err != nil {
+			logger.Warnf("skip demo err: %s", err)
+			continue
+		}
+		demos = append(demos, *demo)
+	}
+	sort.Slice(demos, func(i, j int) bool { return demos[i].Name < demos[j].Name })
+	return demos, nil
+}
+
+func (r *Registry) client(ref Ref) (dynamic.ResourceInterface, error) {
+	client, err := r.Resources(ref.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if len(ref.Namespace) == 0 {
+		return client, nil
+	}
+	return client.Namespace(ref.Namespace), nil
+}
+
+// Status reports the rollout of every object of the demo.
+func (r *Registry) Status(ctx context.Context, demo *Demo) *Status {
+	status := &Status{Demo: *demo, State: StateReady, Resources: []ResourceStatus{}}
+	for _, ref := range demo.Resources {
+		resource := r.resourceStatus(ctx, ref)
+		status.Resources = append(status.Resources, resource)
+		if severity[resource.State] > severity[status.State] {
+			status.State = resource.State
+		}
+	}
+	return status
+}
+
+func (r *Registry) resourceStatus(ctx context.Context, ref Ref) ResourceStatus {
+	status := ResourceStatus{Ref: ref, State: StateReady}
+
+	client, err := r.client(ref)
+	if err != nil {
+		status.State = StateUnknown
+		status.Message = err.Error()
+		return status
+	}
+	un, err := client.Get(ctx, ref.Name, metav1.GetOptions{})
+	switch {
+	case k8serror.IsNotFound(err):
+		status.State = StateMissing
+		return status
+	case err != nil:
+		status.State = StateUnknown
+		status.Message = err.Error()
+		return status
+	}
+
+	if un.GetDeletionTimestamp() != nil {
+		status.State = StateProgressing
+		status.Message = "deleting"
+		return status
+	}
+	if ref.GroupVersionKind().GroupKind() == deploymentKind {
+		deployment := &appsv1.Deployment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(un.Object, deployment); err != nil {
+			status.State = StateUnknown
+			status.Message = err.Error()
+			return status
+		}
+		if ready, message := health.DeploymentReady(deployment); !ready {
+			status.State = StateProgressing
+			status.Message = message
+		}
+	}
+	return status
+}
+
+// Uninstall deletes the objects of the demo in the reverse order of their
+// creation, then its registry entry. Missing objects are skipped so a failed
+// uninstall can be retried.
+func (r *Registry) Uninstall(ctx context.Context, demo *Demo) error {
+	propagation := propagationWait
+	for i := len(demo.Resources) - 1; i >= 0; i-- {
+		ref := demo.Resources[i]
+		client, err := r.client(ref)
+		if err != nil {
+			return err
+		}
+		err = client.Delete(ctx, ref.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !k8serror.IsNotFound(err) {
+			return fmt.Errorf("delete %s %s failed: %s", ref.Kind, ref.Name, err)
+		}
+	}
+	return r.Forget(ctx, demo.Namespace, demo.Name)
+}
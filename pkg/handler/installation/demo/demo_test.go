@@ -0,0 +1,191 @@
+package demo_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/huhenry/hej/pkg/handler/installation/demo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	deploymentResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	serviceResource    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+)
+
+func deployment(name string, replicas, available int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "demo"},
+		"spec":       map[string]interface{}{"replicas": replicas},
+		"status": map[string]interface{}{
+			"updatedReplicas":   replicas,
+			"availableReplicas": available,
+		},
+	}}
+}
+
+func service(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "demo"},
+	}}
+}
+
+func bookinfo(name string, resources ...*unstructured.Unstructured) *demo.Demo {
+	entry := &demo.Demo{Name: name, Template: "bookinfo", Namespace: "demo", Creator: "admin"}
+	for _, resource := range resources {
+		entry.Resources = append(entry.Resources, demo.RefOf(resource))
+	}
+	return entry
+}
+
+var _ = Describe("Registry", func() {
+	var (
+		ctx           context.Context
+		kube          *fake.Clientset
+		dynamicClient *dynamicfake.FakeDynamicClient
+		registry      *demo.Registry
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		kube = fake.NewSimpleClientset()
+		dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			deploymentResource: "DeploymentList",
+			serviceResource:    "ServiceList",
+		})
+		registry = &demo.Registry{
+			Kube: kube,
+			Resources: func(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error) {
+				switch gvk.Kind {
+				case "Deployment":
+					return dynamicClient.Resource(deploymentResource), nil
+				case "Service":
+					return dynamicClient.Resource(serviceResource), nil
+				}
+				return nil, fmt.Errorf("kind %s is not served", gvk.Kind)
+			},
+		}
+	})
+
+	create := func(resource schema.GroupVersionResource, un *unstructured.Unstructured) {
+		_, err := dynamicClient.Resource(resource).Namespace("demo").Create(ctx, un, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	Describe("Record", func() {
+		It("keeps the demo until it is forgotten", func() {
+			Expect(registry.Record(ctx, bookinfo("shop", service("productpage")))).To(Succeed())
+
+			entry, err := registry.Get(ctx, "demo", "shop")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry.Template).To(Equal("bookinfo"))
+			Expect(entry.CreatedAt).NotTo(BeZero())
+			Expect(entry.Resources).To(Equal([]demo.Ref{{APIVersion: "v1", Kind: "Service", Namespace: "demo", Name: "productpage"}}))
+
+			Expect(registry.Forget(ctx, "demo", "shop")).To(Succeed())
+			_, err = registry.Get(ctx, "demo", "shop")
+			Expect(k8serror.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("rejects a name already taken", func() {
+			Expect(registry.Record(ctx, bookinfo("shop"))).To(Succeed())
+			err := registry.Record(ctx, bookinfo("shop"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("shop"))
+		})
+	})
+
+	Describe("List", func() {
+		It("sorts the demos and skips broken entries", func() {
+			Expect(registry.Record(ctx, bookinfo("zoo"))).To(Succeed())
+			Expect(registry.Record(ctx, bookinfo("abc"))).To(Succeed())
+			_, err := kube.CoreV1().ConfigMaps("demo").Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo-broken", Namespace: "demo", Labels: map[string]string{demo.DemoLabel: "bookinfo"}},
+				Data:       map[string]string{"demo": "{"},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = kube.CoreV1().ConfigMaps("demo").Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "demo"},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			demos, err := registry.List(ctx, "demo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(demos).To(HaveLen(2))
+			Expect(demos[0].Name).To(Equal("abc"))
+			Expect(demos[1].Name).To(Equal("zoo"))
+		})
+	})
+
+	Describe("Status", func() {
+		It("is ready when every object is rolled out", func() {
+			create(deploymentResource, deployment("reviews", 2, 2))
+			create(serviceResource, service("reviews"))
+
+			status := registry.Status(ctx, bookinfo("shop", service("reviews"), deployment("reviews", 2, 2)))
+			Expect(status.State).To(Equal(demo.StateReady))
+			Expect(status.Resources).To(HaveLen(2))
+		})
+
+		It("is progressing while a deployment is not available", func() {
+			create(deploymentResource, deployment("reviews", 2, 1))
+			create(serviceResource, service("reviews"))
+
+			status := registry.Status(ctx, bookinfo("shop", service("reviews"), deployment("reviews", 2, 1)))
+			Expect(status.State).To(Equal(demo.StateProgressing))
+			Expect(status.Resources[0].State).To(Equal(demo.StateReady))
+			Expect(status.Resources[1].State).To(Equal(demo.StateProgressing))
+			Expect(status.Resources[1].Message).To(ContainSubstring("1 of 2"))
+		})
+
+		It("reports the objects which are gone", func() {
+			create(deploymentResource, deployment("reviews", 1, 0))
+
+			status := registry.Status(ctx, bookinfo("shop", deployment("reviews", 1, 0), service("reviews")))
+			Expect(status.State).To(Equal(demo.StateMissing))
+			Expect(status.Resources[1].State).To(Equal(demo.StateMissing))
+		})
+	})
+
+	Describe("Uninstall", func() {
+		It("deletes the objects of the demo only", func() {
+			create(deploymentResource, deployment("reviews", 1, 1))
+			create(serviceResource, service("reviews"))
+			create(serviceResource, service("other"))
+			entry := bookinfo("shop", service("reviews"), deployment("reviews", 1, 1))
+			Expect(registry.Record(ctx, entry)).To(Succeed())
+
+			Expect(registry.Uninstall(ctx, entry)).To(Succeed())
+
+			_, err := dynamicClient.Resource(deploymentResource).Namespace("demo").Get(ctx, "reviews", metav1.GetOptions{})
+			Expect(k8serror.IsNotFound(err)).To(BeTrue())
+			_, err = dynamicClient.Resource(serviceResource).Namespace("demo").Get(ctx, "reviews", metav1.GetOptions{})
+			Expect(k8serror.IsNotFound(err)).To(BeTrue())
+			_, err = dynamicClient.Resource(serviceResource).Namespace("demo").Get(ctx, "other", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = registry.Get(ctx, "demo", "shop")
+			Expect(k8serror.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("skips the objects already deleted", func() {
+			entry := bookinfo("shop", service("reviews"))
+			Expect(registry.Record(ctx, entry)).To(Succeed())
+
+			Expect(registry.Uninstall(ctx, entry)).To(Succeed())
+		})
+	})
+})
@@ -2,6 +2,7 @@ package installation
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
@@ -11,9 +12,17 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 
+	backendV1 "github.com/huhenry/hej/pkg/backend/v1"
 	"github.com/huhenry/hej/pkg/common"
+	"github.com/huhenry/hej/pkg/define"
 	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/installation/health"
+	"github.com/huhenry/hej/pkg/handler/installation/iop"
+	"github.com/huhenry/hej/pkg/handler/installation/revision"
 	"github.com/huhenry/hej/pkg/installation/operator"
 	"github.com/huhenry/hej/pkg/log"
 	"github.com/huhenry/hej/pkg/multiCluster"
@@ -28,9 +37,16 @@ const (
 	ServiceMeshKind    = "ServiceMesh"
 
 	DefaultInstallNamespace = "istio-system"
+	IstioOperatorName       = "istiocontrolplane-default"
 	DefaultProfile          = "default"
 
 	JaegerPort = "5066"
+
+	// QueryParameterGateway names the egress gateway, istio-egressgateway by default.
+	QueryParameterGateway = "gateway"
+	// QueryParameterHealth asks the status for the health of the mesh, which
+	// checks every component and probes the endpoints.
+	QueryParameterHealth = "health"
 )
 
 var ServiceMeshGVK = &schema.GroupVersionKind{
@@ -55,21 +71,38 @@ func Install(mgr multiCluster.Manager, ctx iris.Context) {
 		return
 
 	}
-	err = manager.Install(istioInstall)
-	if err != nil {
-
-		manager.UnInstall()
-
-		logger.Errorf("installation failed %s", err)
 
+	report, err := runPreflight(ctx.Request().Context(), mgr, clusterName, istioInstall.Zookeeper)
+	if err != nil {
+		logger.Errorf("preflight of cluster %s failed err: %s", clusterName, err)
 		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("Istio安装部署失败！", err))
-
+		return
+	}
+	if failedChecks(ctx, report) {
 		return
 	}
 
-	//handler.SendAudit(audit.ModuleIstio, audit.ActionInstall, clusterName, ctx)
+	// manager.Install cannot be cancelled, the step runs to its end even past
+	// the job timeout and the steps after it see the expired context.
+	steps := []Step{
+		{Name: StepInstall, Run: func(context.Context) error { return manager.Install(istioInstall) }},
+		waitCRDs(mgr, clusterName),
+		waitComponents(mgr, clusterName, StepControlPlane, ComponentIstiod),
+		waitComponents(mgr, clusterName, StepGateways, ComponentIngressGateway),
+	}
+	if istioInstall.Zookeeper {
+		steps = append(steps, waitComponents(mgr, clusterName, StepZookeeper, ComponentZookeeper))
+	}
+	rollback := &Step{Name: StepRollback, Run: func(context.Context) error { return manager.UnInstall() }}
 
-	handler.ResponseOk(ctx, "{Success:true}")
+	job, err := jobs(mgr).Start(clusterName, StepInstall, userName(ctx), steps, rollback,
+		auditJob(audit.ModuleIstio, audit.ActionInstall, clusterName, istioInstall, ctx))
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, job)
 
 }
 
@@ -89,14 +122,74 @@ func Uninstall(mgr multiCluster.Manager, ctx iris.Context) {
 
 	}
 
-	err = manager.UnInstall()
+	revisions, err := revisionManager(mgr, clusterName)
 	if err != nil {
 		logger.Errorf("UnInstall failed err: %s", err)
 
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("Istio卸载失败！", err))
+
+		return
+	}
+
+	// the operator manager only knows the default control plane, the
+	// revisions of the upgrades go first
+	steps := []Step{
+		{Name: StepRevision, Run: revisions.RemoveAll},
+		{Name: StepUninstall, Run: func(context.Context) error { return manager.UnInstall() }},
+	}
+	job, err := jobs(mgr).Start(clusterName, StepUninstall, userName(ctx), steps, nil,
+		auditJob(audit.ModuleIstio, audit.ActionUnInstall, clusterName, nil, ctx))
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, job)
+
+}
+
+// userName tolerates a missing user context, the job is still run.
+func userName(ctx iris.Context) string {
+	if userContext, ok := ctx.Values().Get(define.UserContextKey).(*handler.UserContext); ok {
+		return userContext.Name
 	}
-	//handler.SendAudit(audit.ModuleIstio, audit.ActionUnInstall, clusterName, ctx)
-	handler.ResponseOk(ctx, "{Success:true}")
+	return ""
+}
 
+// auditJob audits the operation once its job is over, with the state the job
+// ended in. The user and the address are taken now, the request is gone by
+// then.
+func auditJob(module, action, cluster string, options interface{}, ctx iris.Context) func(job *Job) {
+	entry := handler.NewClusterAudit(module, action, cluster, &handler.ClusterAuditDetail{Operation: action, Options: options}, ctx)
+	return func(job *Job) {
+		handler.SetClusterAuditDetail(entry, &handler.ClusterAuditDetail{
+			Operation: action,
+			Options:   options,
+			Job:       job.ID,
+			State:     string(job.State),
+			Error:     job.Error,
+		})
+		audit.Send([]*backendV1.Audit{entry})
+	}
+}
+
+// GetJob polls a servicemesh job of the cluster.
+func GetJob(mgr multiCluster.Manager, ctx iris.Context) {
+	clusterName := ctx.Params().Get("cluster")
+	id := ctx.Params().Get("id")
+
+	job, err := jobs(mgr).Get(ctx.Request().Context(), clusterName, id)
+	if err != nil {
+		logger.Errorf("get job %s of cluster %s failed err: %s", id, clusterName, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("获取任务失败！", err))
+		return
+	}
+	if job == nil {
+		handler.Response(ctx, customErrors.StatusCodeResourceNotFound, fmt.Sprintf("集群%s不存在任务%s", clusterName, id))
+		return
+	}
+
+	handler.ResponseOk(ctx, job)
 }
 
 type ServiceMesh struct {
@@ -121,230 +214,215 @@ type ClusterStatus struct {
 	PrometheusURL string `json:"prometheusHost,omitempty"`
 	EurekaHost    string `json:"eurekaHost,omitempty"`
 	ZookeeperHost string `json:"zookeeperHost,omitempty"`
+	// Version is the version of the active control plane.
+	Version  string `json:"version,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// Health tells which component of the mesh is broken and why, it is only
+	// checked on demand.
+	Health *health.Report `json:"health,omitempty"`
 }
 
 type EgressgatewayStatus struct {
 	Status string `json:"status"`
 	Enable bool   `json:"enable"`
 	Error  string `json:"error"`
+	// Gateways are every egress gateway of the IstioOperator.
+	Gateways []GatewayStatus `json:"gateways,omitempty"`
 }
 
-func Status(mgr multiCluster.Manager, ctx iris.Context) {
-
-	clusterName := ctx.Params().Get("cluster")
-	cluster := ClusterStatus{
-		Status: "success",
-	}
+type GatewayStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
 
+// fetchServiceMesh checks the IstioOperator of the default control plane and
+// returns the ServiceMesh describing the installation.
+func fetchServiceMesh(ctx context.Context, mgr multiCluster.Manager, clusterName string) (*ServiceMesh, error) {
 	dynamicClient, err := mgr.DynamicClient(clusterName, &istiov1alpha1.IstioOperatorGVK)
 	if err != nil {
 		logger.Errorf("DynamicClient %s", err)
-		cluster.Status = "failure"
-		cluster.Error = fmt.Sprintf("%s", err)
-
-		handler.ResponseOk(ctx, cluster)
-		return
-
+		return nil, err
 	}
 
-	_, err = dynamicClient.Namespace("istio-system").Get(context.TODO(), "istiocontrolplane-default", metav1.GetOptions{})
-	if err != nil {
-		logger.Errorf("istiocontrolplane-default %s", err)
-		cluster.Status = "failure"
-		cluster.Error = fmt.Sprintf("%s", err)
-
-		handler.ResponseOk(ctx, cluster)
-		return
+	if _, err := dynamicClient.Namespace(DefaultInstallNamespace).Get(ctx, IstioOperatorName, metav1.GetOptions{}); err != nil {
+		logger.Errorf("%s %s", IstioOperatorName, err)
+		return nil, err
 	}
 
 	meshClient, err := mgr.DynamicClient(clusterName, ServiceMeshGVK)
 	if err != nil {
 		logger.Errorf("meshclient err %s", err)
-		cluster.Status = "failure"
-		cluster.Error = fmt.Sprintf("%s", err)
-
-		handler.ResponseOk(ctx, cluster)
-		return
+		return nil, err
 	}
 
-	meshus, err := meshClient.Namespace("istio-system").Get(context.TODO(), "mesh", metav1.GetOptions{})
+	meshus, err := meshClient.Namespace(DefaultInstallNamespace).Get(ctx, "mesh", metav1.GetOptions{})
 	if err != nil {
 		logger.Errorf("fetch mesh err %s", err)
-		cluster.Status = "failure"
-		cluster.Error = fmt.Sprintf("%s", err)
-
-		handler.ResponseOk(ctx, cluster)
-		return
+		return nil, err
 	}
 
 	mesh := &ServiceMesh{}
 	if err := common.JsonConvert(meshus, mesh); err != nil {
 		logger.Errorf("fetch mesh err %s", err)
-		cluster.Status = "failure"
-		cluster.Error = fmt.Sprintf("%s", err)
+		return nil, err
+	}
+	return mesh, nil
+}
 
-		handler.ResponseOk(ctx, cluster)
-		return
+func Status(mgr multiCluster.Manager, ctx iris.Context) {
+
+	clusterName := ctx.Params().Get("cluster")
+	cluster := ClusterStatus{
+		Status: "success",
 	}
 
-	clusterhost := mesh.Spec.ApiServerHost
+	mesh, err := fetchServiceMesh(ctx.Request().Context(), mgr, clusterName)
+	if err != nil {
+		cluster.Status = "failure"
+		cluster.Error = fmt.Sprintf("%s", err)
+	} else {
+		cluster.Host = mesh.Spec.ApiServerHost
+		cluster.JaegerURL = mesh.Spec.JaegerHost
+		cluster.EurekaHost = mesh.Spec.WireAddress
+		cluster.ZookeeperHost = mesh.Spec.ZookeeperAddress
+	}
 
-	jaegerhost := mesh.Spec.JaegerHost
+	var active *revision.Revision
+	revisions, err := revisionManager(mgr, clusterName)
+	if err == nil {
+		if active, _, err = revisions.Active(ctx.Request().Context()); err == nil {
+			cluster.Version = active.Version
+			cluster.Revision = active.Name
+		}
+	}
+	// without the default control plane the mesh is not installed, there is
+	// no health to check
+	installed := !k8serror.IsNotFound(err)
+	if err != nil && installed {
+		logger.Warnf("version of cluster %s is unknown err: %s", clusterName, err)
+	}
 
-	wireAddress := mesh.Spec.WireAddress
-	zookeeperAddress := mesh.Spec.ZookeeperAddress
-	cluster = ClusterStatus{
-		Status:        "success",
-		Host:          clusterhost,
-		JaegerURL:     jaegerhost,
-		EurekaHost:    wireAddress,
-		ZookeeperHost: zookeeperAddress,
+	if installed && ctx.URLParamBoolDefault(QueryParameterHealth, false) {
+		cluster.Health = meshHealth(ctx.Request().Context(), mgr, clusterName, active, mesh)
 	}
 
 	handler.ResponseOk(ctx, cluster)
 
 }
 
-func EgressStatus(mgr multiCluster.Manager, ctx iris.Context) {
-	clusterName := ctx.Params().Get("cluster")
+// getIstioOperator returns the IstioOperator of the default control plane,
+// which owns the gateways.
+func getIstioOperator(ctx context.Context, mgr multiCluster.Manager, clusterName string) (*iop.IstioOperator, error) {
 	istioOperatorClient, err := mgr.DynamicClient(clusterName, &istiov1alpha1.IstioOperatorGVK)
-	egress := &EgressgatewayStatus{}
 	if err != nil {
-		logger.Errorf("istioOperatorClient %s", err)
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
-		return
+		return nil, err
 	}
-
-	un, err := istioOperatorClient.Namespace("istio-system").Get(context.TODO(), "istiocontrolplane-default", metav1.GetOptions{})
+	un, err := istioOperatorClient.Namespace(DefaultInstallNamespace).Get(ctx, IstioOperatorName, metav1.GetOptions{})
 	if err != nil {
-		logger.Errorf("istiocontrolplane-default %s", err)
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
+		return nil, err
+	}
+	return iop.New(un), nil
+}
 
-		handler.ResponseOk(ctx, egress)
-		return
+// setEgressGateway switches a named egress gateway other than the default one
+// in the IstioOperator, the operator then reconciles the gateway deployment.
+func setEgressGateway(ctx context.Context, mgr multiCluster.Manager, clusterName, name string, enabled bool) error {
+	istioOperatorClient, err := mgr.DynamicClient(clusterName, &istiov1alpha1.IstioOperatorGVK)
+	if err != nil {
+		return err
 	}
-	/*	istioOperator := &istiov1alpha1.IstioOperator{}
-		err = dynamic.FromUnstructured(un, istioOperator)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		un, err := istioOperatorClient.Namespace(DefaultInstallNamespace).Get(ctx, IstioOperatorName, metav1.GetOptions{})
 		if err != nil {
-			logger.Errorf("istioOperator convert %s", err)
-			egress.Status = "failure"
-			egress.Error = fmt.Sprintf("%s", err)
-
-			handler.ResponseOk(ctx, egress)
-			return
+			return err
 		}
-				if istioOperator.Spec.Components != nil &&istioOperator.Spec.Components.EgressGateways!=nil&&len(istioOperator.Spec.Components.EgressGateways)>0{
-			egress.Enable = istioOperator.Spec.Components.EgressGateways[0].Enabled.Value
+		istioOperator := iop.New(un)
+		if err := istioOperator.SetGatewayEnabled(iop.EgressGateways, name, enabled); err != nil {
+			return err
 		}
-	*/
-	spec := un.Object["spec"]
-	if spec == nil {
-		err = fmt.Errorf("istiocontrolplane-default spec is null")
-		logger.Errorf(err.Error())
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
-		return
-	}
-	specmap, ok := spec.(map[string]interface{})
-	if !ok {
-		err = fmt.Errorf("istiocontrolplane-default spec not is map")
-		logger.Errorf(err.Error())
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
-		return
-	}
-	components := specmap["components"]
-	componentsmap, ok := components.(map[string]interface{})
-	if !ok {
-		err = fmt.Errorf("istiocontrolplane-default spec components not is map")
-		logger.Errorf(err.Error())
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
-		return
+		_, err = istioOperatorClient.Namespace(DefaultInstallNamespace).Update(ctx, istioOperator.Unstructured(), metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func gatewayComponent(gateway iop.Gateway) Component {
+	namespace := gateway.Namespace
+	if len(namespace) == 0 {
+		namespace = DefaultInstallNamespace
 	}
-	egressGateways := componentsmap["egressGateways"]
-	egressGatewayslist, ok := egressGateways.([]interface{})
-	if !ok {
-		err = fmt.Errorf("istiocontrolplane-default spec components egressGateways not is slice")
-		logger.Errorf(err.Error())
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
-		return
+	return Component{Name: gateway.Name, Namespace: namespace, Deployment: gateway.Name}
+}
+
+func gatewayStatus(ctx context.Context, k8sclient kubernetes.Interface, gateway iop.Gateway) GatewayStatus {
+	status := GatewayStatus{Name: gateway.Name, Enabled: gateway.Enabled, Status: "running"}
+	component := gatewayComponent(gateway)
+	_, err := k8sclient.AppsV1().Deployments(component.Namespace).Get(ctx, component.Deployment, metav1.GetOptions{})
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			status.Status = "notFound"
+		} else {
+			status.Status = "failure"
+		}
+		logger.Errorf("%s workload %s", gateway.Name, err)
+		status.Error = fmt.Sprintf("%s", err)
 	}
-	if egressGatewayslist == nil || len(egressGatewayslist) == 0 {
-		err = fmt.Errorf("istiocontrolplane-default spec components egressGateways is null")
-		logger.Errorf(err.Error())
+	return status
+}
+
+// EgressStatus reports every egress gateway, the top level fields describe
+// the gateway of the query parameter, istio-egressgateway by default.
+func EgressStatus(mgr multiCluster.Manager, ctx iris.Context) {
+	clusterName := ctx.Params().Get("cluster")
+	name := ctx.URLParamDefault(QueryParameterGateway, iop.DefaultEgressGateway)
+	egress := &EgressgatewayStatus{}
+	fail := func(err error) {
+		logger.Errorf("egress status of cluster %s failed err: %s", clusterName, err)
 		egress.Status = "failure"
 		egress.Error = fmt.Sprintf("%s", err)
 		handler.ResponseOk(ctx, egress)
-		return
 	}
-	egresses := egressGatewayslist[0]
-	egressesmap, ok := egresses.(map[string]interface{})
-	if !ok {
-		err = fmt.Errorf("istiocontrolplane-default spec components egressGateways egress not is map")
-		logger.Errorf(err.Error())
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
+
+	istioOperator, err := getIstioOperator(ctx.Request().Context(), mgr, clusterName)
+	if err != nil {
+		fail(err)
 		return
 	}
-
-	if v, ok := egressesmap["enabled"]; !ok {
-		err = fmt.Errorf("istiocontrolplane-default spec components egressGateways egress enabled is null")
-		logger.Errorf(err.Error())
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
+	gateways, err := istioOperator.Gateways(iop.EgressGateways)
+	if err != nil {
+		fail(err)
 		return
-	} else {
-		egress.Enable = v.(bool)
 	}
 
 	k8sclient, err := mgr.Client(clusterName)
 	if err != nil {
-		logger.Errorf("k8sclient %s", err)
-		egress.Status = "failure"
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
+		fail(err)
 		return
 	}
-	_, err = k8sclient.AppsV1().Deployments("istio-system").Get(context.TODO(), "istio-egressgateway", metav1.GetOptions{})
-	if err != nil {
-		if k8serror.IsNotFound(err) {
-			egress.Status = "notFound"
-		} else {
-			egress.Status = "failure"
+
+	selected := false
+	for _, gateway := range gateways {
+		status := gatewayStatus(ctx.Request().Context(), k8sclient, gateway)
+		egress.Gateways = append(egress.Gateways, status)
+		if gateway.Name == name {
+			selected = true
+			egress.Enable = status.Enabled
+			egress.Status = status.Status
+			egress.Error = status.Error
 		}
-		logger.Errorf("istio-egressGateway workload %s", err)
-		egress.Error = fmt.Sprintf("%s", err)
-		handler.ResponseOk(ctx, egress)
-		return
 	}
-	egress.Status = "running"
+	if !selected {
+		egress.Status = "failure"
+		egress.Error = (&iop.NotFoundError{Kind: iop.EgressGateways, Name: name}).Error()
+	}
 	handler.ResponseOk(ctx, egress)
-	return
 }
 
 func EgressEnable(mgr multiCluster.Manager, ctx iris.Context) {
 	clusterName := ctx.Params().Get("cluster")
+	name := ctx.URLParamDefault(QueryParameterGateway, iop.DefaultEgressGateway)
 
-	factory := operator.NewOperatorManagerFactory()
-	manager, err := factory(clusterName, mgr)
-	if err != nil {
-		logger.Errorf("EnableEgress failed err: %s", err)
-		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("外部服务开启失败！", err))
-
-		return
-
-	}
 	operation := ctx.Params().Get("operation")
 	enableEgress := false
 	if operation == "enable" {
@@ -359,19 +437,51 @@ func EgressEnable(mgr multiCluster.Manager, ctx iris.Context) {
 		return
 	}
 
-	if err := manager.EnableEgress(enableEgress); err != nil {
+	istioOperator, err := getIstioOperator(ctx.Request().Context(), mgr, clusterName)
+	if err != nil {
 		logger.Errorf("EnableEgress failed err: %s", err)
-		err := fmt.Errorf("EnableEgress failed : %s", DefaultInstallNamespace, err)
 		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("外部服务开启失败！", err))
+		return
+	}
+	gateway, err := istioOperator.Gateway(iop.EgressGateways, name)
+	if err != nil {
+		var notFound *iop.NotFoundError
+		if !errors.As(err, &notFound) || !enableEgress {
+			logger.Errorf("EnableEgress failed err: %s", err)
+			handler.ResponseErr(ctx, customErrors.BadRequest(fmt.Sprintf("外部服务网关%s不存在", name)))
+			return
+		}
+		gateway = &iop.Gateway{Name: name}
+	}
+
+	// the operator manager owns the default gateway, it only knows that one,
+	// the other named gateways are switched in the IstioOperator
+	toggle := func(ctx context.Context) error { return setEgressGateway(ctx, mgr, clusterName, name, enableEgress) }
+	if name == iop.DefaultEgressGateway {
+		factory := operator.NewOperatorManagerFactory()
+		manager, err := factory(clusterName, mgr)
+		if err != nil {
+			logger.Errorf("EnableEgress failed err: %s", err)
+			handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("外部服务开启失败！", err))
+			return
+		}
+		toggle = func(context.Context) error { return manager.EnableEgress(enableEgress) }
+	}
 
+	steps := []Step{{Name: StepEgress, Run: toggle}}
+	if enableEgress {
+		steps = append(steps, waitComponents(mgr, clusterName, StepGateways, gatewayComponent(*gateway)))
+	}
+	action := audit.ActionUnInstall
+	if enableEgress {
+		action = audit.ActionInstall
+	}
+	job, err := jobs(mgr).Start(clusterName, StepEgress+"-"+operation, userName(ctx), steps, nil,
+		auditJob(audit.ModuleIstio+"-"+audit.ModuleServiceEntry, action, clusterName, map[string]interface{}{"operation": operation, "gateway": name}, ctx))
+	if err != nil {
+		handler.ResponseErr(ctx, err)
 		return
 	}
-	/*
-		if enableEgress {
-			handler.SendAudit(audit.ModuleIstio+"-"+audit.ModuleServiceEntry, audit.ActionInstall, clusterName, ctx)
-		} else {
-			handler.SendAudit(audit.ModuleIstio+"-"+audit.ModuleServiceEntry, audit.ActionUnInstall, clusterName, ctx)
-		}*/
-	handler.ResponseOk(ctx, nil)
-	return
+
+	handler.ResponseOk(ctx, job)
 }
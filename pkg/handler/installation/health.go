@@ -0,0 +1,88 @@
+package installation
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/huhenry/hej/pkg/handler/installation/health"
+	"github.com/huhenry/hej/pkg/handler/installation/revision"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/huhenry/hej/pkg/prometheus"
+)
+
+const (
+	SidecarInjectorWebhook = "istio-sidecar-injector"
+	ValidationWebhook      = "istiod-" + DefaultInstallNamespace
+)
+
+var probeClient = &http.Client{Timeout: health.DefaultProbeTimeout}
+
+func (c Component) healthComponent(optional bool) health.Component {
+	return health.Component{Name: c.Name, Namespace: c.Namespace, Deployment: c.Deployment, Optional: optional}
+}
+
+// meshHealth checks the components of the active control plane, mesh is nil
+// when the ServiceMesh is missing. Jaeger and zookeeper are optional, they are
+// disabled when their address is not configured.
+func meshHealth(ctx context.Context, mgr multiCluster.Manager, clusterName string, active *revision.Revision, mesh *ServiceMesh) *health.Report {
+	kube, err := mgr.Client(clusterName)
+	if err != nil {
+		logger.Errorf("health of cluster %s is unknown err: %s", clusterName, err)
+		return &health.Report{
+			Status:     health.StateUnknown,
+			Components: []health.Health{},
+			Namespaces: []health.Injection{},
+		}
+	}
+
+	istiod := ComponentIstiod
+	injector := SidecarInjectorWebhook
+	if active != nil && len(active.Name) > 0 {
+		istiod.Name = istiod.Name + "-" + active.Name
+		istiod.Deployment = istiod.Deployment + "-" + active.Name
+		injector = injector + "-" + active.Name
+	}
+
+	opts := health.Options{
+		Components: []health.Component{
+			istiod.healthComponent(false),
+			ComponentIngressGateway.healthComponent(false),
+			ComponentEgressGateway.healthComponent(true),
+			ComponentZookeeper.healthComponent(true),
+		},
+		Webhooks: []health.Webhook{
+			{Name: injector, Kind: health.WebhookMutating},
+			{Name: ValidationWebhook, Kind: health.WebhookValidating, Optional: true},
+		},
+		Endpoints: []health.Endpoint{
+			prometheusEndpoint(mgr, clusterName),
+			{Name: "jaeger", Optional: true},
+			{Name: "zookeeper", Optional: true},
+		},
+	}
+	if mesh != nil {
+		if address := mesh.Spec.JaegerHost; len(address) > 0 {
+			opts.Endpoints[1] = health.Endpoint{Name: "jaeger", Address: address, Probe: health.HTTPProbe(probeClient, address), Optional: true}
+		}
+		if address := mesh.Spec.ZookeeperAddress; len(address) > 0 {
+			opts.Endpoints[2] = health.Endpoint{Name: "zookeeper", Address: address, Probe: health.TCPProbe(address), Optional: true}
+		}
+	}
+
+	return health.Run(ctx, kube, opts)
+}
+
+func prometheusEndpoint(mgr multiCluster.Manager, clusterName string) health.Endpoint {
+	endpoint := health.Endpoint{Name: "prometheus", Address: "prometheus"}
+	p8sClient, err := prometheus.NewP8sClient(mgr, clusterName)
+	if err != nil {
+		endpoint.Probe = func(context.Context) error { return err }
+		return endpoint
+	}
+	endpoint.Probe = func(ctx context.Context) error {
+		_, _, err := p8sClient.Api.Query(ctx, "vector(1)", time.Now())
+		return err
+	}
+	return endpoint
+}
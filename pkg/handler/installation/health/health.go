@@ -0,0 +1,432 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/huhenry/hej/pkg/handler/installation/revision"
+)
+
+type State string
+
+const (
+	StateHealthy   State = "Healthy"
+	StateDegraded  State = "Degraded"
+	StateUnhealthy State = "Unhealthy"
+	StateUnknown   State = "Unknown"
+	// StateDisabled is an optional component which is not installed.
+	StateDisabled State = "Disabled"
+
+	KindDeployment = "deployment"
+	KindWebhook    = "webhook"
+	KindEndpoint   = "endpoint"
+	KindInjection  = "injection"
+
+	WebhookMutating   = "mutating"
+	WebhookValidating = "validating"
+
+	InjectAnnotation    = "sidecar.istio.io/inject"
+	DefaultProbeTimeout = 3 * time.Second
+)
+
+var severity = map[State]int{
+	StateHealthy:   0,
+	StateDisabled:  0,
+	StateUnknown:   1,
+	StateDegraded:  2,
+	StateUnhealthy: 3,
+}
+
+// Replicas of a deployment.
+type Replicas struct {
+	Ready   int32 `json:"ready"`
+	Desired int32 `json:"desired"`
+}
+
+// Health is the state of one component, Reason tells what is broken.
+type Health struct {
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Status   State     `json:"status"`
+	Reason   string    `json:"reason,omitempty"`
+	Replicas *Replicas `json:"replicas,omitempty"`
+}
+
+// Injection is the sidecar coverage of a namespace with injection enabled,
+// pods opting out of injection are not counted.
+type Injection struct {
+	Namespace string `json:"namespace"`
+	Revision  string `json:"revision,omitempty"`
+	Pods      int    `json:"pods"`
+	Injected  int    `json:"injected"`
+	Status    State  `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Report holds every component, Status is the worst of them.
+type Report struct {
+	Status     State       `json:"status"`
+	Components []Health    `json:"components"`
+	Namespaces []Injection `json:"namespaces"`
+}
+
+func (r *Report) add(health Health) {
+	r.Components = append(r.Components, health)
+	r.worsen(health.Status)
+}
+
+func (r *Report) worsen(state State) {
+	if severity[state] > severity[r.Status] {
+		r.Status = state
+	}
+}
+
+// Component is a deployment of the mesh, an optional component may be
+// missing.
+type Component struct {
+	Name       string
+	Namespace  string
+	Deployment string
+	Optional   bool
+}
+
+// Webhook is an admission webhook configuration of the mesh.
+type Webhook struct {
+	Name     string
+	Kind     string
+	Optional bool
+}
+
+// Endpoint is an address used by the mesh, Probe tells whether it answers.
+// An optional endpoint may be left unconfigured.
+type Endpoint struct {
+	Name     string
+	Address  string
+	Probe    func(ctx context.Context) error
+	Optional bool
+}
+
+type Options struct {
+	Components []Component
+	Webhooks   []Webhook
+	Endpoints  []Endpoint
+	// ProbeTimeout bounds each endpoint probe.
+	ProbeTimeout time.Duration
+}
+
+// Run checks every component, the endpoints are probed concurrently.
+func Run(ctx context.Context, kube kubernetes.Interface, opts Options) *Report {
+	report := &Report{Status: StateHealthy, Components: []Health{}, Namespaces: []Injection{}}
+
+	for _, component := range opts.Components {
+		report.add(checkComponent(ctx, kube, component))
+	}
+	for _, webhook := range opts.Webhooks {
+		report.add(checkWebhook(ctx, kube, webhook))
+	}
+	for _, health := range probeEndpoints(ctx, opts) {
+		report.add(health)
+	}
+
+	injections, err := checkInjection(ctx, kube)
+	if err != nil {
+		report.add(Health{Name: "sidecar-injection", Kind: KindInjection, Status: StateUnknown, Reason: err.Error()})
+	}
+	for _, injection := range injections {
+		report.Namespaces = append(report.Namespaces, injection)
+		report.worsen(injection.Status)
+	}
+	return report
+}
+
+// DeploymentReady tells whether every replica of the latest generation is
+// available, the message explains why not.
+func DeploymentReady(deployment *appsv1.Deployment) (bool, string) {
+	replicas := desiredReplicas(deployment)
+	status := deployment.Status
+	if status.ObservedGeneration < deployment.Generation {
+		return false, "waiting for the rollout to be observed"
+	}
+	if status.UpdatedReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d replicas updated", status.UpdatedReplicas, replicas)
+	}
+	if status.AvailableReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d replicas available", status.AvailableReplicas, replicas)
+	}
+	return true, ""
+}
+
+func desiredReplicas(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas != nil {
+		return *deployment.Spec.Replicas
+	}
+	return 1
+}
+
+func checkComponent(ctx context.Context, kube kubernetes.Interface, component Component) Health {
+	health := Health{Name: component.Name, Kind: KindDeployment, Status: StateHealthy}
+
+	deployment, err := kube.AppsV1().Deployments(component.Namespace).Get(ctx, component.Deployment, metav1.GetOptions{})
+	switch {
+	case k8serror.IsNotFound(err) && component.Optional:
+		health.Status = StateDisabled
+		return health
+	case k8serror.IsNotFound(err):
+		health.Status = StateUnhealthy
+		health.Reason = fmt.Sprintf("deployment %s/%s不存在", component.Namespace, component.Deployment)
+		return health
+	case err != nil:
+		health.Status = StateUnknown
+		health.Reason = err.Error()
+		return health
+	}
+
+	health.Replicas = &Replicas{Ready: deployment.Status.ReadyReplicas, Desired: desiredReplicas(deployment)}
+	if ready, message := DeploymentReady(deployment); !ready {
+		health.Status = StateDegraded
+		if deployment.Status.AvailableReplicas == 0 {
+			health.Status = StateUnhealthy
+		}
+		health.Reason = message
+	}
+	return health
+}
+
+func checkWebhook(ctx context.Context, kube kubernetes.Interface, webhook Webhook) Health {
+	health := Health{Name: webhook.Name, Kind: KindWebhook, Status: StateHealthy}
+
+	var configs []webhookConfig
+	var err error
+	switch webhook.Kind {
+	case WebhookMutating:
+		configs, err = mutatingConfigs(ctx, kube, webhook.Name)
+	default:
+		configs, err = validatingConfigs(ctx, kube, webhook.Name)
+	}
+	switch {
+	case k8serror.IsNotFound(err) && webhook.Optional:
+		health.Status = StateDisabled
+		return health
+	case k8serror.IsNotFound(err):
+		health.Status = StateUnhealthy
+		health.Reason = fmt.Sprintf("%s webhook %s不存在", webhook.Kind, webhook.Name)
+		return health
+	case err != nil:
+		health.Status = StateUnknown
+		health.Reason = err.Error()
+		return health
+	}
+
+	if len(configs) == 0 {
+		health.Status = StateUnhealthy
+		health.Reason = "webhook配置为空"
+		return health
+	}
+	for _, config := range configs {
+		if len(config.CABundle) == 0 {
+			health.Status = StateUnhealthy
+			health.Reason = fmt.Sprintf("webhook %s未配置证书", config.Name)
+			return health
+		}
+		if config.Service == nil {
+			continue
+		}
+		ready, err := serviceReady(ctx, kube, config.Service.Namespace, config.Service.Name)
+		if err != nil {
+			health.Status = StateUnknown
+			health.Reason = err.Error()
+			return health
+		}
+		if !ready {
+			health.Status = StateUnhealthy
+			health.Reason = fmt.Sprintf("webhook %s的服务%s/%s没有可用实例", config.Name, config.Service.Namespace, config.Service.Name)
+			return health
+		}
+	}
+	return health
+}
+
+type webhookConfig struct {
+	Name     string
+	CABundle []byte
+	Service  *serviceReference
+}
+
+type serviceReference struct {
+	Namespace string
+	Name      string
+}
+
+func mutatingConfigs(ctx context.Context, kube kubernetes.Interface, name string) ([]webhookConfig, error) {
+	configuration, err := kube.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	configs := []webhookConfig{}
+	for _, webhook := range configuration.Webhooks {
+		config := webhookConfig{Name: webhook.Name, CABundle: webhook.ClientConfig.CABundle}
+		if service := webhook.ClientConfig.Service; service != nil {
+			config.Service = &serviceReference{Namespace: service.Namespace, Name: service.Name}
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+func validatingConfigs(ctx context.Context, kube kubernetes.Interface, name string) ([]webhookConfig, error) {
+	configuration, err := kube.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	configs := []webhookConfig{}
+	for _, webhook := range configuration.Webhooks {
+		config := webhookConfig{Name: webhook.Name, CABundle: webhook.ClientConfig.CABundle}
+		if service := webhook.ClientConfig.Service; service != nil {
+			config.Service = &serviceReference{Namespace: service.Namespace, Name: service.Name}
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+func serviceReady(ctx context.Context, kube kubernetes.Interface, namespace, name string) (bool, error) {
+	endpoints, err := kube.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func probeEndpoints(ctx context.Context, opts Options) []Health {
+	timeout := opts.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+
+	results := make([]Health, len(opts.Endpoints))
+	done := make(chan struct{}, len(opts.Endpoints))
+	for i, endpoint := range opts.Endpoints {
+		go func(i int, endpoint Endpoint) {
+			defer func() { done <- struct{}{} }()
+
+			health := Health{Name: endpoint.Name, Kind: KindEndpoint, Status: StateHealthy}
+			if len(endpoint.Address) == 0 || endpoint.Probe == nil {
+				health.Status = StateUnknown
+				if endpoint.Optional {
+					health.Status = StateDisabled
+				}
+				health.Reason = "地址未配置"
+				results[i] = health
+				return
+			}
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := endpoint.Probe(probeCtx); err != nil {
+				health.Status = StateUnhealthy
+				health.Reason = fmt.Sprintf("%s无法访问: %s", endpoint.Address, err)
+			}
+			results[i] = health
+		}(i, endpoint)
+	}
+	for range opts.Endpoints {
+		<-done
+	}
+	return results
+}
+
+// HTTPProbe succeeds when the address answers without a server error, the
+// scheme defaults to http.
+func HTTPProbe(client *http.Client, address string) func(ctx context.Context) error {
+	url := address
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("status %d", response.StatusCode)
+		}
+		return nil
+	}
+}
+
+// TCPProbe succeeds when the address accepts a connection.
+func TCPProbe(address string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		dialer := &net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+func checkInjection(ctx context.Context, kube kubernetes.Interface) ([]Injection, error) {
+	namespaces, err := kube.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	injections := []Injection{}
+	for _, namespace := range namespaces.Items {
+		rev, revisioned := namespace.Labels[revision.RevisionLabel]
+		if namespace.Labels[revision.InjectionLabel] != "enabled" && !revisioned {
+			continue
+		}
+		injection := Injection{Namespace: namespace.Name, Revision: rev, Status: StateHealthy}
+
+		pods, err := kube.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			injection.Status = StateUnknown
+			injection.Reason = err.Error()
+			injections = append(injections, injection)
+			continue
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Status.Phase != corev1.PodRunning || pod.Annotations[InjectAnnotation] == "false" {
+				continue
+			}
+			injection.Pods++
+			for _, container := range pod.Spec.Containers {
+				if container.Name == revision.ProxyContainer {
+					injection.Injected++
+					break
+				}
+			}
+		}
+		if injection.Injected < injection.Pods {
+			injection.Status = StateDegraded
+			injection.Reason = fmt.Sprintf("%d个Pod未注入sidecar，需要重启", injection.Pods-injection.Injected)
+		}
+		injections = append(injections, injection)
+	}
+	return injections, nil
+}
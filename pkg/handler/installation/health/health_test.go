@@ -0,0 +1,191 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/huhenry/hej/pkg/handler/installation/health"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func deployment(name string, replicas, available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			UpdatedReplicas:   replicas,
+			ReadyReplicas:     available,
+			AvailableReplicas: available,
+		},
+	}
+}
+
+func injector(caBundle []byte) *admissionv1.MutatingWebhookConfiguration {
+	return &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-sidecar-injector"},
+		Webhooks: []admissionv1.MutatingWebhook{{
+			Name: "sidecar-injector.istio.io",
+			ClientConfig: admissionv1.WebhookClientConfig{
+				CABundle: caBundle,
+				Service:  &admissionv1.ServiceReference{Namespace: "istio-system", Name: "istiod"},
+			},
+		}},
+	}
+}
+
+func istiodEndpoints(ready bool) *corev1.Endpoints {
+	endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "istiod", Namespace: "istio-system"}}
+	if ready {
+		endpoints.Subsets = []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}}
+	}
+	return endpoints
+}
+
+func pod(namespace, name string, injected bool, annotations map[string]string) *corev1.Pod {
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if injected {
+		p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "istio-proxy"})
+	}
+	return p
+}
+
+func find(report *health.Report, name string) health.Health {
+	for _, h := range report.Components {
+		if h.Name == name {
+			return h
+		}
+	}
+	Fail("component " + name + " not found")
+	return health.Health{}
+}
+
+var _ = Describe("Run", func() {
+	var (
+		objects []runtime.Object
+		opts    health.Options
+	)
+
+	BeforeEach(func() {
+		objects = []runtime.Object{
+			deployment("istiod", 1, 1),
+			deployment("istio-ingressgateway", 2, 2),
+			injector([]byte("ca")),
+			istiodEndpoints(true),
+		}
+		opts = health.Options{
+			Components: []health.Component{
+				{Name: "istiod", Namespace: "istio-system", Deployment: "istiod"},
+				{Name: "ingressgateway", Namespace: "istio-system", Deployment: "istio-ingressgateway"},
+				{Name: "egressgateway", Namespace: "istio-system", Deployment: "istio-egressgateway", Optional: true},
+			},
+			Webhooks: []health.Webhook{
+				{Name: "istio-sidecar-injector", Kind: health.WebhookMutating},
+				{Name: "istiod-istio-system", Kind: health.WebhookValidating, Optional: true},
+			},
+		}
+	})
+
+	run := func() *health.Report {
+		return health.Run(context.TODO(), fake.NewSimpleClientset(objects...), opts)
+	}
+
+	It("is healthy when everything runs", func() {
+		report := run()
+		Expect(report.Status).To(Equal(health.StateHealthy))
+		Expect(find(report, "ingressgateway").Replicas).To(Equal(&health.Replicas{Ready: 2, Desired: 2}))
+		Expect(find(report, "egressgateway").Status).To(Equal(health.StateDisabled))
+		Expect(find(report, "istiod-istio-system").Status).To(Equal(health.StateDisabled))
+	})
+
+	It("reports the replicas of a degraded deployment", func() {
+		objects[1] = deployment("istio-ingressgateway", 2, 1)
+		report := run()
+		Expect(report.Status).To(Equal(health.StateDegraded))
+		gateway := find(report, "ingressgateway")
+		Expect(gateway.Status).To(Equal(health.StateDegraded))
+		Expect(gateway.Reason).To(Equal("1 of 2 replicas available"))
+		Expect(gateway.Replicas).To(Equal(&health.Replicas{Ready: 1, Desired: 2}))
+
+		objects[0] = deployment("istiod", 1, 0)
+		Expect(find(run(), "istiod").Status).To(Equal(health.StateUnhealthy))
+	})
+
+	It("fails on a missing required deployment", func() {
+		objects = objects[1:]
+		report := run()
+		Expect(report.Status).To(Equal(health.StateUnhealthy))
+		Expect(find(report, "istiod").Reason).To(ContainSubstring("istio-system/istiod"))
+	})
+
+	It("checks the webhook certificate and service", func() {
+		objects[2] = injector(nil)
+		Expect(find(run(), "istio-sidecar-injector").Status).To(Equal(health.StateUnhealthy))
+
+		objects[2] = injector([]byte("ca"))
+		objects[3] = istiodEndpoints(false)
+		h := find(run(), "istio-sidecar-injector")
+		Expect(h.Status).To(Equal(health.StateUnhealthy))
+		Expect(h.Reason).To(ContainSubstring("istio-system/istiod"))
+
+		objects = objects[:2]
+		Expect(find(run(), "istio-sidecar-injector").Status).To(Equal(health.StateUnhealthy))
+	})
+
+	It("probes the endpoints", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		opts.Endpoints = []health.Endpoint{
+			{Name: "jaeger", Address: server.Listener.Addr().String(), Probe: health.HTTPProbe(server.Client(), server.Listener.Addr().String())},
+			{Name: "zookeeper", Address: listener.Addr().String(), Probe: health.TCPProbe(listener.Addr().String())},
+			{Name: "prometheus", Address: "prometheus", Probe: func(context.Context) error { return errors.New("refused") }},
+			{Name: "eureka"},
+			{Name: "skywalking", Optional: true},
+		}
+		report := run()
+		Expect(find(report, "jaeger").Status).To(Equal(health.StateHealthy))
+		Expect(find(report, "zookeeper").Status).To(Equal(health.StateHealthy))
+		prometheus := find(report, "prometheus")
+		Expect(prometheus.Status).To(Equal(health.StateUnhealthy))
+		Expect(prometheus.Reason).To(ContainSubstring("refused"))
+		Expect(find(report, "eureka").Status).To(Equal(health.StateUnknown))
+		Expect(find(report, "skywalking").Status).To(Equal(health.StateDisabled))
+		Expect(report.Status).To(Equal(health.StateUnhealthy))
+	})
+
+	It("reports the sidecar coverage of injected namespaces", func() {
+		objects = append(objects,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{"istio-injection": "enabled"}}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "canary", Labels: map[string]string{"istio.io/rev": "1-8-2"}}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+			pod("app", "a1", true, nil),
+			pod("app", "a2", false, nil),
+			pod("app", "a3", false, map[string]string{"sidecar.istio.io/inject": "false"}),
+			pod("canary", "c1", true, nil),
+			pod("plain", "p1", false, nil),
+		)
+		report := run()
+		Expect(report.Namespaces).To(ConsistOf(
+			health.Injection{Namespace: "app", Pods: 2, Injected: 1, Status: health.StateDegraded, Reason: "1个Pod未注入sidecar，需要重启"},
+			health.Injection{Namespace: "canary", Revision: "1-8-2", Pods: 1, Injected: 1, Status: health.StateHealthy},
+		))
+		Expect(report.Status).To(Equal(health.StateDegraded))
+	})
+})
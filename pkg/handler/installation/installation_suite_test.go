@@ -0,0 +1,13 @@
+package installation_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInstallation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Installation Suite")
+}
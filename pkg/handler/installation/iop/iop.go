@@ -0,0 +1,156 @@
+package iop
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type GatewayKind string
+
+const (
+	IngressGateways GatewayKind = "ingressGateways"
+	EgressGateways  GatewayKind = "egressGateways"
+
+	DefaultIngressGateway = "istio-ingressgateway"
+	DefaultEgressGateway  = "istio-egressgateway"
+)
+
+// Gateway is a gateway of the IstioOperator components.
+type Gateway struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Enabled   bool              `json:"enabled"`
+	Label     map[string]string `json:"label,omitempty"`
+}
+
+// Component is a component or an addon of the IstioOperator.
+type Component struct {
+	Enabled   bool   `json:"enabled"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// NotFoundError is returned for a gateway missing from the IstioOperator.
+type NotFoundError struct {
+	Kind GatewayKind
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Kind, e.Name)
+}
+
+// IstioOperator reads and changes the components of an IstioOperator kept as
+// unstructured, the fields it does not know are left untouched.
+type IstioOperator struct {
+	un *unstructured.Unstructured
+}
+
+func New(un *unstructured.Unstructured) *IstioOperator {
+	return &IstioOperator{un: un}
+}
+
+// Unstructured is the IstioOperator with the changes made so far.
+func (o *IstioOperator) Unstructured() *unstructured.Unstructured {
+	return o.un
+}
+
+// decode converts the field into out through json, it tells whether the
+// field is set.
+func (o *IstioOperator) decode(out interface{}, fields ...string) (bool, error) {
+	value, found, err := unstructured.NestedFieldNoCopy(o.un.Object, fields...)
+	if err != nil || !found || value == nil {
+		return false, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return false, fmt.Errorf("%s of %s is invalid: %s", joinFields(fields), o.un.GetName(), err)
+	}
+	return true, nil
+}
+
+func joinFields(fields []string) string {
+	return strings.Join(fields, ".")
+}
+
+// Gateways returns the gateways of the kind in declaration order.
+func (o *IstioOperator) Gateways(kind GatewayKind) ([]Gateway, error) {
+	gateways := []Gateway{}
+	if _, err := o.decode(&gateways, "spec", "components", string(kind)); err != nil {
+		return nil, err
+	}
+	return gateways, nil
+}
+
+// Gateway returns the named gateway of the kind or a NotFoundError.
+func (o *IstioOperator) Gateway(kind GatewayKind, name string) (*Gateway, error) {
+	gateways, err := o.Gateways(kind)
+	if err != nil {
+		return nil, err
+	}
+	for i := range gateways {
+		if gateways[i].Name == name {
+			return &gateways[i], nil
+		}
+	}
+	return nil, &NotFoundError{Kind: kind, Name: name}
+}
+
+// SetGatewayEnabled switches the named gateway, a missing gateway is added
+// when it is enabled.
+func (o *IstioOperator) SetGatewayEnabled(kind GatewayKind, name string, enabled bool) error {
+	fields := []string{"spec", "components", string(kind)}
+	list, _, err := unstructured.NestedSlice(o.un.Object, fields...)
+	if err != nil {
+		return err
+	}
+
+	for i, item := range list {
+		gateway, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s of %s is invalid: item %d is not an object", joinFields(fields), o.un.GetName(), i)
+		}
+		if gateway["name"] == name {
+			gateway["enabled"] = enabled
+			return unstructured.SetNestedSlice(o.un.Object, list, fields...)
+		}
+	}
+
+	if !enabled {
+		return &NotFoundError{Kind: kind, Name: name}
+	}
+	list = append(list, map[string]interface{}{"name": name, "enabled": true})
+	return unstructured.SetNestedSlice(o.un.Object, list, fields...)
+}
+
+// Component returns the component, such as pilot or cni, it tells whether
+// the component is declared.
+func (o *IstioOperator) Component(name string) (Component, bool, error) {
+	component := Component{}
+	found, err := o.decode(&component, "spec", "components", name)
+	return component, found, err
+}
+
+// SetComponentEnabled switches the component.
+func (o *IstioOperator) SetComponentEnabled(name string, enabled bool) error {
+	return unstructured.SetNestedField(o.un.Object, enabled, "spec", "components", name, "enabled")
+}
+
+// Addons returns the addon components, such as kiali or tracing, by name.
+func (o *IstioOperator) Addons() (map[string]Component, error) {
+	addons := map[string]Component{}
+	if _, err := o.decode(&addons, "spec", "addonComponents"); err != nil {
+		return nil, err
+	}
+	return addons, nil
+}
+
+// SetAddonEnabled switches the addon component.
+func (o *IstioOperator) SetAddonEnabled(name string, enabled bool) error {
+	return unstructured.SetNestedField(o.un.Object, enabled, "spec", "addonComponents", name, "enabled")
+}
@@ -0,0 +1,13 @@
+package iop_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestIop(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Iop Suite")
+}
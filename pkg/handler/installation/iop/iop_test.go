@@ -0,0 +1,124 @@
+package iop_test
+
+import (
+	"errors"
+
+	"github.com/huhenry/hej/pkg/handler/installation/iop"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func istioOperator() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "install.istio.io/v1alpha1",
+		"kind":       "IstioOperator",
+		"metadata":   map[string]interface{}{"name": "istiocontrolplane-default", "namespace": "istio-system"},
+		"spec": map[string]interface{}{
+			"components": map[string]interface{}{
+				"pilot": map[string]interface{}{"enabled": true},
+				"ingressGateways": []interface{}{
+					map[string]interface{}{"name": "istio-ingressgateway", "enabled": true},
+				},
+				"egressGateways": []interface{}{
+					map[string]interface{}{
+						"name":    "istio-egressgateway",
+						"enabled": false,
+						"k8s":     map[string]interface{}{"replicaCount": int64(2)},
+					},
+					map[string]interface{}{"name": "egress-payment", "namespace": "payment", "enabled": true},
+				},
+			},
+			"addonComponents": map[string]interface{}{
+				"kiali":   map[string]interface{}{"enabled": true},
+				"tracing": map[string]interface{}{"enabled": false},
+			},
+		},
+	}}
+}
+
+var _ = Describe("IstioOperator", func() {
+	var operator *iop.IstioOperator
+
+	BeforeEach(func() {
+		operator = iop.New(istioOperator())
+	})
+
+	It("reads the gateways", func() {
+		gateways, err := operator.Gateways(iop.EgressGateways)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gateways).To(Equal([]iop.Gateway{
+			{Name: "istio-egressgateway"},
+			{Name: "egress-payment", Namespace: "payment", Enabled: true},
+		}))
+
+		gateway, err := operator.Gateway(iop.IngressGateways, "istio-ingressgateway")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gateway.Enabled).To(BeTrue())
+
+		_, err = operator.Gateway(iop.IngressGateways, "missing")
+		var notFound *iop.NotFoundError
+		Expect(errors.As(err, &notFound)).To(BeTrue())
+	})
+
+	It("reads a missing gateway list as empty", func() {
+		unstructured.RemoveNestedField(operator.Unstructured().Object, "spec", "components", "egressGateways")
+		gateways, err := operator.Gateways(iop.EgressGateways)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gateways).To(BeEmpty())
+	})
+
+	It("fails on a malformed gateway list", func() {
+		Expect(unstructured.SetNestedField(operator.Unstructured().Object, "broken", "spec", "components", "egressGateways")).To(Succeed())
+		_, err := operator.Gateways(iop.EgressGateways)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("switches a named gateway and keeps the other fields", func() {
+		Expect(operator.SetGatewayEnabled(iop.EgressGateways, "istio-egressgateway", true)).To(Succeed())
+		Expect(operator.SetGatewayEnabled(iop.EgressGateways, "egress-payment", false)).To(Succeed())
+
+		gateways, err := operator.Gateways(iop.EgressGateways)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gateways[0].Enabled).To(BeTrue())
+		Expect(gateways[1].Enabled).To(BeFalse())
+
+		list, _, err := unstructured.NestedSlice(operator.Unstructured().Object, "spec", "components", "egressGateways")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list[0]).To(HaveKeyWithValue("k8s", map[string]interface{}{"replicaCount": int64(2)}))
+	})
+
+	It("adds an enabled gateway but does not disable a missing one", func() {
+		Expect(operator.SetGatewayEnabled(iop.EgressGateways, "egress-new", true)).To(Succeed())
+		gateway, err := operator.Gateway(iop.EgressGateways, "egress-new")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gateway.Enabled).To(BeTrue())
+
+		err = operator.SetGatewayEnabled(iop.EgressGateways, "egress-missing", false)
+		var notFound *iop.NotFoundError
+		Expect(errors.As(err, &notFound)).To(BeTrue())
+	})
+
+	It("reads and switches components and addons", func() {
+		pilot, found, err := operator.Component("pilot")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(pilot.Enabled).To(BeTrue())
+
+		_, found, err = operator.Component("cni")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+		Expect(operator.SetComponentEnabled("cni", true)).To(Succeed())
+		cni, found, _ := operator.Component("cni")
+		Expect(found).To(BeTrue())
+		Expect(cni.Enabled).To(BeTrue())
+
+		Expect(operator.SetAddonEnabled("tracing", true)).To(Succeed())
+		addons, err := operator.Addons()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addons).To(Equal(map[string]iop.Component{
+			"kiali":   {Enabled: true},
+			"tracing": {Enabled: true},
+		}))
+	})
+})
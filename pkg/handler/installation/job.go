@@ -0,0 +1,312 @@
+package installation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"k8s.io/client-go/kubernetes"
+)
+
+type JobState string
+
+const (
+	JobPending   JobState = "Pending"
+	JobRunning   JobState = "Running"
+	JobSucceeded JobState = "Succeeded"
+	JobFailed    JobState = "Failed"
+
+	// MaxFinishedJobs bounds the finished jobs kept for polling, the oldest
+	// are dropped first.
+	MaxFinishedJobs = 100
+	// DefaultJobTimeout bounds a whole job, rollback included.
+	DefaultJobTimeout = 20 * time.Minute
+
+	jobStoreTimeout = 10 * time.Second
+)
+
+// JobStep is the progress of one step, as reported to the client.
+type JobStep struct {
+	Name       string   `json:"name"`
+	State      JobState `json:"state"`
+	Message    string   `json:"message,omitempty"`
+	StartedAt  int64    `json:"startedAt,omitempty"`
+	FinishedAt int64    `json:"finishedAt,omitempty"`
+}
+
+// Job is a servicemesh operation running in the background on one cluster.
+// Deadline is when the job is considered abandoned if it is still not over.
+type Job struct {
+	ID         string    `json:"id"`
+	Cluster    string    `json:"cluster"`
+	Operation  string    `json:"operation"`
+	User       string    `json:"user,omitempty"`
+	State      JobState  `json:"state"`
+	Steps      []JobStep `json:"steps"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  int64     `json:"createdAt"`
+	FinishedAt int64     `json:"finishedAt,omitempty"`
+	Deadline   int64     `json:"deadline,omitempty"`
+}
+
+func (j *Job) copy() *Job {
+	c := *j
+	c.Steps = append([]JobStep{}, j.Steps...)
+	return &c
+}
+
+// Finished tells whether the job is over.
+func (j *Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed
+}
+
+// expired tells whether the job was abandoned, the replica running it having
+// stopped before it was over.
+func (j *Job) expired(now time.Time) bool {
+	return !j.Finished() && j.Deadline > 0 && now.Unix() > j.Deadline
+}
+
+// abandon marks a job which expired as failed.
+func abandon(job *Job) {
+	job.State = JobFailed
+	job.Error = "任务执行中断"
+}
+
+func jobConflict(job *Job) error {
+	return customErrors.Conflict(fmt.Sprintf("集群%s正在执行%s任务%s，请稍后再试", job.Cluster, job.Operation, job.ID))
+}
+
+// Step is one unit of work of a job.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// JobStore keeps the job of each cluster where every replica sees it, it is
+// the lock of the cluster as well.
+type JobStore interface {
+	// Acquire records the job as the one of its cluster, it fails with a
+	// conflict while the cluster has another job which is not over.
+	Acquire(ctx context.Context, job *Job) error
+	// Save records the progress of the job.
+	Save(ctx context.Context, job *Job) error
+	// Load returns the job of the cluster with the id, the current one or a
+	// previous one still kept, nil when it is unknown.
+	Load(ctx context.Context, cluster, id string) (*Job, error)
+}
+
+// JobManager runs at most one job per cluster and keeps the finished jobs for
+// polling. With a store the lock and the jobs are shared by the replicas,
+// otherwise they are local to the process.
+type JobManager struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	store    JobStore
+	jobs     map[string]*Job
+	finished []string
+	active   map[string]string
+}
+
+func NewJobManager(timeout time.Duration, store JobStore) *JobManager {
+	return &JobManager{
+		timeout: timeout,
+		store:   store,
+		jobs:    make(map[string]*Job),
+		active:  make(map[string]string),
+	}
+}
+
+var (
+	jobManagerOnce sync.Once
+	jobManager     *JobManager
+)
+
+// jobs returns the job manager of the handlers, the jobs are kept in the
+// clusters they run on.
+func jobs(mgr multiCluster.Manager) *JobManager {
+	jobManagerOnce.Do(func() {
+		jobManager = NewJobManager(DefaultJobTimeout, NewClusterJobStore(func(cluster string) (kubernetes.Interface, error) {
+			client, err := mgr.Client(cluster)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}))
+	})
+	return jobManager
+}
+
+// Start runs the steps in order in the background. When a step fails the
+// remaining steps are skipped and rollback, if any, is run and recorded as a
+// step of its own. It fails with a conflict when the cluster already runs a
+// job. finished, if any, is given the job once it is over.
+func (m *JobManager) Start(cluster, operation, user string, steps []Step, rollback *Step, finished func(job *Job)) (*Job, error) {
+	m.mu.Lock()
+	if id, ok := m.active[cluster]; ok {
+		running := m.jobs[id]
+		m.mu.Unlock()
+		return nil, jobConflict(running)
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        newJobID(),
+		Cluster:   cluster,
+		Operation: operation,
+		User:      user,
+		State:     JobPending,
+		CreatedAt: now.Unix(),
+		Deadline:  now.Add(m.timeout).Unix(),
+	}
+	for _, step := range steps {
+		job.Steps = append(job.Steps, JobStep{Name: step.Name, State: JobPending})
+	}
+	m.jobs[job.ID] = job
+	m.active[cluster] = job.ID
+	m.mu.Unlock()
+
+	if m.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), jobStoreTimeout)
+		err := m.store.Acquire(ctx, job.copy())
+		cancel()
+		if err != nil {
+			m.mu.Lock()
+			delete(m.jobs, job.ID)
+			delete(m.active, cluster)
+			m.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	go m.run(job, steps, rollback, finished)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return job.copy(), nil
+}
+
+// Get returns a snapshot of the job of the cluster, nil when it is unknown.
+// The jobs of the other replicas are read from the store, one which was
+// abandoned is reported as failed.
+func (m *JobManager) Get(ctx context.Context, cluster, id string) (*Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if ok && job.Cluster == cluster {
+		defer m.mu.Unlock()
+		return job.copy(), nil
+	}
+	m.mu.Unlock()
+
+	if m.store == nil {
+		return nil, nil
+	}
+	job, err := m.store.Load(ctx, cluster, id)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	if job.expired(time.Now()) {
+		abandon(job)
+	}
+	return job, nil
+}
+
+func (m *JobManager) run(job *Job, steps []Step, rollback *Step, finished func(job *Job)) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	m.update(job, func() { job.State = JobRunning })
+
+	var failure error
+	for i, step := range steps {
+		if failure = m.runStep(ctx, job, i, step); failure != nil {
+			break
+		}
+	}
+
+	if failure != nil && rollback != nil {
+		m.update(job, func() {
+			job.Steps = append(job.Steps, JobStep{Name: rollback.Name, State: JobPending})
+		})
+		if err := m.runStep(ctx, job, len(job.Steps)-1, *rollback); err != nil {
+			logger.Errorf("rollback %s job %s of cluster %s failed err: %s", job.Operation, job.ID, job.Cluster, err)
+		}
+	}
+
+	m.update(job, func() {
+		job.State = JobSucceeded
+		if failure != nil {
+			job.State = JobFailed
+			job.Error = failure.Error()
+		}
+		job.FinishedAt = time.Now().Unix()
+	})
+
+	m.mu.Lock()
+	delete(m.active, job.Cluster)
+	m.finished = append(m.finished, job.ID)
+	for len(m.finished) > MaxFinishedJobs {
+		delete(m.jobs, m.finished[0])
+		m.finished = m.finished[1:]
+	}
+	snapshot := job.copy()
+	m.mu.Unlock()
+
+	logger.Infof("%s job %s of cluster %s is %s", job.Operation, job.ID, job.Cluster, job.State)
+	if finished != nil {
+		finished(snapshot)
+	}
+}
+
+func (m *JobManager) runStep(ctx context.Context, job *Job, i int, step Step) error {
+	m.update(job, func() {
+		job.Steps[i].State = JobRunning
+		job.Steps[i].StartedAt = time.Now().Unix()
+	})
+
+	err := step.Run(ctx)
+
+	m.update(job, func() {
+		job.Steps[i].FinishedAt = time.Now().Unix()
+		job.Steps[i].State = JobSucceeded
+		if err != nil {
+			job.Steps[i].State = JobFailed
+			job.Steps[i].Message = err.Error()
+		}
+	})
+	if err != nil {
+		logger.Errorf("step %s of %s job %s of cluster %s failed err: %s", step.Name, job.Operation, job.ID, job.Cluster, err)
+	}
+	return err
+}
+
+// update changes the job and records it in the store, only the goroutine
+// running the job calls it so the saves are in order. A failure to save is
+// only logged, the job goes on.
+func (m *JobManager) update(job *Job, f func()) {
+	m.mu.Lock()
+	f()
+	snapshot := job.copy()
+	m.mu.Unlock()
+
+	if m.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jobStoreTimeout)
+	defer cancel()
+	if err := m.store.Save(ctx, snapshot); err != nil {
+		logger.Warnf("save %s job %s of cluster %s failed err: %s", job.Operation, job.ID, job.Cluster, err)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
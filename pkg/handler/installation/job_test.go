@@ -0,0 +1,227 @@
+package installation_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler/installation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("JobManager", func() {
+	var manager *installation.JobManager
+
+	BeforeEach(func() {
+		manager = installation.NewJobManager(time.Minute, nil)
+	})
+
+	finished := func(job *installation.Job) func() installation.JobState {
+		return func() installation.JobState {
+			current, err := manager.Get(context.Background(), job.Cluster, job.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).NotTo(BeNil())
+			return current.State
+		}
+	}
+
+	step := func(name string, err error) installation.Step {
+		return installation.Step{Name: name, Run: func(context.Context) error { return err }}
+	}
+
+	It("runs the steps in order", func() {
+		order := make(chan string, 2)
+		job, err := manager.Start("c1", "install", "admin", []installation.Step{
+			{Name: "a", Run: func(context.Context) error { order <- "a"; return nil }},
+			{Name: "b", Run: func(context.Context) error { order <- "b"; return nil }},
+		}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.Cluster).To(Equal("c1"))
+		Expect(job.User).To(Equal("admin"))
+		Expect(job.Steps).To(HaveLen(2))
+
+		Eventually(finished(job)).Should(Equal(installation.JobSucceeded))
+		Expect(<-order).To(Equal("a"))
+		Expect(<-order).To(Equal("b"))
+
+		job, _ = manager.Get(context.Background(), "c1", job.ID)
+		Expect(job.Error).To(BeEmpty())
+		Expect(job.FinishedAt).NotTo(BeZero())
+		for _, s := range job.Steps {
+			Expect(s.State).To(Equal(installation.JobSucceeded))
+		}
+	})
+
+	It("skips the remaining steps and records the rollback on failure", func() {
+		rollback := step("rollback", nil)
+		job, err := manager.Start("c1", "install", "", []installation.Step{
+			step("a", errors.New("boom")),
+			step("b", nil),
+		}, &rollback, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(finished(job)).Should(Equal(installation.JobFailed))
+
+		job, _ = manager.Get(context.Background(), "c1", job.ID)
+		Expect(job.Error).To(Equal("boom"))
+		Expect(job.Steps).To(HaveLen(3))
+		Expect(job.Steps[0].State).To(Equal(installation.JobFailed))
+		Expect(job.Steps[0].Message).To(Equal("boom"))
+		Expect(job.Steps[1].State).To(Equal(installation.JobPending))
+		Expect(job.Steps[2].Name).To(Equal("rollback"))
+		Expect(job.Steps[2].State).To(Equal(installation.JobSucceeded))
+	})
+
+	It("runs a single job per cluster", func() {
+		release := make(chan struct{})
+		job, err := manager.Start("c1", "install", "", []installation.Step{
+			{Name: "a", Run: func(context.Context) error { <-release; return nil }},
+		}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = manager.Start("c1", "uninstall", "", []installation.Step{step("a", nil)}, nil, nil)
+		Expect(err).To(HaveOccurred())
+		_, conflict := err.(*customErrors.ConflictError)
+		Expect(conflict).To(BeTrue())
+
+		other, err := manager.Start("c2", "uninstall", "", []installation.Step{step("a", nil)}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(finished(other)).Should(Equal(installation.JobSucceeded))
+
+		close(release)
+		Eventually(finished(job)).Should(Equal(installation.JobSucceeded))
+
+		_, err = manager.Start("c1", "uninstall", "", []installation.Step{step("a", nil)}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns snapshots and unknown jobs", func() {
+		job, err := manager.Start("c1", "install", "", []installation.Step{step("a", nil)}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		job.Steps[0].Name = "changed"
+
+		Eventually(finished(job)).Should(Equal(installation.JobSucceeded))
+		current, _ := manager.Get(context.Background(), "c1", job.ID)
+		Expect(current.Steps[0].Name).To(Equal("a"))
+
+		current, err = manager.Get(context.Background(), "c2", job.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current).To(BeNil())
+		current, err = manager.Get(context.Background(), "c1", "missing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current).To(BeNil())
+	})
+
+	It("gives the finished job to the callback", func() {
+		done := make(chan *installation.Job, 1)
+		_, err := manager.Start("c1", "install", "", []installation.Step{step("a", errors.New("boom"))}, nil,
+			func(job *installation.Job) { done <- job })
+		Expect(err).NotTo(HaveOccurred())
+
+		var job *installation.Job
+		Eventually(done).Should(Receive(&job))
+		Expect(job.State).To(Equal(installation.JobFailed))
+		Expect(job.Error).To(Equal("boom"))
+	})
+})
+
+var _ = Describe("ClusterJobStore", func() {
+	var (
+		kube             *fake.Clientset
+		replica, another *installation.JobManager
+	)
+
+	BeforeEach(func() {
+		clientset := fake.NewSimpleClientset()
+		kube = clientset
+		client := func(string) (kubernetes.Interface, error) { return clientset, nil }
+		replica = installation.NewJobManager(time.Minute, installation.NewClusterJobStore(client))
+		another = installation.NewJobManager(time.Minute, installation.NewClusterJobStore(client))
+	})
+
+	stored := func() *installation.Job {
+		cm, err := kube.CoreV1().ConfigMaps(installation.JobNamespace).Get(context.Background(), installation.JobConfigMap, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		job := &installation.Job{}
+		Expect(json.Unmarshal([]byte(cm.Data["job"]), job)).To(Succeed())
+		return job
+	}
+
+	It("locks the cluster for every replica", func() {
+		release := make(chan struct{})
+		job, err := replica.Start("c1", "install", "", []installation.Step{
+			{Name: "a", Run: func(context.Context) error { <-release; return nil }},
+		}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = another.Start("c1", "uninstall", "", []installation.Step{{Name: "a", Run: func(context.Context) error { return nil }}}, nil, nil)
+		_, conflict := err.(*customErrors.ConflictError)
+		Expect(conflict).To(BeTrue())
+
+		polled, err := another.Get(context.Background(), "c1", job.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(polled.Operation).To(Equal("install"))
+		Expect(polled.Finished()).To(BeFalse())
+
+		close(release)
+		Eventually(func() installation.JobState { return stored().State }).Should(Equal(installation.JobSucceeded))
+		polled, _ = another.Get(context.Background(), "c1", job.ID)
+		Expect(polled.State).To(Equal(installation.JobSucceeded))
+
+		_, err = another.Start("c1", "uninstall", "", []installation.Step{{Name: "a", Run: func(context.Context) error { return nil }}}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps the previous jobs for polling", func() {
+		first, err := replica.Start("c1", "install", "", []installation.Step{{Name: "a", Run: func(context.Context) error { return nil }}}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() installation.JobState { return stored().State }).Should(Equal(installation.JobSucceeded))
+
+		second, err := another.Start("c1", "uninstall", "", []installation.Step{{Name: "a", Run: func(context.Context) error { return nil }}}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() installation.JobState { return stored().State }).Should(Equal(installation.JobSucceeded))
+		Expect(stored().ID).To(Equal(second.ID))
+
+		polled, err := another.Get(context.Background(), "c1", first.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(polled).NotTo(BeNil())
+		Expect(polled.Operation).To(Equal("install"))
+		Expect(polled.State).To(Equal(installation.JobSucceeded))
+	})
+
+	It("replaces an abandoned job", func() {
+		abandoned := &installation.Job{
+			ID:        "abandoned",
+			Cluster:   "c1",
+			Operation: "install",
+			State:     installation.JobRunning,
+			Deadline:  time.Now().Add(-time.Minute).Unix(),
+		}
+		data, err := json.Marshal(abandoned)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = kube.CoreV1().ConfigMaps(installation.JobNamespace).Create(context.Background(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: installation.JobConfigMap, Namespace: installation.JobNamespace},
+			Data:       map[string]string{"job": string(data)},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		polled, err := replica.Get(context.Background(), "c1", "abandoned")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(polled.State).To(Equal(installation.JobFailed))
+
+		job, err := replica.Start("c1", "uninstall", "", []installation.Step{{Name: "a", Run: func(context.Context) error { return nil }}}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored().ID).To(Equal(job.ID))
+
+		polled, err = another.Get(context.Background(), "c1", "abandoned")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(polled.State).To(Equal(installation.JobFailed))
+	})
+})
@@ -0,0 +1,198 @@
+package installation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// JobNamespace and JobConfigMap locate the job of a cluster, kube-system
+	// since istio-system comes and goes with the mesh.
+	JobNamespace = "kube-system"
+	JobConfigMap = "hej-servicemesh-job"
+
+	// MaxStoredJobs bounds the previous jobs kept next to the current one in
+	// the config map, the oldest are dropped first.
+	MaxStoredJobs = 10
+
+	jobDataKey    = "job"
+	jobHistoryKey = "history"
+)
+
+// ClusterJobStore keeps the current job of a cluster in a config map of the
+// cluster, with the last previous jobs for polling. The config map is the
+// lock of the cluster, its job is only replaced once it is over or abandoned
+// and its resource version makes the replicas starting a job at once
+// conflict.
+type ClusterJobStore struct {
+	client func(cluster string) (kubernetes.Interface, error)
+	now    func() time.Time
+}
+
+func NewClusterJobStore(client func(cluster string) (kubernetes.Interface, error)) *ClusterJobStore {
+	return &ClusterJobStore{client: client, now: time.Now}
+}
+
+func (s *ClusterJobStore) Acquire(ctx context.Context, job *Job) error {
+	configMaps, err := s.configMaps(job.Cluster)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	cm, err := configMaps.Get(ctx, JobConfigMap, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: JobConfigMap, Namespace: JobNamespace},
+			Data:       map[string]string{jobDataKey: string(data)},
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if k8serror.IsAlreadyExists(err) {
+			return customErrors.Conflict(fmt.Sprintf("集群%s正在执行其他任务，请稍后再试", job.Cluster))
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := decodeJob(cm)
+	if err != nil {
+		logger.Warnf("replace broken job of cluster %s err: %s", job.Cluster, err)
+	} else if current != nil && !current.Finished() && !current.expired(s.now()) {
+		return jobConflict(current)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if current != nil {
+		if current.expired(s.now()) {
+			abandon(current)
+		}
+		history, err := decodeHistory(cm)
+		if err != nil {
+			logger.Warnf("drop broken job history of cluster %s err: %s", job.Cluster, err)
+		}
+		history = append([]*Job{current}, history...)
+		if len(history) > MaxStoredJobs {
+			history = history[:MaxStoredJobs]
+		}
+		historyData, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		cm.Data[jobHistoryKey] = string(historyData)
+	}
+	cm.Data[jobDataKey] = string(data)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	if k8serror.IsConflict(err) {
+		return customErrors.Conflict(fmt.Sprintf("集群%s正在执行其他任务，请稍后再试", job.Cluster))
+	}
+	return err
+}
+
+func (s *ClusterJobStore) Save(ctx context.Context, job *Job) error {
+	configMaps, err := s.configMaps(job.Cluster)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, JobConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// the job was abandoned and another one took the cluster
+		if current, err := decodeJob(cm); err == nil && current != nil && current.ID != job.ID {
+			return fmt.Errorf("cluster %s runs job %s", job.Cluster, current.ID)
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[jobDataKey] = string(data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *ClusterJobStore) Load(ctx context.Context, cluster, id string) (*Job, error) {
+	configMaps, err := s.configMaps(cluster)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := configMaps.Get(ctx, JobConfigMap, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := decodeJob(cm)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID == id {
+		return current, nil
+	}
+	history, err := decodeHistory(cm)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range history {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *ClusterJobStore) configMaps(cluster string) (typedcorev1.ConfigMapInterface, error) {
+	kube, err := s.client(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return kube.CoreV1().ConfigMaps(JobNamespace), nil
+}
+
+func decodeJob(cm *corev1.ConfigMap) (*Job, error) {
+	data, ok := cm.Data[jobDataKey]
+	if !ok || len(data) == 0 {
+		return nil, nil
+	}
+	job := &Job{}
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func decodeHistory(cm *corev1.ConfigMap) ([]*Job, error) {
+	data, ok := cm.Data[jobHistoryKey]
+	if !ok || len(data) == 0 {
+		return nil, nil
+	}
+	history := []*Job{}
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,64 @@
+package installation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/installation/preflight"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/kataras/iris/v12"
+	istiov1alpha1 "istio.io/istio/operator/pkg/apis/istio/v1alpha1"
+)
+
+// QueryParameterForce installs even though the preflight checks fail.
+const QueryParameterForce = "force"
+
+func runPreflight(ctx context.Context, mgr multiCluster.Manager, clusterName string, zookeeper bool) (*preflight.Report, error) {
+	kube, err := mgr.Client(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	crds, err := mgr.DynamicClient(clusterName, &preflight.CRDGVK)
+	if err != nil {
+		return nil, err
+	}
+	operators, err := mgr.DynamicClient(clusterName, &istiov1alpha1.IstioOperatorGVK)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := preflight.Clients{Kube: kube, CRDs: crds, IstioOperators: operators}
+	return preflight.Run(ctx, clients, preflight.DefaultOptions(DefaultInstallNamespace, IstioOperatorName, zookeeper)), nil
+}
+
+// Preflight reports whether the cluster is ready for the installation.
+func Preflight(mgr multiCluster.Manager, ctx iris.Context) {
+	clusterName := ctx.Params().Get("cluster")
+
+	report, err := runPreflight(ctx.Request().Context(), mgr, clusterName, true)
+	if err != nil {
+		logger.Errorf("preflight of cluster %s failed err: %s", clusterName, err)
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, report)
+}
+
+// failedChecks responds with the failed checks, it returns false when the
+// installation may go on.
+func failedChecks(ctx iris.Context, report *preflight.Report) bool {
+	if !report.Failed() || ctx.URLParamDefault(QueryParameterForce, "") == "true" {
+		return false
+	}
+
+	messages := []string{}
+	for _, check := range report.Checks {
+		if check.Result == preflight.ResultFail {
+			messages = append(messages, check.Message)
+		}
+	}
+	handler.ResponseMessageList(ctx, http.StatusBadRequest, messages)
+	return true
+}
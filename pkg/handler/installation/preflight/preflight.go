@@ -0,0 +1,399 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/huhenry/hej/pkg/log"
+)
+
+var logger = log.RegisterScope("installation-preflight")
+
+type Result string
+
+const (
+	ResultPass Result = "pass"
+	ResultWarn Result = "warn"
+	ResultFail Result = "fail"
+
+	CheckKubernetesVersion = "kubernetes-version"
+	CheckIstioCRDs         = "istio-crds"
+	CheckIstioOperator     = "istio-operator"
+	CheckNamespace         = "namespace"
+	CheckNodeResources     = "node-resources"
+	CheckWebhooks          = "webhooks"
+	CheckPorts             = "ports"
+
+	istioGroupSuffix = "istio.io"
+)
+
+// CRDGVK is the resource listed through the dynamic client to find the Istio CRDs.
+var CRDGVK = schema.GroupVersionKind{
+	Group:   "apiextensions.k8s.io",
+	Version: "v1",
+	Kind:    "CustomResourceDefinition",
+}
+
+// Check is the outcome of one preflight check.
+type Check struct {
+	Name    string `json:"name"`
+	Result  Result `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+// Report holds every check, Result is the worst of them.
+type Report struct {
+	Result Result  `json:"result"`
+	Checks []Check `json:"checks"`
+}
+
+// Failed tells whether the installation is expected to fail.
+func (r *Report) Failed() bool {
+	return r.Result == ResultFail
+}
+
+func (r *Report) add(name string, result Result, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Result: result, Message: fmt.Sprintf(format, args...)})
+	if result == ResultFail || (result == ResultWarn && r.Result == ResultPass) {
+		r.Result = result
+	}
+}
+
+// Clients are the clients of the checked cluster, as given by the multiCluster
+// manager, CRDs serves CRDGVK and IstioOperators serves the IstioOperator kind.
+type Clients struct {
+	Kube           kubernetes.Interface
+	CRDs           dynamic.NamespaceableResourceInterface
+	IstioOperators dynamic.NamespaceableResourceInterface
+}
+
+// Requirement is the resources requested by one component of the mesh.
+type Requirement struct {
+	Component string
+	CPU       resource.Quantity
+	Memory    resource.Quantity
+}
+
+type Options struct {
+	// MinKubernetesVersion is the oldest supported Kubernetes version.
+	MinKubernetesVersion string
+	// Namespace is where the mesh is installed.
+	Namespace string
+	// OperatorName is the IstioOperator created by the installation, any
+	// other IstioOperator belongs to a foreign installation.
+	OperatorName string
+	// Requirements are the requests of the installed components.
+	Requirements []Requirement
+	// GatewayPorts are the ports exposed by the ingress gateway.
+	GatewayPorts []int32
+}
+
+// DefaultOptions match the default profile installed by the operator.
+func DefaultOptions(namespace, operatorName string, zookeeper bool) Options {
+	opts := Options{
+		MinKubernetesVersion: "1.16.0",
+		Namespace:            namespace,
+		OperatorName:         operatorName,
+		Requirements: []Requirement{
+			{Component: "istiod", CPU: resource.MustParse("500m"), Memory: resource.MustParse("2Gi")},
+			{Component: "istio-ingressgateway", CPU: resource.MustParse("100m"), Memory: resource.MustParse("128Mi")},
+		},
+		GatewayPorts: []int32{80, 443, 15021, 15443},
+	}
+	if zookeeper {
+		opts.Requirements = append(opts.Requirements, Requirement{Component: "zookeeper", CPU: resource.MustParse("100m"), Memory: resource.MustParse("256Mi")})
+	}
+	return opts
+}
+
+// Run runs every check, a check which cannot query the cluster is reported
+// as a warning rather than stopping the others.
+func Run(ctx context.Context, clients Clients, opts Options) *Report {
+	report := &Report{Result: ResultPass, Checks: []Check{}}
+
+	checkKubernetesVersion(ctx, clients, opts, report)
+	checkIstioCRDs(ctx, clients, opts, report)
+	checkIstioOperator(ctx, clients, opts, report)
+	checkNamespace(ctx, clients, opts, report)
+	checkNodeResources(ctx, clients, opts, report)
+	checkWebhooks(ctx, clients, opts, report)
+	checkPorts(ctx, clients, opts, report)
+
+	for _, check := range report.Checks {
+		if check.Result != ResultPass {
+			logger.Infof("preflight check %s: %s %s", check.Name, check.Result, check.Message)
+		}
+	}
+	return report
+}
+
+func checkKubernetesVersion(ctx context.Context, clients Clients, opts Options, report *Report) {
+	info, err := clients.Kube.Discovery().ServerVersion()
+	if err != nil {
+		report.add(CheckKubernetesVersion, ResultWarn, "无法获取Kubernetes版本: %s", err)
+		return
+	}
+	current, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		report.add(CheckKubernetesVersion, ResultWarn, "无法解析Kubernetes版本%q: %s", info.GitVersion, err)
+		return
+	}
+	min := version.MustParseGeneric(opts.MinKubernetesVersion)
+	if current.LessThan(min) {
+		report.add(CheckKubernetesVersion, ResultFail, "Kubernetes版本%s低于最低要求%s", current, min)
+		return
+	}
+	report.add(CheckKubernetesVersion, ResultPass, "Kubernetes版本%s", current)
+}
+
+func checkIstioCRDs(ctx context.Context, clients Clients, opts Options, report *Report) {
+	list, err := clients.CRDs.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		report.add(CheckIstioCRDs, ResultWarn, "无法获取CRD: %s", err)
+		return
+	}
+	found := []string{}
+	for _, item := range list.Items {
+		if strings.HasSuffix(item.GetName(), "."+istioGroupSuffix) {
+			found = append(found, item.GetName())
+		}
+	}
+	if len(found) > 0 {
+		report.add(CheckIstioCRDs, ResultWarn, "集群已存在%d个Istio CRD，将被覆盖: %s", len(found), strings.Join(found, ", "))
+		return
+	}
+	report.add(CheckIstioCRDs, ResultPass, "")
+}
+
+func checkIstioOperator(ctx context.Context, clients Clients, opts Options, report *Report) {
+	list, err := clients.IstioOperators.List(ctx, metav1.ListOptions{})
+	switch {
+	// the kind is not served before the first installation
+	case k8serror.IsNotFound(err) || meta.IsNoMatchError(err):
+		report.add(CheckIstioOperator, ResultPass, "")
+		return
+	case err != nil:
+		report.add(CheckIstioOperator, ResultWarn, "无法获取IstioOperator: %s", err)
+		return
+	}
+	own := false
+	foreign := []string{}
+	for _, item := range list.Items {
+		// the revisions of an upgrade are named after the default one
+		if item.GetNamespace() == opts.Namespace &&
+			(item.GetName() == opts.OperatorName || strings.HasPrefix(item.GetName(), opts.OperatorName+"-")) {
+			own = true
+			continue
+		}
+		foreign = append(foreign, item.GetNamespace()+"/"+item.GetName())
+	}
+	switch {
+	case len(foreign) > 0:
+		report.add(CheckIstioOperator, ResultFail, "集群存在其他IstioOperator: %s", strings.Join(foreign, ", "))
+	case own:
+		report.add(CheckIstioOperator, ResultWarn, "Istio已安装，将重新安装")
+	default:
+		report.add(CheckIstioOperator, ResultPass, "")
+	}
+}
+
+func checkNamespace(ctx context.Context, clients Clients, opts Options, report *Report) {
+	namespace, err := clients.Kube.CoreV1().Namespaces().Get(ctx, opts.Namespace, metav1.GetOptions{})
+	switch {
+	case k8serror.IsNotFound(err):
+		report.add(CheckNamespace, ResultPass, "")
+	case err != nil:
+		report.add(CheckNamespace, ResultWarn, "无法获取命名空间%s: %s", opts.Namespace, err)
+	case namespace.Status.Phase == corev1.NamespaceTerminating:
+		report.add(CheckNamespace, ResultFail, "命名空间%s正在删除", opts.Namespace)
+	default:
+		report.add(CheckNamespace, ResultWarn, "命名空间%s已存在", opts.Namespace)
+	}
+}
+
+// checkNodeResources compares the requests of the components with what is
+// left on the ready and schedulable nodes. It fails when the cluster cannot
+// hold them all and warns when no single node can hold the largest one.
+func checkNodeResources(ctx context.Context, clients Clients, opts Options, report *Report) {
+	nodes, err := clients.Kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		report.add(CheckNodeResources, ResultWarn, "无法获取节点: %s", err)
+		return
+	}
+	pods, err := clients.Kube.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		report.add(CheckNodeResources, ResultWarn, "无法获取Pod: %s", err)
+		return
+	}
+
+	requested := map[string]corev1.ResourceList{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if len(pod.Spec.NodeName) == 0 || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		list, ok := requested[pod.Spec.NodeName]
+		if !ok {
+			list = corev1.ResourceList{}
+			requested[pod.Spec.NodeName] = list
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+				if quantity, ok := container.Resources.Requests[name]; ok {
+					sum := list[name]
+					sum.Add(quantity)
+					list[name] = sum
+				}
+			}
+		}
+	}
+
+	var freeCPU, freeMemory, maxCPU, maxMemory resource.Quantity
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Spec.Unschedulable || !nodeReady(node) {
+			continue
+		}
+		cpu := node.Status.Allocatable.Cpu().DeepCopy()
+		cpu.Sub(requested[node.Name][corev1.ResourceCPU])
+		memory := node.Status.Allocatable.Memory().DeepCopy()
+		memory.Sub(requested[node.Name][corev1.ResourceMemory])
+
+		freeCPU.Add(cpu)
+		freeMemory.Add(memory)
+		if cpu.Cmp(maxCPU) > 0 {
+			maxCPU = cpu
+		}
+		if memory.Cmp(maxMemory) > 0 {
+			maxMemory = memory
+		}
+	}
+
+	var needCPU, needMemory resource.Quantity
+	largest := Requirement{}
+	for _, requirement := range opts.Requirements {
+		needCPU.Add(requirement.CPU)
+		needMemory.Add(requirement.Memory)
+		if requirement.Memory.Cmp(largest.Memory) > 0 {
+			largest = requirement
+		}
+	}
+
+	switch {
+	case freeCPU.Cmp(needCPU) < 0 || freeMemory.Cmp(needMemory) < 0:
+		report.add(CheckNodeResources, ResultFail, "集群剩余资源不足，需要CPU %s 内存 %s，剩余CPU %s 内存 %s",
+			needCPU.String(), needMemory.String(), freeCPU.String(), freeMemory.String())
+	case maxCPU.Cmp(largest.CPU) < 0 || maxMemory.Cmp(largest.Memory) < 0:
+		report.add(CheckNodeResources, ResultWarn, "没有节点能容纳%s，需要CPU %s 内存 %s",
+			largest.Component, largest.CPU.String(), largest.Memory.String())
+	default:
+		report.add(CheckNodeResources, ResultPass, "剩余CPU %s 内存 %s", freeCPU.String(), freeMemory.String())
+	}
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkWebhooks finds the Istio webhooks, those served out of the install
+// namespace belong to another control plane and intercept the same resources.
+func checkWebhooks(ctx context.Context, clients Clients, opts Options, report *Report) {
+	foreign := []string{}
+	leftover := []string{}
+	classify := func(kind, name string, service *string) {
+		if !strings.Contains(name, "istio") {
+			return
+		}
+		if service != nil && *service != opts.Namespace {
+			foreign = append(foreign, kind+"/"+name)
+			return
+		}
+		leftover = append(leftover, kind+"/"+name)
+	}
+
+	mutating, err := clients.Kube.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		report.add(CheckWebhooks, ResultWarn, "无法获取Webhook: %s", err)
+		return
+	}
+	for _, configuration := range mutating.Items {
+		var namespace *string
+		for _, webhook := range configuration.Webhooks {
+			if webhook.ClientConfig.Service != nil {
+				namespace = &webhook.ClientConfig.Service.Namespace
+			}
+		}
+		classify("mutating", configuration.Name, namespace)
+	}
+
+	validating, err := clients.Kube.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		report.add(CheckWebhooks, ResultWarn, "无法获取Webhook: %s", err)
+		return
+	}
+	for _, configuration := range validating.Items {
+		var namespace *string
+		for _, webhook := range configuration.Webhooks {
+			if webhook.ClientConfig.Service != nil {
+				namespace = &webhook.ClientConfig.Service.Namespace
+			}
+		}
+		classify("validating", configuration.Name, namespace)
+	}
+
+	switch {
+	case len(foreign) > 0:
+		report.add(CheckWebhooks, ResultFail, "存在其他命名空间的Istio Webhook: %s", strings.Join(foreign, ", "))
+	case len(leftover) > 0:
+		report.add(CheckWebhooks, ResultWarn, "已存在Istio Webhook，将被覆盖: %s", strings.Join(leftover, ", "))
+	default:
+		report.add(CheckWebhooks, ResultPass, "")
+	}
+}
+
+// checkPorts finds the load balancers out of the install namespace which
+// already expose the ports of the ingress gateway.
+func checkPorts(ctx context.Context, clients Clients, opts Options, report *Report) {
+	services, err := clients.Kube.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		report.add(CheckPorts, ResultWarn, "无法获取Service: %s", err)
+		return
+	}
+
+	gatewayPorts := map[int32]bool{}
+	for _, port := range opts.GatewayPorts {
+		gatewayPorts[port] = true
+	}
+	conflicts := []string{}
+	for _, service := range services.Items {
+		if service.Namespace == opts.Namespace || service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		for _, port := range service.Spec.Ports {
+			if gatewayPorts[port.Port] {
+				conflicts = append(conflicts, fmt.Sprintf("%s/%s:%d", service.Namespace, service.Name, port.Port))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		report.add(CheckPorts, ResultWarn, "以下LoadBalancer占用了入口网关端口: %s", strings.Join(conflicts, ", "))
+		return
+	}
+	report.add(CheckPorts, ResultPass, "")
+}
@@ -0,0 +1,237 @@
+package preflight_test
+
+import (
+	"context"
+
+	"github.com/huhenry/hej/pkg/handler/installation/preflight"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	crdGVR      = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	operatorGVR = schema.GroupVersionResource{Group: "install.istio.io", Version: "v1alpha1", Resource: "istiooperators"}
+)
+
+func node(name, cpu, memory string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func pod(name, nodeName, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse(memory),
+				}},
+			}},
+		},
+	}
+}
+
+func unstructuredObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func find(report *preflight.Report, name string) preflight.Check {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	Fail("check " + name + " not found")
+	return preflight.Check{}
+}
+
+var _ = Describe("Run", func() {
+	var (
+		kubeObjects    []runtime.Object
+		dynamicObjects []runtime.Object
+		gitVersion     string
+		operatorsErr   error
+		opts           preflight.Options
+	)
+
+	BeforeEach(func() {
+		kubeObjects = []runtime.Object{node("n1", "4", "8Gi")}
+		dynamicObjects = nil
+		operatorsErr = nil
+		gitVersion = "v1.20.4"
+		opts = preflight.DefaultOptions("istio-system", "istiocontrolplane-default", true)
+	})
+
+	run := func() *preflight.Report {
+		kube := fake.NewSimpleClientset(kubeObjects...)
+		kube.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: gitVersion}
+
+		dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			crdGVR:      "CustomResourceDefinitionList",
+			operatorGVR: "IstioOperatorList",
+		}, dynamicObjects...)
+		if operatorsErr != nil {
+			dynamicClient.PrependReactor("list", operatorGVR.Resource, func(clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, operatorsErr
+			})
+		}
+
+		return preflight.Run(context.TODO(), preflight.Clients{
+			Kube:           kube,
+			CRDs:           dynamicClient.Resource(crdGVR),
+			IstioOperators: dynamicClient.Resource(operatorGVR),
+		}, opts)
+	}
+
+	It("passes on a fresh cluster", func() {
+		report := run()
+		Expect(report.Result).To(Equal(preflight.ResultPass))
+		Expect(report.Failed()).To(BeFalse())
+		Expect(report.Checks).To(HaveLen(7))
+	})
+
+	It("fails on an old Kubernetes", func() {
+		gitVersion = "v1.15.3"
+		report := run()
+		Expect(report.Failed()).To(BeTrue())
+		Expect(find(report, preflight.CheckKubernetesVersion).Result).To(Equal(preflight.ResultFail))
+	})
+
+	It("warns on an unknown Kubernetes version", func() {
+		gitVersion = ""
+		Expect(find(run(), preflight.CheckKubernetesVersion).Result).To(Equal(preflight.ResultWarn))
+	})
+
+	It("warns on existing Istio CRDs", func() {
+		dynamicObjects = []runtime.Object{
+			unstructuredObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "virtualservices.networking.istio.io"),
+			unstructuredObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "certificates.cert-manager.io"),
+		}
+		check := find(run(), preflight.CheckIstioCRDs)
+		Expect(check.Result).To(Equal(preflight.ResultWarn))
+		Expect(check.Message).To(ContainSubstring("virtualservices.networking.istio.io"))
+		Expect(check.Message).NotTo(ContainSubstring("cert-manager"))
+	})
+
+	It("fails on a foreign IstioOperator and warns on its own", func() {
+		dynamicObjects = []runtime.Object{
+			unstructuredObject("install.istio.io/v1alpha1", "IstioOperator", "istio-system", "istiocontrolplane-default"),
+			unstructuredObject("install.istio.io/v1alpha1", "IstioOperator", "istio-system", "istiocontrolplane-default-1-8-2"),
+		}
+		Expect(find(run(), preflight.CheckIstioOperator).Result).To(Equal(preflight.ResultWarn))
+
+		dynamicObjects = append(dynamicObjects, unstructuredObject("install.istio.io/v1alpha1", "IstioOperator", "other", "installed-state"))
+		check := find(run(), preflight.CheckIstioOperator)
+		Expect(check.Result).To(Equal(preflight.ResultFail))
+		Expect(check.Message).To(ContainSubstring("other/installed-state"))
+	})
+
+	It("passes when IstioOperator is not served and warns when it cannot be listed", func() {
+		operatorsErr = k8serror.NewNotFound(operatorGVR.GroupResource(), "")
+		Expect(find(run(), preflight.CheckIstioOperator).Result).To(Equal(preflight.ResultPass))
+
+		operatorsErr = k8serror.NewForbidden(operatorGVR.GroupResource(), "", nil)
+		check := find(run(), preflight.CheckIstioOperator)
+		Expect(check.Result).To(Equal(preflight.ResultWarn))
+		Expect(check.Message).To(ContainSubstring("forbidden"))
+	})
+
+	It("checks the install namespace", func() {
+		Expect(find(run(), preflight.CheckNamespace).Result).To(Equal(preflight.ResultPass))
+
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "istio-system"}}
+		kubeObjects = append(kubeObjects, namespace)
+		Expect(find(run(), preflight.CheckNamespace).Result).To(Equal(preflight.ResultWarn))
+
+		namespace.Status.Phase = corev1.NamespaceTerminating
+		Expect(find(run(), preflight.CheckNamespace).Result).To(Equal(preflight.ResultFail))
+	})
+
+	It("fails when the nodes cannot hold the components", func() {
+		kubeObjects = []runtime.Object{node("n1", "4", "8Gi"), pod("p1", "n1", "3900m", "1Gi")}
+		check := find(run(), preflight.CheckNodeResources)
+		Expect(check.Result).To(Equal(preflight.ResultFail))
+	})
+
+	It("ignores finished pods and unready nodes", func() {
+		finished := pod("p1", "n1", "3900m", "1Gi")
+		finished.Status.Phase = corev1.PodSucceeded
+		unready := node("n2", "64", "256Gi")
+		unready.Status.Conditions[0].Status = corev1.ConditionFalse
+		kubeObjects = []runtime.Object{node("n1", "4", "8Gi"), finished, unready}
+		check := find(run(), preflight.CheckNodeResources)
+		Expect(check.Result).To(Equal(preflight.ResultPass))
+	})
+
+	It("warns when no single node holds the largest component", func() {
+		kubeObjects = []runtime.Object{node("n1", "2", "1Gi"), node("n2", "2", "1536Mi")}
+		Expect(find(run(), preflight.CheckNodeResources).Result).To(Equal(preflight.ResultWarn))
+	})
+
+	It("fails on the webhooks of another control plane", func() {
+		webhook := func(name, namespace string) *admissionv1.MutatingWebhookConfiguration {
+			return &admissionv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Webhooks: []admissionv1.MutatingWebhook{{
+					Name:         "sidecar-injector.istio.io",
+					ClientConfig: admissionv1.WebhookClientConfig{Service: &admissionv1.ServiceReference{Namespace: namespace, Name: "istiod"}},
+				}},
+			}
+		}
+		kubeObjects = append(kubeObjects, webhook("istio-sidecar-injector", "istio-system"))
+		Expect(find(run(), preflight.CheckWebhooks).Result).To(Equal(preflight.ResultWarn))
+
+		kubeObjects = append(kubeObjects, webhook("istio-sidecar-injector-canary", "istio-canary"))
+		check := find(run(), preflight.CheckWebhooks)
+		Expect(check.Result).To(Equal(preflight.ResultFail))
+		Expect(check.Message).To(ContainSubstring("istio-sidecar-injector-canary"))
+	})
+
+	It("warns on load balancers exposing the gateway ports", func() {
+		kubeObjects = append(kubeObjects,
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "ingress"},
+				Spec: corev1.ServiceSpec{
+					Type:  corev1.ServiceTypeLoadBalancer,
+					Ports: []corev1.ServicePort{{Port: 443}},
+				},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+			},
+		)
+		check := find(run(), preflight.CheckPorts)
+		Expect(check.Result).To(Equal(preflight.ResultWarn))
+		Expect(check.Message).To(Equal("以下LoadBalancer占用了入口网关端口: ingress/nginx:443"))
+	})
+})
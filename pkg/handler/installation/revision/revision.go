@@ -0,0 +1,332 @@
+package revision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/huhenry/hej/pkg/log"
+)
+
+var logger = log.RegisterScope("installation-revision")
+
+const (
+	// DefaultTag is the version of an IstioOperator without tag.
+	DefaultTag = "1.6.0"
+
+	// PreviousAnnotation chains a revision to the one it upgraded, the
+	// default control plane has no revision.
+	PreviousAnnotation = "tpaas.troila.com/servicemesh.previous-revision"
+
+	InjectionLabel = "istio-injection"
+	RevisionLabel  = "istio.io/rev"
+	ProxyContainer = "istio-proxy"
+)
+
+var operatorResource = schema.GroupResource{Group: "install.istio.io", Resource: "istiooperators"}
+
+// Revision is one control plane of the mesh, the default control plane has
+// an empty name.
+type Revision struct {
+	Name     string `json:"name"`
+	Operator string `json:"operator"`
+	Version  string `json:"version"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// Workload is a pod whose sidecar does not run the active version.
+type Workload struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Owner     string `json:"owner,omitempty"`
+	Version   string `json:"version"`
+}
+
+type Status struct {
+	// Version is the version of the active control plane.
+	Version string `json:"version"`
+	// Revision is the active revision, empty for the default control plane.
+	Revision          string     `json:"revision"`
+	Revisions         []Revision `json:"revisions"`
+	OutdatedWorkloads []Workload `json:"outdatedWorkloads"`
+}
+
+// Name turns a version into a revision name, revisions are DNS labels.
+func Name(v string) (string, error) {
+	parsed, err := version.ParseGeneric(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(parsed.String(), ".", "-"), nil
+}
+
+// Manager upgrades the mesh with a canary control plane: every revision is an
+// IstioOperator reconciled by the in-cluster operator next to the default
+// one, the injected namespaces are then switched to the new revision. The
+// gateways stay with the control plane which installed them.
+type Manager struct {
+	Kube      kubernetes.Interface
+	Operators dynamic.NamespaceableResourceInterface
+	// Namespace is where the control planes are installed.
+	Namespace string
+	// Operator is the IstioOperator of the default control plane.
+	Operator string
+}
+
+func operatorVersion(un *unstructured.Unstructured) string {
+	tag, found, _ := unstructured.NestedFieldNoCopy(un.Object, "spec", "tag")
+	if !found || tag == nil || len(fmt.Sprint(tag)) == 0 {
+		return DefaultTag
+	}
+	return fmt.Sprint(tag)
+}
+
+// List returns the control planes from the default one to the active one.
+func (m *Manager) List(ctx context.Context) ([]Revision, error) {
+	list, err := m.Operators.Namespace(m.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var base *Revision
+	next := map[string]Revision{}
+	for i := range list.Items {
+		un := &list.Items[i]
+		name, _, _ := unstructured.NestedString(un.Object, "spec", "revision")
+		revision := Revision{
+			Name:     name,
+			Operator: un.GetName(),
+			Version:  operatorVersion(un),
+			Previous: un.GetAnnotations()[PreviousAnnotation],
+		}
+		switch {
+		case un.GetName() == m.Operator:
+			base = &revision
+		case len(name) > 0:
+			next[revision.Previous] = revision
+		}
+	}
+	if base == nil {
+		return nil, k8serror.NewNotFound(operatorResource, m.Operator)
+	}
+
+	revisions := []Revision{*base}
+	for {
+		revision, ok := next[revisions[len(revisions)-1].Name]
+		if !ok {
+			break
+		}
+		delete(next, revision.Previous)
+		revisions = append(revisions, revision)
+	}
+	for _, revision := range next {
+		logger.Warnf("revision %s is not chained to the active control plane", revision.Name)
+	}
+	return revisions, nil
+}
+
+// Active returns the control plane the injected namespaces use and the one
+// before it, which is nil for the default control plane.
+func (m *Manager) Active(ctx context.Context) (*Revision, *Revision, error) {
+	revisions, err := m.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	active := &revisions[len(revisions)-1]
+	if len(revisions) == 1 {
+		return active, nil, nil
+	}
+	return active, &revisions[len(revisions)-2], nil
+}
+
+// Create installs the control plane of the version next to the active one,
+// its IstioOperator is a copy of the active one without gateways.
+func (m *Manager) Create(ctx context.Context, v string) (*Revision, error) {
+	name, err := Name(v)
+	if err != nil {
+		return nil, err
+	}
+	active, _, err := m.Active(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := m.Operators.Namespace(m.Namespace).Get(ctx, active.Operator, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	un := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": current.GetAPIVersion(),
+		"kind":       current.GetKind(),
+	}}
+	un.SetNamespace(m.Namespace)
+	un.SetName(m.Operator + "-" + name)
+	un.SetAnnotations(map[string]string{PreviousAnnotation: active.Name})
+
+	spec, _, err := unstructured.NestedMap(current.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	spec["revision"] = name
+	spec["tag"] = v
+	unstructured.RemoveNestedField(spec, "components", "ingressGateways")
+	unstructured.RemoveNestedField(spec, "components", "egressGateways")
+	if err := unstructured.SetNestedMap(un.Object, spec, "spec"); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.Operators.Namespace(m.Namespace).Create(ctx, un, metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	return &Revision{Name: name, Operator: un.GetName(), Version: v, Previous: active.Name}, nil
+}
+
+// Switch moves the namespaces injected by one control plane to another, the
+// pods get the new sidecar once they are restarted.
+func (m *Manager) Switch(ctx context.Context, from, to *Revision) error {
+	selector := InjectionLabel + "=enabled"
+	if len(from.Name) > 0 {
+		selector = RevisionLabel + "=" + from.Name
+	}
+	namespaces, err := m.Kube.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces.Items {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			ns, err := m.Kube.CoreV1().Namespaces().Get(ctx, namespace.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if ns.Labels == nil {
+				ns.Labels = map[string]string{}
+			}
+			if len(to.Name) == 0 {
+				delete(ns.Labels, RevisionLabel)
+				ns.Labels[InjectionLabel] = "enabled"
+			} else {
+				delete(ns.Labels, InjectionLabel)
+				ns.Labels[RevisionLabel] = to.Name
+			}
+			_, err = m.Kube.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("switch namespace %s to revision %q failed: %s", namespace.Name, to.Name, err)
+		}
+	}
+	return nil
+}
+
+// Remove uninstalls the control plane of the revision, the default control
+// plane is never removed.
+func (m *Manager) Remove(ctx context.Context, revision *Revision) error {
+	if len(revision.Name) == 0 {
+		return fmt.Errorf("the default control plane can not be removed")
+	}
+	err := m.Operators.Namespace(m.Namespace).Delete(ctx, revision.Operator, metav1.DeleteOptions{})
+	if err != nil && !k8serror.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveAll moves the injected namespaces back to the default control plane
+// and removes every revision, the newest first, so the default one can be
+// uninstalled. It does nothing when the mesh is not installed.
+func (m *Manager) RemoveAll(ctx context.Context) error {
+	revisions, err := m.List(ctx)
+	if k8serror.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	base := &revisions[0]
+	for i := len(revisions) - 1; i > 0; i-- {
+		if err := m.Switch(ctx, &revisions[i], base); err != nil {
+			return err
+		}
+		if err := m.Remove(ctx, &revisions[i]); err != nil {
+			return fmt.Errorf("remove revision %s failed: %s", revisions[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Outdated lists the pods whose sidecar does not run the version.
+func (m *Manager) Outdated(ctx context.Context, v string) ([]Workload, error) {
+	pods, err := m.Kube.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := []Workload{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		proxyVersion, ok := sidecarVersion(pod)
+		if !ok || proxyVersion == v {
+			continue
+		}
+		workload := Workload{Namespace: pod.Namespace, Pod: pod.Name, Version: proxyVersion}
+		if len(pod.OwnerReferences) > 0 {
+			workload.Owner = pod.OwnerReferences[0].Kind + "/" + pod.OwnerReferences[0].Name
+		}
+		workloads = append(workloads, workload)
+	}
+	return workloads, nil
+}
+
+// sidecarVersion is the image tag of the proxy container.
+func sidecarVersion(pod *corev1.Pod) (string, bool) {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != ProxyContainer {
+			continue
+		}
+		image := container.Image
+		if i := strings.Index(image, "@"); i >= 0 {
+			image = image[:i]
+		}
+		if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+			return image[i+1:], true
+		}
+		return "latest", true
+	}
+	return "", false
+}
+
+// Status reports the control planes and the pods left on another version.
+func (m *Manager) Status(ctx context.Context) (*Status, error) {
+	revisions, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := revisions[len(revisions)-1]
+
+	workloads, err := m.Outdated(ctx, active.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{
+		Version:           active.Version,
+		Revision:          active.Name,
+		Revisions:         revisions,
+		OutdatedWorkloads: workloads,
+	}, nil
+}
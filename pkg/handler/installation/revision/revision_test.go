@@ -0,0 +1,197 @@
+package revision_test
+
+import (
+	"context"
+
+	"github.com/huhenry/hej/pkg/handler/installation/revision"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var operatorGVR = schema.GroupVersionResource{Group: "install.istio.io", Version: "v1alpha1", Resource: "istiooperators"}
+
+func defaultOperator() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "install.istio.io/v1alpha1",
+		"kind":       "IstioOperator",
+		"metadata": map[string]interface{}{
+			"namespace": "istio-system",
+			"name":      "istiocontrolplane-default",
+		},
+		"spec": map[string]interface{}{
+			"profile": "default",
+			"components": map[string]interface{}{
+				"pilot":           map[string]interface{}{"enabled": true},
+				"ingressGateways": []interface{}{map[string]interface{}{"name": "istio-ingressgateway"}},
+			},
+		},
+	}}
+}
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func sidecarPod(name, image string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "app",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d9c"}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "web", Image: "web:2.0"},
+			{Name: "istio-proxy", Image: image},
+		}},
+	}
+}
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx     context.Context
+		kube    *fake.Clientset
+		manager *revision.Manager
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		kube = fake.NewSimpleClientset(
+			namespace("app", map[string]string{"istio-injection": "enabled"}),
+			namespace("plain", nil),
+			sidecarPod("web-1", "docker.io/istio/proxyv2:1.6.0"),
+			sidecarPod("web-2", "registry:5000/istio/proxyv2:1.8.2"),
+		)
+		dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			operatorGVR: "IstioOperatorList",
+		}, defaultOperator())
+		manager = &revision.Manager{
+			Kube:      kube,
+			Operators: dynamicClient.Resource(operatorGVR),
+			Namespace: "istio-system",
+			Operator:  "istiocontrolplane-default",
+		}
+	})
+
+	labels := func(name string) map[string]string {
+		ns, err := kube.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return ns.Labels
+	}
+
+	It("names revisions after versions", func() {
+		name, err := revision.Name("1.8.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("1-8-2"))
+
+		_, err = revision.Name("latest")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports the default control plane", func() {
+		active, previous, err := manager.Active(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(previous).To(BeNil())
+		Expect(active.Name).To(BeEmpty())
+		Expect(active.Version).To(Equal(revision.DefaultTag))
+	})
+
+	It("creates a revision without gateways and chains it", func() {
+		created, err := manager.Create(ctx, "1.8.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Operator).To(Equal("istiocontrolplane-default-1-8-2"))
+
+		un, err := manager.Operators.Namespace("istio-system").Get(ctx, created.Operator, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(un.Object["spec"]).To(HaveKeyWithValue("revision", "1-8-2"))
+		Expect(un.Object["spec"]).To(HaveKeyWithValue("tag", "1.8.2"))
+		Expect(un.Object["spec"]).To(HaveKeyWithValue("profile", "default"))
+		components, _, _ := unstructured.NestedMap(un.Object, "spec", "components")
+		Expect(components).To(HaveKey("pilot"))
+		Expect(components).NotTo(HaveKey("ingressGateways"))
+
+		original, err := manager.Operators.Namespace("istio-system").Get(ctx, "istiocontrolplane-default", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, found, _ := unstructured.NestedFieldNoCopy(original.Object, "spec", "revision")
+		Expect(found).To(BeFalse())
+
+		active, previous, err := manager.Active(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(active.Name).To(Equal("1-8-2"))
+		Expect(active.Version).To(Equal("1.8.2"))
+		Expect(previous.Name).To(BeEmpty())
+	})
+
+	It("switches the injected namespaces and back", func() {
+		base, _, err := manager.Active(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		target, err := manager.Create(ctx, "1.8.2")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(manager.Switch(ctx, base, target)).To(Succeed())
+		Expect(labels("app")).To(Equal(map[string]string{"istio.io/rev": "1-8-2"}))
+		Expect(labels("plain")).To(BeEmpty())
+
+		Expect(manager.Switch(ctx, target, base)).To(Succeed())
+		Expect(labels("app")).To(Equal(map[string]string{"istio-injection": "enabled"}))
+
+		Expect(manager.Remove(ctx, target)).To(Succeed())
+		active, _, err := manager.Active(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(active.Name).To(BeEmpty())
+
+		Expect(manager.Remove(ctx, target)).To(Succeed())
+		Expect(manager.Remove(ctx, base)).NotTo(Succeed())
+	})
+
+	It("lists the workloads left on another version", func() {
+		status, err := manager.Status(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Version).To(Equal("1.6.0"))
+		Expect(status.OutdatedWorkloads).To(Equal([]revision.Workload{
+			{Namespace: "app", Pod: "web-2", Owner: "ReplicaSet/web-5d9c", Version: "1.8.2"},
+		}))
+
+		_, err = manager.Create(ctx, "1.8.2")
+		Expect(err).NotTo(HaveOccurred())
+		status, err = manager.Status(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Revision).To(Equal("1-8-2"))
+		Expect(status.Revisions).To(HaveLen(2))
+		Expect(status.OutdatedWorkloads).To(HaveLen(1))
+		Expect(status.OutdatedWorkloads[0].Pod).To(Equal("web-1"))
+	})
+
+	It("removes every revision and keeps the default control plane", func() {
+		base, _, err := manager.Active(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		first, err := manager.Create(ctx, "1.7.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.Switch(ctx, base, first)).To(Succeed())
+		second, err := manager.Create(ctx, "1.8.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.Switch(ctx, first, second)).To(Succeed())
+
+		Expect(manager.RemoveAll(ctx)).To(Succeed())
+		revisions, err := manager.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revisions).To(HaveLen(1))
+		Expect(revisions[0].Name).To(BeEmpty())
+		Expect(labels("app")).To(Equal(map[string]string{"istio-injection": "enabled"}))
+
+		Expect(manager.Operators.Namespace("istio-system").Delete(ctx, "istiocontrolplane-default", metav1.DeleteOptions{})).To(Succeed())
+		Expect(manager.RemoveAll(ctx)).To(Succeed())
+	})
+
+	It("fails when the mesh is not installed", func() {
+		Expect(manager.Operators.Namespace("istio-system").Delete(ctx, "istiocontrolplane-default", metav1.DeleteOptions{})).To(Succeed())
+		_, err := manager.Status(ctx)
+		Expect(err).To(HaveOccurred())
+	})
+})
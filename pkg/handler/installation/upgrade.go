@@ -0,0 +1,160 @@
+package installation
+
+import (
+	"context"
+	"fmt"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/installation/revision"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/kataras/iris/v12"
+	istiov1alpha1 "istio.io/istio/operator/pkg/apis/istio/v1alpha1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	StepUpgrade    = "upgrade"
+	StepRevision   = "revision"
+	StepNamespaces = "namespaces"
+)
+
+type UpgradeOptions struct {
+	Version string `json:"version"`
+}
+
+func revisionManager(mgr multiCluster.Manager, clusterName string) (*revision.Manager, error) {
+	kube, err := mgr.Client(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	operators, err := mgr.DynamicClient(clusterName, &istiov1alpha1.IstioOperatorGVK)
+	if err != nil {
+		return nil, err
+	}
+	return &revision.Manager{
+		Kube:      kube,
+		Operators: operators,
+		Namespace: DefaultInstallNamespace,
+		Operator:  IstioOperatorName,
+	}, nil
+}
+
+// Upgrade installs the control plane of the target version next to the
+// active one and switches the injected namespaces to it. The workloads keep
+// their sidecar until they are restarted, UpgradeStatus lists them.
+func Upgrade(mgr multiCluster.Manager, ctx iris.Context) {
+	clusterName := ctx.Params().Get("cluster")
+
+	options := &UpgradeOptions{}
+	if err := ctx.ReadJSON(options); err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+	name, err := revision.Name(options.Version)
+	if err != nil {
+		handler.ResponseErr(ctx, customErrors.BadRequest(fmt.Sprintf("版本%q不合法", options.Version)))
+		return
+	}
+
+	revisions, err := revisionManager(mgr, clusterName)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("Istio升级失败！", err))
+		return
+	}
+	active, _, err := revisions.Active(ctx.Request().Context())
+	if err != nil {
+		logger.Errorf("upgrade cluster %s failed err: %s", clusterName, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("Istio升级失败！", err))
+		return
+	}
+	if active.Version == options.Version {
+		handler.ResponseErr(ctx, customErrors.BadRequest(fmt.Sprintf("Istio已是版本%s", options.Version)))
+		return
+	}
+
+	target := &revision.Revision{Name: name, Operator: IstioOperatorName + "-" + name, Version: options.Version, Previous: active.Name}
+	steps := []Step{
+		{Name: StepRevision, Run: func(ctx context.Context) error {
+			_, err := revisions.Create(ctx, options.Version)
+			return err
+		}},
+		waitComponents(mgr, clusterName, StepControlPlane, Component{Name: "istiod-" + name, Namespace: DefaultInstallNamespace, Deployment: "istiod-" + name}),
+		{Name: StepNamespaces, Run: func(ctx context.Context) error { return revisions.Switch(ctx, active, target) }},
+	}
+	rollback := &Step{Name: StepRollback, Run: func(ctx context.Context) error {
+		if err := revisions.Switch(ctx, target, active); err != nil {
+			return err
+		}
+		return revisions.Remove(ctx, target)
+	}}
+
+	job, err := jobs(mgr).Start(clusterName, StepUpgrade, userName(ctx), steps, rollback,
+		auditJob(audit.ModuleIstio, audit.ActionUpgrade, clusterName, options, ctx))
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, job)
+}
+
+// Rollback switches the injected namespaces back to the previous control
+// plane and removes the active one.
+func Rollback(mgr multiCluster.Manager, ctx iris.Context) {
+	clusterName := ctx.Params().Get("cluster")
+
+	revisions, err := revisionManager(mgr, clusterName)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("Istio回滚失败！", err))
+		return
+	}
+	active, previous, err := revisions.Active(ctx.Request().Context())
+	if err != nil {
+		logger.Errorf("rollback cluster %s failed err: %s", clusterName, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("Istio回滚失败！", err))
+		return
+	}
+	if previous == nil {
+		handler.ResponseErr(ctx, customErrors.BadRequest("Istio没有可回滚的版本"))
+		return
+	}
+
+	steps := []Step{
+		{Name: StepNamespaces, Run: func(ctx context.Context) error { return revisions.Switch(ctx, active, previous) }},
+		{Name: StepRevision, Run: func(ctx context.Context) error { return revisions.Remove(ctx, active) }},
+	}
+	job, err := jobs(mgr).Start(clusterName, StepRollback, userName(ctx), steps, nil,
+		auditJob(audit.ModuleIstio, audit.ActionRollback, clusterName, map[string]interface{}{"from": active.Version, "to": previous.Version}, ctx))
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	handler.ResponseOk(ctx, job)
+}
+
+// UpgradeStatus reports the control planes and the workloads still running
+// the sidecar of another version.
+func UpgradeStatus(mgr multiCluster.Manager, ctx iris.Context) {
+	clusterName := ctx.Params().Get("cluster")
+
+	revisions, err := revisionManager(mgr, clusterName)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("获取Istio版本失败！", err))
+		return
+	}
+	status, err := revisions.Status(ctx.Request().Context())
+	if err != nil {
+		logger.Errorf("upgrade status of cluster %s failed err: %s", clusterName, err)
+		if k8serror.IsNotFound(err) {
+			handler.Response(ctx, customErrors.StatusCodeResourceNotFound, "Istio未安装")
+			return
+		}
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("获取Istio版本失败！", err))
+		return
+	}
+
+	handler.ResponseOk(ctx, status)
+}
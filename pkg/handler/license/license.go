@@ -2,12 +2,16 @@ package license
 
 import (
 	"errors"
+	"flag"
+	"net/http"
+	"time"
 
 	"github.com/huhenry/hej/pkg/backend"
 	customErrors "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler"
 	"github.com/huhenry/hej/pkg/log"
 	"github.com/kataras/iris/v12"
+	"github.com/spf13/viper"
 )
 
 var logger = log.RegisterScope("license-filter")
@@ -16,8 +20,23 @@ const (
 	StatusExpired   = -1
 	StatusNoLicense = -2
 	StatusOverCpu   = -3
+
+	licenseRefreshInterval        = "license.refresh_interval"
+	defaultLicenseRefreshInterval = time.Minute
 )
 
+// AddFlags adds the license flags
+func AddFlags(flagSet *flag.FlagSet) {
+	flagSet.Duration(licenseRefreshInterval, defaultLicenseRefreshInterval, "How often the cached license state is refreshed from the backend.")
+}
+
+// InitFromViper configures the license cache with properties from viper
+func InitFromViper(v *viper.Viper) {
+	licenseCache.SetInterval(v.GetDuration(licenseRefreshInterval))
+}
+
+var licenseCache = NewStateCache(defaultLicenseRefreshInterval, loadState)
+
 func Handler() func(iris.Context) {
 	return func(ctx iris.Context) {
 
@@ -28,7 +47,7 @@ func Handler() func(iris.Context) {
 			return
 		}
 		code, err := ValidLicense(userContext.Token)
-		if code != 200 {
+		if code != http.StatusOK {
 			handler.Response(ctx, code, err.Error())
 			return
 		}
@@ -37,21 +56,42 @@ func Handler() func(iris.Context) {
 	}
 }
 
+// ValidLicense checks the cached license state, the backend is only called
+// when no state has been loaded yet or it is outdated.
 func ValidLicense(token string) (int, error) {
-	license, _ := backend.GetClient().V1().License().
-		Licenseinfo(token)
-
-	if license == nil {
-		return 200, nil
+	state, err := licenseCache.Get(token)
+	if err != nil {
+		logger.Errorf("get license failed err: %s", err)
+		return customErrors.StatusCodeLicenseUnavailable, errors.New("license信息获取失败")
 	}
-	switch license.Status {
-	case StatusExpired:
-		return customErrors.StatusCodeLicenseInvalid, errors.New("license超期")
-	case StatusNoLicense:
-		return customErrors.StatusCodeLicenseInvalid, errors.New("license超期")
-	case StatusOverCpu:
-		return customErrors.StatusCodeLicenseOverload, errors.New("license资源超限")
+	if state.Code != http.StatusOK {
+		return state.Code, errors.New(state.Message)
 	}
+	return http.StatusOK, nil
+}
 
-	return 200, nil
+// GetLicense exposes the license state, it is not behind the license check so
+// the UI can warn before requests start failing.
+func GetLicense(ctx iris.Context) {
+	userContext := handler.ExtractUserContext(ctx)
+
+	state, err := licenseCache.Get(userContext.Token)
+	if err != nil {
+		logger.Errorf("get license failed err: %s", err)
+		handler.Response(ctx, customErrors.StatusCodeLicenseUnavailable, "license信息获取失败")
+		return
+	}
+
+	handler.ResponseOk(ctx, state)
+}
+
+func loadState(token string) (*State, error) {
+	license, err := backend.GetClient().V1().License().Licenseinfo(token)
+	if err != nil {
+		return nil, err
+	}
+	if license == nil {
+		return nil, errors.New("backend returned no license")
+	}
+	return NewState(license.Status, license.ExpireTime), nil
 }
@@ -0,0 +1,113 @@
+package license
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// State is the license as seen by hej, Code is the status code requests
+// are answered with while the license is in this state.
+type State struct {
+	Status     int    `json:"status"`
+	Valid      bool   `json:"valid"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	ExpireTime int64  `json:"expireTime,omitempty"`
+	CheckedAt  int64  `json:"checkedAt"`
+}
+
+func NewState(status int, expireTime int64) *State {
+	state := &State{
+		Status:     status,
+		Code:       http.StatusOK,
+		ExpireTime: expireTime,
+		CheckedAt:  time.Now().Unix(),
+	}
+	switch status {
+	case StatusExpired:
+		state.Code, state.Message = customErrors.StatusCodeLicenseInvalid, "license超期"
+	case StatusNoLicense:
+		state.Code, state.Message = customErrors.StatusCodeLicenseMissing, "license不存在"
+	case StatusOverCpu:
+		state.Code, state.Message = customErrors.StatusCodeLicenseOverload, "license资源超限"
+	}
+	state.Valid = state.Code == http.StatusOK
+	return state
+}
+
+// StateCache keeps the last license state. A state older than the interval
+// is reloaded within the request finding it outdated, with the token of that
+// request, and concurrent requests share the reload. A failed reload keeps
+// the last state until the next interval.
+type StateCache struct {
+	mu          sync.RWMutex
+	interval    time.Duration
+	state       *State
+	refreshedAt time.Time
+	group       singleflight.Group
+	load        func(token string) (*State, error)
+	now         func() time.Time
+}
+
+func NewStateCache(interval time.Duration, load func(token string) (*State, error)) *StateCache {
+	return &StateCache{
+		interval: interval,
+		load:     load,
+		now:      time.Now,
+	}
+}
+
+func (c *StateCache) SetInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interval = interval
+}
+
+// Get returns the cached state, the backend is called only when there is no
+// state yet or it is outdated.
+func (c *StateCache) Get(token string) (*State, error) {
+	c.mu.RLock()
+	state, refreshedAt, interval := c.state, c.refreshedAt, c.interval
+	c.mu.RUnlock()
+
+	if state != nil && c.now().Sub(refreshedAt) < interval {
+		return state, nil
+	}
+
+	fresh, err := c.refresh(token)
+	if err != nil {
+		if state == nil {
+			return nil, err
+		}
+		logger.Warnf("refresh license failed, keep the last state err: %s", err)
+		c.mu.Lock()
+		c.refreshedAt = c.now()
+		c.mu.Unlock()
+		return state, nil
+	}
+	return fresh, nil
+}
+
+func (c *StateCache) refresh(token string) (*State, error) {
+	value, err, _ := c.group.Do("license", func() (interface{}, error) {
+		state, err := c.load(token)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.state = state
+		c.refreshedAt = c.now()
+		c.mu.Unlock()
+		return state, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*State), nil
+}
@@ -0,0 +1,83 @@
+package license_test
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	. "github.com/huhenry/hej/pkg/handler/license"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State", func() {
+	It("should answer each status with its own code", func() {
+		Expect(NewState(StatusExpired, 0).Code).To(Equal(customErrors.StatusCodeLicenseInvalid))
+		Expect(NewState(StatusNoLicense, 0).Code).To(Equal(customErrors.StatusCodeLicenseMissing))
+		Expect(NewState(StatusOverCpu, 0).Code).To(Equal(customErrors.StatusCodeLicenseOverload))
+
+		state := NewState(1, 1700000000)
+		Expect(state.Valid).To(BeTrue())
+		Expect(state.Code).To(Equal(http.StatusOK))
+		Expect(state.ExpireTime).To(BeEquivalentTo(1700000000))
+	})
+})
+
+var _ = Describe("StateCache", func() {
+	var (
+		calls   int32
+		failure *atomic.Value
+		load    func(token string) (*State, error)
+	)
+
+	BeforeEach(func() {
+		calls = 0
+		failure = &atomic.Value{}
+		load = func(token string) (*State, error) {
+			atomic.AddInt32(&calls, 1)
+			if err, ok := failure.Load().(error); ok {
+				return nil, err
+			}
+			return NewState(1, 0), nil
+		}
+	})
+
+	It("should fail until a state has been loaded", func() {
+		failure.Store(errors.New("backend unavailable"))
+		cache := NewStateCache(time.Minute, load)
+
+		_, err := cache.Get("token")
+		Expect(err).To(MatchError("backend unavailable"))
+	})
+
+	It("should serve the cached state within the interval", func() {
+		cache := NewStateCache(time.Minute, load)
+
+		for i := 0; i < 3; i++ {
+			state, err := cache.Get("token")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Valid).To(BeTrue())
+		}
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("should reload an outdated state within the request and keep the last state on failure", func() {
+		cache := NewStateCache(50*time.Millisecond, load)
+		_, _ = cache.Get("token")
+
+		time.Sleep(60 * time.Millisecond)
+		failure.Store(errors.New("backend unavailable"))
+
+		state, err := cache.Get("token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Valid).To(BeTrue())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+
+		state, err = cache.Get("token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Valid).To(BeTrue())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+})
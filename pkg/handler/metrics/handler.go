@@ -29,7 +29,6 @@ const (
 	ServiceMeshKind    = "ServiceMesh"
 
 	DefaultInstallNamespace = "istio-system"
-	DefaultTag              = "1.6.0"
 	DefaultProfile          = "default"
 
 	JaegerPort = "5066"
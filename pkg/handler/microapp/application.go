@@ -5,7 +5,6 @@ import (
 
 	"github.com/huhenry/hej/pkg/common/app"
 	"github.com/huhenry/hej/pkg/common/page"
-	"github.com/huhenry/hej/pkg/define"
 	customerrors "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler"
 	"github.com/huhenry/hej/pkg/handler/audit"
@@ -85,12 +84,20 @@ func UpdateApplication(ctx iris.Context) {
 		NamespaceId:   appCtx.NamespaceId,
 	}
 
+	before := handler.AuditBefore(micro.MicroApplicaiton().Get(resource, name))
+
 	err = micro.MicroApplicaiton().Update(resource, name, desc)
 	if err != nil {
 
 		handler.RespondWithDetailedError(ctx, customerrors.CustomClientErr("更新应用失败！", err))
 	} else {
-		handler.SendAudit(audit.ModuleMicroApplication, audit.ActionUpdate, name, ctx)
+		after, err := micro.MicroApplicaiton().Get(resource, name)
+		if err != nil {
+			logger.Warnf("get application %s after update failed err: %s", name, err)
+			handler.SendAudit(audit.ModuleMicroApplication, audit.ActionUpdate, name, ctx)
+		} else {
+			handler.SendAuditWithDiff(audit.ModuleMicroApplication, audit.ActionUpdate, name, before, after, ctx)
+		}
 		handler.ResponseOk(ctx, nil)
 	}
 }
@@ -171,6 +178,11 @@ func ListApplication(ctx iris.Context) {
 		Cluster:       appCtx.ClusterName,
 		KubeNamespace: appCtx.KubeNamespace,
 	}
+	query, err := filter.ParserParams(ctx, microAppSchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customerrors.BadParametersErr(err))
+		return
+	}
 
 	microAppAll, err := micro.MicroApplicaiton().List(resource)
 	if err != nil {
@@ -180,43 +192,19 @@ func ListApplication(ctx iris.Context) {
 		return
 	}
 
-	/* microApps := microAppAll
-	filters := filter.PraserParams(ctx)
-	//通过过滤器层层过滤请求参数
-	for _, filter := range filters {
-		microApps = filter.Filte(microApps)
-	} */
-	microApp := &v1beta1.MicroApp{}
-	filters := filter.ParserParams(ctx)
-	microApps := make([]v1beta1.MicroApp, 0)
-	for _, App := range microAppAll {
-		microApp = &App
-		for _, filter := range filters {
-			microApp = filter.Filte(microApp)
-		}
-
-		if microApp != nil {
-			microApps = append(microApps, *microApp)
-		}
-
-	}
-
-	sort.Slice(microApps, func(i, j int) bool {
-		r := microApps[i].CreateTimeSec > microApps[j].CreateTimeSec
-		if paramQuery.Sortby == define.SortByCreateTime {
-			r = !r
-		}
-
-		return r
-	})
 	data := make([]interface{}, 0)
-	for i := range microApps {
-		dto := getMicroappDTO(&microApps[i])
+	for i := range microAppAll {
+		dto := getMicroappDTO(&microAppAll[i])
 		data = append(data, dto)
 	}
-	logger.Infof("listApplication返回数据 ：%s", data)
-	handler.ResponseOk(ctx, page.PageInfo(data, paramQuery))
+	handler.ResponseOk(ctx, page.PageInfo(query.Apply(microAppSchema, data), paramQuery))
+
+}
 
+var microAppSchema = filter.Schema{
+	filter.FieldName:       func(item interface{}) interface{} { return item.(*MicroappDTO).Name },
+	filter.FieldCreator:    func(item interface{}) interface{} { return item.(*MicroappDTO).Creator },
+	filter.FieldCreateTime: func(item interface{}) interface{} { return item.(*MicroappDTO).CreateTimeSec },
 }
 
 type MicroappDTO struct {
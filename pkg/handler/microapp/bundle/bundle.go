@@ -0,0 +1,138 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the version of the documents which are settings rather
+	// than resources, such as the traffic policy of a micro service.
+	APIVersion = "bundle.tpaas.troila.com/v1"
+
+	KindTrafficPolicy = "TrafficPolicy"
+	KindCanaryPolicy  = "CanaryPolicy"
+
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+	separator             = "---\n"
+)
+
+// Options are the cluster specific fields dropped from the resources, the
+// metadata written by the cluster itself is always dropped.
+type Options struct {
+	Labels      []string
+	Annotations []string
+	// Fields are paths from the root of the resource, such as spec.cluster.
+	Fields [][]string
+}
+
+// Document wraps a setting into a document of the bundle, spec is anything
+// which encodes to a json object.
+func Document(kind, name string, spec interface{}) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("%s %s is not an object: %s", kind, name, err)
+	}
+
+	un := &unstructured.Unstructured{Object: map[string]interface{}{"spec": object}}
+	un.SetAPIVersion(APIVersion)
+	un.SetKind(kind)
+	un.SetName(name)
+	return un, nil
+}
+
+// Strip returns a copy of the resource which can be applied to another
+// namespace or cluster: only its name, labels and annotations are kept from
+// the metadata and its status is dropped.
+func Strip(un *unstructured.Unstructured, opts Options) *unstructured.Unstructured {
+	stripped := un.DeepCopy()
+	unstructured.RemoveNestedField(stripped.Object, "metadata")
+	unstructured.RemoveNestedField(stripped.Object, "status")
+	for _, fields := range opts.Fields {
+		unstructured.RemoveNestedField(stripped.Object, fields...)
+	}
+
+	stripped.SetName(un.GetName())
+	if labels := without(un.GetLabels(), opts.Labels); len(labels) > 0 {
+		stripped.SetLabels(labels)
+	}
+	if annotations := without(un.GetAnnotations(), append(opts.Annotations, lastAppliedAnnotation)); len(annotations) > 0 {
+		stripped.SetAnnotations(annotations)
+	}
+	return stripped
+}
+
+func without(values map[string]string, keys []string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	for _, key := range keys {
+		delete(result, key)
+	}
+	return result
+}
+
+// WriteYAML writes the documents as one multi-document yaml in their order.
+func WriteYAML(w io.Writer, documents []*unstructured.Unstructured) error {
+	for i, document := range documents {
+		data, err := yaml.Marshal(document.Object)
+		if err != nil {
+			return fmt.Errorf("encode %s %s failed: %s", document.GetKind(), document.GetName(), err)
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileName is the name of the document in a tar bundle, the index keeps the
+// order the documents are applied in.
+func FileName(index int, document *unstructured.Unstructured) string {
+	return fmt.Sprintf("%02d-%s-%s.yaml", index, strings.ToLower(document.GetKind()), document.GetName())
+}
+
+// WriteTar writes a tar with one yaml file per document.
+func WriteTar(w io.Writer, documents []*unstructured.Unstructured) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	for i, document := range documents {
+		data, err := yaml.Marshal(document.Object)
+		if err != nil {
+			return fmt.Errorf("encode %s %s failed: %s", document.GetKind(), document.GetName(), err)
+		}
+		header := &tar.Header{
+			Name:    FileName(i, document),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,118 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/huhenry/hej/pkg/handler/microapp/bundle"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func microService() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "microservices.troila.com/v1beta1",
+		"kind":       "MicroService",
+		"metadata": map[string]interface{}{
+			"name":              "reviews",
+			"namespace":         "shop",
+			"uid":               "2f1c",
+			"resourceVersion":   "42",
+			"creationTimestamp": "2020-07-01T00:00:00Z",
+			"ownerReferences":   []interface{}{map[string]interface{}{"kind": "MicroApp", "name": "shop"}},
+			"labels": map[string]interface{}{
+				"app.tpaas.troila.com/app": "12",
+				"microapp":                 "shop",
+			},
+			"annotations": map[string]interface{}{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				"description": "reviews",
+			},
+		},
+		"spec": map[string]interface{}{
+			"serviceName": "reviews",
+			"cluster":     "prod",
+		},
+		"status": map[string]interface{}{"phase": "Running"},
+	}}
+}
+
+var _ = Describe("Strip", func() {
+	It("keeps only what can be applied elsewhere", func() {
+		original := microService()
+		stripped := bundle.Strip(original, bundle.Options{
+			Labels: []string{"app.tpaas.troila.com/app"},
+			Fields: [][]string{{"spec", "cluster"}},
+		})
+
+		Expect(stripped.Object).To(Equal(map[string]interface{}{
+			"apiVersion": "microservices.troila.com/v1beta1",
+			"kind":       "MicroService",
+			"metadata": map[string]interface{}{
+				"name":        "reviews",
+				"labels":      map[string]interface{}{"microapp": "shop"},
+				"annotations": map[string]interface{}{"description": "reviews"},
+			},
+			"spec": map[string]interface{}{"serviceName": "reviews"},
+		}))
+		Expect(original.GetNamespace()).To(Equal("shop"))
+	})
+})
+
+var _ = Describe("Document", func() {
+	It("wraps a setting", func() {
+		document, err := bundle.Document(bundle.KindTrafficPolicy, "reviews", struct {
+			Timeout int `json:"timeout"`
+		}{Timeout: 3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(document.GetAPIVersion()).To(Equal(bundle.APIVersion))
+		Expect(document.GetKind()).To(Equal(bundle.KindTrafficPolicy))
+		Expect(document.GetName()).To(Equal("reviews"))
+		Expect(document.Object["spec"]).To(Equal(map[string]interface{}{"timeout": float64(3)}))
+	})
+
+	It("rejects a setting which is not an object", func() {
+		_, err := bundle.Document(bundle.KindTrafficPolicy, "reviews", []string{"a"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Write", func() {
+	var documents []*unstructured.Unstructured
+
+	BeforeEach(func() {
+		policy, err := bundle.Document(bundle.KindTrafficPolicy, "reviews", map[string]interface{}{"timeout": 3})
+		Expect(err).NotTo(HaveOccurred())
+		documents = []*unstructured.Unstructured{bundle.Strip(microService(), bundle.Options{}), policy}
+	})
+
+	It("writes a multi-document yaml", func() {
+		buf := &bytes.Buffer{}
+		Expect(bundle.WriteYAML(buf, documents)).To(Succeed())
+
+		parts := strings.Split(buf.String(), "---\n")
+		Expect(parts).To(HaveLen(2))
+		Expect(parts[0]).To(ContainSubstring("kind: MicroService"))
+		Expect(parts[1]).To(ContainSubstring("kind: TrafficPolicy"))
+	})
+
+	It("writes a file per document into a tar", func() {
+		buf := &bytes.Buffer{}
+		Expect(bundle.WriteTar(buf, documents)).To(Succeed())
+
+		names := []string{}
+		tr := tar.NewReader(buf)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			names = append(names, header.Name)
+		}
+		Expect(names).To(Equal([]string{"00-microservice-reviews.yaml", "01-trafficpolicy-reviews.yaml"}))
+	})
+})
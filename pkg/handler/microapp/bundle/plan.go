@@ -0,0 +1,233 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	KindMicroApp     = "MicroApp"
+	KindMicroService = "MicroService"
+	KindGateway      = "Gateway"
+	KindCanary       = "Canary"
+
+	tarMagicOffset = 257
+	tarMagic       = "ustar"
+
+	// MaxSize is the largest bundle an import reads, an exported application
+	// is a few small documents per micro service and stays far below it.
+	MaxSize = 8 << 20
+)
+
+type Action string
+
+const (
+	ActionCreate    Action = "Create"
+	ActionUpdate    Action = "Update"
+	ActionUnchanged Action = "Unchanged"
+	ActionSkip      Action = "Skip"
+	ActionConflict  Action = "Conflict"
+)
+
+// rank is the order the kinds are applied in, a kind only depends on the
+// kinds before it. Service entries come after the micro services.
+var rank = map[string]int{
+	KindMicroApp:      0,
+	KindMicroService:  1,
+	KindGateway:       3,
+	KindTrafficPolicy: 4,
+	KindCanary:        5,
+	KindCanaryPolicy:  6,
+}
+
+// Step is what applying a document of the bundle does.
+type Step struct {
+	Kind     string                     `json:"kind"`
+	Name     string                     `json:"name"`
+	Action   Action                     `json:"action"`
+	Reason   string                     `json:"reason,omitempty"`
+	Document *unstructured.Unstructured `json:"-"`
+}
+
+func (s *Step) String() string {
+	return fmt.Sprintf("%s %s: %s", s.Kind, s.Name, s.Reason)
+}
+
+// Plan is the steps of a bundle in the order they are applied.
+type Plan struct {
+	Application string  `json:"application"`
+	Steps       []*Step `json:"steps"`
+}
+
+// Read decodes a bundle written by WriteYAML or WriteTar. The reader is
+// expected to be bounded, see MaxSize.
+func Read(r io.Reader) ([]*unstructured.Unstructured, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > tarMagicOffset+len(tarMagic) && string(data[tarMagicOffset:tarMagicOffset+len(tarMagic)]) == tarMagic {
+		return readTar(data)
+	}
+	return readYAML(data)
+}
+
+func readTar(data []byte) ([]*unstructured.Unstructured, error) {
+	files := map[string][]byte{}
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = content
+		names = append(names, header.Name)
+	}
+
+	sort.Strings(names)
+	documents := []*unstructured.Unstructured{}
+	for _, name := range names {
+		decoded, err := readYAML(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		documents = append(documents, decoded...)
+	}
+	return documents, nil
+}
+
+func readYAML(data []byte) ([]*unstructured.Unstructured, error) {
+	documents := []*unstructured.Unstructured{}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		un := &unstructured.Unstructured{}
+		if err := decoder.Decode(&un.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(un.Object) == 0 {
+			continue
+		}
+		documents = append(documents, un)
+	}
+	return documents, nil
+}
+
+// Validate checks the bundle holds one MicroApp and documents of known kinds
+// only, it returns the name of the application.
+func Validate(documents []*unstructured.Unstructured) (string, error) {
+	application := ""
+	seen := map[string]bool{}
+	for _, document := range documents {
+		kind, name := document.GetKind(), document.GetName()
+		if _, ok := rank[kind]; !ok {
+			return "", fmt.Errorf("unsupported kind %q", kind)
+		}
+		if len(name) == 0 {
+			return "", fmt.Errorf("%s without name", kind)
+		}
+		if seen[kind+"/"+name] {
+			return "", fmt.Errorf("%s %s is duplicated", kind, name)
+		}
+		seen[kind+"/"+name] = true
+
+		if kind == KindMicroApp {
+			if len(application) > 0 {
+				return "", fmt.Errorf("more than one %s", KindMicroApp)
+			}
+			application = name
+		}
+	}
+	if len(application) == 0 {
+		return "", fmt.Errorf("no %s", KindMicroApp)
+	}
+	return application, nil
+}
+
+// IsServiceEntry tells whether the micro service is a service entry.
+func IsServiceEntry(document *unstructured.Unstructured) bool {
+	entry, found, _ := unstructured.NestedFieldNoCopy(document.Object, "spec", "serviceEntry")
+	return found && entry != nil
+}
+
+func rankOf(document *unstructured.Unstructured) int {
+	if document.GetKind() == KindMicroService && IsServiceEntry(document) {
+		return rank[KindMicroService] + 1
+	}
+	return rank[document.GetKind()]
+}
+
+// Sort orders the documents by dependency, the order of the bundle is kept
+// within a kind.
+func Sort(documents []*unstructured.Unstructured) {
+	sort.SliceStable(documents, func(i, j int) bool {
+		return rankOf(documents[i]) < rankOf(documents[j])
+	})
+}
+
+// Add plans the document against the object in place, which is nil when
+// there is none. The object has to be stripped with the options of the
+// bundle, an object labelled for another application is a conflict.
+func (p *Plan) Add(document, existing *unstructured.Unstructured, label string) *Step {
+	step := &Step{Kind: document.GetKind(), Name: document.GetName(), Action: ActionCreate, Document: document}
+	p.Steps = append(p.Steps, step)
+	if existing == nil {
+		return step
+	}
+
+	if owner, ok := existing.GetLabels()[label]; ok && len(label) > 0 && owner != p.Application && document.GetKind() != KindMicroApp {
+		step.Action = ActionConflict
+		step.Reason = fmt.Sprintf("已属于应用%s", owner)
+		return step
+	}
+	step.Action = ActionUpdate
+	if equal(document.Object["spec"], existing.Object["spec"]) {
+		step.Action = ActionUnchanged
+	}
+	return step
+}
+
+// equal compares through json, the numbers of a decoded bundle and of the
+// cluster do not have the same types.
+func equal(a, b interface{}) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(left, right)
+}
+
+// Conflicts returns the steps which stop the bundle from being applied.
+func (p *Plan) Conflicts() []*Step {
+	conflicts := []*Step{}
+	for _, step := range p.Steps {
+		if step.Action == ActionConflict {
+			conflicts = append(conflicts, step)
+		}
+	}
+	return conflicts
+}
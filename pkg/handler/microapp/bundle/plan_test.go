@@ -0,0 +1,151 @@
+package bundle_test
+
+import (
+	"bytes"
+
+	"github.com/huhenry/hej/pkg/handler/microapp/bundle"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func document(kind, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	un := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	un.SetAPIVersion("microservices.troila.com/v1beta1")
+	un.SetKind(kind)
+	un.SetName(name)
+	return un
+}
+
+func names(documents []*unstructured.Unstructured) []string {
+	result := []string{}
+	for _, document := range documents {
+		result = append(result, document.GetKind()+"/"+document.GetName())
+	}
+	return result
+}
+
+var _ = Describe("Read", func() {
+	var documents []*unstructured.Unstructured
+
+	BeforeEach(func() {
+		documents = []*unstructured.Unstructured{
+			document(bundle.KindMicroApp, "shop", map[string]interface{}{"description": "shop"}),
+			document(bundle.KindMicroService, "reviews", map[string]interface{}{"replicas": int64(2)}),
+		}
+	})
+
+	It("reads back a yaml bundle", func() {
+		buf := &bytes.Buffer{}
+		Expect(bundle.WriteYAML(buf, documents)).To(Succeed())
+
+		read, err := bundle.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(read)).To(Equal([]string{"MicroApp/shop", "MicroService/reviews"}))
+		Expect(read[1].Object["spec"]).To(Equal(map[string]interface{}{"replicas": float64(2)}))
+	})
+
+	It("reads back a tar bundle", func() {
+		buf := &bytes.Buffer{}
+		Expect(bundle.WriteTar(buf, documents)).To(Succeed())
+
+		read, err := bundle.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(read)).To(Equal([]string{"MicroApp/shop", "MicroService/reviews"}))
+	})
+
+	It("fails on a broken yaml", func() {
+		_, err := bundle.Read(bytes.NewBufferString("kind: [MicroApp"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Validate", func() {
+	It("returns the application", func() {
+		application, err := bundle.Validate([]*unstructured.Unstructured{
+			document(bundle.KindMicroService, "reviews", nil),
+			document(bundle.KindMicroApp, "shop", nil),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(application).To(Equal("shop"))
+	})
+
+	table.DescribeTable("rejects",
+		func(documents ...*unstructured.Unstructured) {
+			_, err := bundle.Validate(documents)
+			Expect(err).To(HaveOccurred())
+		},
+		table.Entry("a bundle without application", document(bundle.KindMicroService, "reviews", nil)),
+		table.Entry("two applications", document(bundle.KindMicroApp, "shop", nil), document(bundle.KindMicroApp, "blog", nil)),
+		table.Entry("an unknown kind", document(bundle.KindMicroApp, "shop", nil), document("Deployment", "reviews", nil)),
+		table.Entry("a duplicated document", document(bundle.KindMicroApp, "shop", nil), document(bundle.KindGateway, "www", nil), document(bundle.KindGateway, "www", nil)),
+	)
+})
+
+var _ = Describe("Sort", func() {
+	It("orders the documents by dependency", func() {
+		documents := []*unstructured.Unstructured{
+			document(bundle.KindCanaryPolicy, "reviews-v2", nil),
+			document(bundle.KindTrafficPolicy, "reviews", nil),
+			document(bundle.KindMicroService, "payment", map[string]interface{}{"serviceEntry": map[string]interface{}{"hosts": []interface{}{"pay.example.com"}}}),
+			document(bundle.KindGateway, "www", nil),
+			document(bundle.KindMicroService, "reviews", nil),
+			document(bundle.KindCanary, "reviews-v2", nil),
+			document(bundle.KindMicroApp, "shop", nil),
+			document(bundle.KindMicroService, "ratings", nil),
+		}
+
+		bundle.Sort(documents)
+		Expect(names(documents)).To(Equal([]string{
+			"MicroApp/shop",
+			"MicroService/reviews",
+			"MicroService/ratings",
+			"MicroService/payment",
+			"Gateway/www",
+			"TrafficPolicy/reviews",
+			"Canary/reviews-v2",
+			"CanaryPolicy/reviews-v2",
+		}))
+	})
+})
+
+var _ = Describe("Plan", func() {
+	const label = "microapp"
+
+	var plan *bundle.Plan
+
+	BeforeEach(func() {
+		plan = &bundle.Plan{Application: "shop"}
+	})
+
+	It("creates what is missing", func() {
+		step := plan.Add(document(bundle.KindMicroService, "reviews", nil), nil, label)
+		Expect(step.Action).To(Equal(bundle.ActionCreate))
+	})
+
+	It("leaves what is the same", func() {
+		existing := document(bundle.KindMicroService, "reviews", map[string]interface{}{"replicas": int64(2)})
+		step := plan.Add(document(bundle.KindMicroService, "reviews", map[string]interface{}{"replicas": float64(2)}), existing, label)
+		Expect(step.Action).To(Equal(bundle.ActionUnchanged))
+	})
+
+	It("updates what differs", func() {
+		existing := document(bundle.KindMicroService, "reviews", map[string]interface{}{"replicas": int64(1)})
+		existing.SetLabels(map[string]string{label: "shop"})
+		step := plan.Add(document(bundle.KindMicroService, "reviews", map[string]interface{}{"replicas": float64(2)}), existing, label)
+		Expect(step.Action).To(Equal(bundle.ActionUpdate))
+	})
+
+	It("reports what belongs to another application", func() {
+		existing := document(bundle.KindMicroService, "reviews", nil)
+		existing.SetLabels(map[string]string{label: "blog"})
+		plan.Add(document(bundle.KindMicroService, "ratings", nil), nil, label)
+		plan.Add(document(bundle.KindMicroService, "reviews", nil), existing, label)
+
+		conflicts := plan.Conflicts()
+		Expect(conflicts).To(HaveLen(1))
+		Expect(conflicts[0].Name).To(Equal("reviews"))
+		Expect(conflicts[0].Reason).To(ContainSubstring("blog"))
+	})
+})
@@ -0,0 +1,60 @@
+package bundle
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The kinds of workload a micro service may be bound to.
+const (
+	WorkloadDeployment  = "Deployment"
+	WorkloadStatefulSet = "StatefulSet"
+	WorkloadDaemonSet   = "DaemonSet"
+)
+
+// RemapDomains moves the gateways to the domains of another cluster, domains
+// maps the domain of a gateway and clusterDomains the cluster domain it is
+// attached to. It returns the gateways left on their domain.
+func RemapDomains(documents []*unstructured.Unstructured, domains, clusterDomains map[string]string) []string {
+	unmapped := []string{}
+	for _, document := range documents {
+		if document.GetKind() != KindGateway {
+			continue
+		}
+		mapped := remap(document, domains, "spec", "domain")
+		if remap(document, clusterDomains, "spec", "clusterDomain") {
+			mapped = true
+		}
+		if !mapped {
+			unmapped = append(unmapped, document.GetName())
+		}
+	}
+	return unmapped
+}
+
+func remap(document *unstructured.Unstructured, mapping map[string]string, fields ...string) bool {
+	value, found, _ := unstructured.NestedString(document.Object, fields...)
+	if !found || len(value) == 0 {
+		return false
+	}
+	target, ok := mapping[value]
+	if !ok {
+		return false
+	}
+	unstructured.SetNestedField(document.Object, target, fields...)
+	return true
+}
+
+// Workload returns the kind and name of the workload a micro service is
+// bound to, the kind defaults to Deployment. Service entries and unbound
+// micro services have none.
+func Workload(document *unstructured.Unstructured) (kind, name string, ok bool) {
+	if document.GetKind() != KindMicroService || IsServiceEntry(document) {
+		return "", "", false
+	}
+	name, _, _ = unstructured.NestedString(document.Object, "spec", "workload", "name")
+	kind, _, _ = unstructured.NestedString(document.Object, "spec", "workload", "kind")
+	if len(kind) == 0 {
+		kind = WorkloadDeployment
+	}
+	return kind, name, len(name) > 0
+}
@@ -0,0 +1,52 @@
+package bundle_test
+
+import (
+	"github.com/huhenry/hej/pkg/handler/microapp/bundle"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("RemapDomains", func() {
+	It("moves the gateways to the mapped domains", func() {
+		www := document(bundle.KindGateway, "www", map[string]interface{}{"domain": "shop.test.example.com", "path": "/"})
+		api := document(bundle.KindGateway, "api", map[string]interface{}{"domain": "api", "clusterDomain": "test-apps"})
+		admin := document(bundle.KindGateway, "admin", map[string]interface{}{"domain": "admin.example.com"})
+		service := document(bundle.KindMicroService, "reviews", map[string]interface{}{"domain": "shop.test.example.com"})
+
+		unmapped := bundle.RemapDomains([]*unstructured.Unstructured{www, api, admin, service},
+			map[string]string{"shop.test.example.com": "shop.example.com"},
+			map[string]string{"test-apps": "prod-apps"})
+
+		Expect(unmapped).To(Equal([]string{"admin"}))
+		Expect(www.Object["spec"]).To(Equal(map[string]interface{}{"domain": "shop.example.com", "path": "/"}))
+		Expect(api.Object["spec"]).To(Equal(map[string]interface{}{"domain": "api", "clusterDomain": "prod-apps"}))
+		Expect(service.Object["spec"]).To(Equal(map[string]interface{}{"domain": "shop.test.example.com"}))
+	})
+})
+
+var _ = Describe("Workload", func() {
+	It("returns the workload of a bound micro service", func() {
+		kind, name, ok := bundle.Workload(document(bundle.KindMicroService, "reviews", map[string]interface{}{
+			"workload": map[string]interface{}{"kind": "StatefulSet", "name": "reviews-v1"},
+		}))
+		Expect(ok).To(BeTrue())
+		Expect(kind).To(Equal(bundle.WorkloadStatefulSet))
+		Expect(name).To(Equal("reviews-v1"))
+	})
+
+	It("defaults the kind to a deployment", func() {
+		kind, _, ok := bundle.Workload(document(bundle.KindMicroService, "reviews", map[string]interface{}{
+			"workload": map[string]interface{}{"name": "reviews-v1"},
+		}))
+		Expect(ok).To(BeTrue())
+		Expect(kind).To(Equal(bundle.WorkloadDeployment))
+	})
+
+	It("has none for a service entry", func() {
+		_, _, ok := bundle.Workload(document(bundle.KindMicroService, "payment", map[string]interface{}{
+			"serviceEntry": map[string]interface{}{"hosts": []interface{}{"pay.example.com"}},
+		}))
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,220 @@
+package microapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/huhenry/hej/pkg/backend"
+	"github.com/huhenry/hej/pkg/common/app"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/auth"
+	canaryhandler "github.com/huhenry/hej/pkg/handler/canary"
+	"github.com/huhenry/hej/pkg/handler/microapp/bundle"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/kataras/iris/v12"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CloneRequest is where a micro application is cloned to: the namespace of
+// App in Cluster, App defaults to the one of the path and Namespace, when
+// given, has to be its namespace. Domains and ClusterDomains map the domains
+// of the gateways to the ones of the target cluster, the gateways not mapped
+// keep their domain.
+type CloneRequest struct {
+	App            int64             `json:"app"`
+	Cluster        string            `json:"cluster"`
+	Namespace      string            `json:"namespace"`
+	Domains        map[string]string `json:"domains"`
+	ClusterDomains map[string]string `json:"clusterDomains"`
+}
+
+// CloneResult is the plan of the clone and the gateways which kept their
+// domain.
+type CloneResult struct {
+	*bundle.Plan
+	UnmappedGateways []string `json:"unmappedGateways"`
+}
+
+// CloneApplication copies the micro application of the path to a namespace of
+// another cluster: the MicroApp, its micro services and service entries,
+// gateways and traffic policies. Canaries go with the rollout of their
+// workloads and are left out. Micro services whose workload is not deployed
+// in the target are skipped and reported, with dryRun only the plan is
+// returned. The caller needs the permissions of an import on the target.
+func CloneApplication(mgr multiCluster.Manager, ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	dryRun, _ := ctx.URLParamBool(canaryhandler.QueryParameterDryRun)
+	appCtx := handler.ExtractAppContext(ctx)
+
+	req := &CloneRequest{}
+	if err := ctx.ReadJSON(req); err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+	if len(req.Cluster) == 0 {
+		handler.Response(ctx, http.StatusBadRequest, "缺少目标集群")
+		return
+	}
+	target, ok := cloneTarget(ctx, req)
+	if !ok {
+		return
+	}
+	if target.Cluster == appCtx.ClusterName && target.KubeNamespace == appCtx.KubeNamespace {
+		handler.Response(ctx, http.StatusBadRequest, "目标集群与命名空间不能与当前相同")
+		return
+	}
+
+	c := ctx.Request().Context()
+	kube, err := mgr.Client(req.Cluster)
+	if err != nil {
+		handler.Response(ctx, customErrors.StatusCodeUnProcessableEntity, fmt.Sprintf("集群连接失败 : %s", err))
+		return
+	}
+	_, err = kube.CoreV1().Namespaces().Get(c, req.Namespace, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		handler.Response(ctx, customErrors.StatusCodeResourceNotFound, fmt.Sprintf("命名空间%s在集群%s中不存在", req.Namespace, req.Cluster))
+		return
+	}
+	if err != nil {
+		handler.ResponseErr(ctx, err)
+		return
+	}
+
+	exported, err := exportApplication(c, mgr, ctx, name)
+	if err != nil {
+		logger.Errorf("export application %s for clone failed err: %s", name, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("克隆应用失败！", err))
+		return
+	}
+	documents := []*unstructured.Unstructured{}
+	for _, document := range exported {
+		if kind := document.GetKind(); kind != bundle.KindCanary && kind != bundle.KindCanaryPolicy {
+			documents = append(documents, document)
+		}
+	}
+	result := &CloneResult{UnmappedGateways: bundle.RemapDomains(documents, req.Domains, req.ClusterDomains)}
+	bundle.Sort(documents)
+
+	imp, err := newImporter(mgr, ctx, name, *target)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+	if result.Plan, err = imp.plan(c, documents); err != nil {
+		logger.Errorf("plan clone of application %s failed err: %s", name, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("克隆应用失败！", err))
+		return
+	}
+	if err := skipMissingWorkloads(c, kube, req.Namespace, result.Plan); err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("克隆应用失败！", err))
+		return
+	}
+	if dryRun {
+		handler.ResponseOk(ctx, result)
+		return
+	}
+
+	if conflicts := result.Conflicts(); len(conflicts) > 0 {
+		msgs := make([]string, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			msgs = append(msgs, conflict.String())
+		}
+		handler.ResponseMessageList(ctx, http.StatusConflict, msgs)
+		return
+	}
+
+	if err := imp.apply(c, result.Plan); err != nil {
+		logger.Errorf("clone application %s to %s/%s failed err: %s", name, req.Cluster, req.Namespace, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("克隆应用失败！", err))
+		return
+	}
+
+	handler.SendAudit(audit.ModuleMicroApplication, audit.ActionClone, fmt.Sprintf("%s -> %s/%s", name, req.Cluster, req.Namespace), ctx)
+	handler.ResponseOk(ctx, result)
+}
+
+// cloneTarget resolves the namespace the application is cloned to from the
+// app of the request and checks the caller may import into it. The response
+// is written when it fails.
+func cloneTarget(ctx iris.Context, req *CloneRequest) (*app.AppResources, bool) {
+	appCtx := handler.ExtractAppContext(ctx)
+	target := &app.AppResources{
+		AppId:         appCtx.AppId,
+		Cluster:       req.Cluster,
+		KubeNamespace: appCtx.KubeNamespace,
+		NamespaceId:   appCtx.NamespaceId,
+	}
+	if req.App > 0 && req.App != appCtx.AppId {
+		info, err := backend.GetClient().V1().App().AppInfoCache(req.App)
+		if err != nil {
+			logger.Errorf("get info of app %d failed err: %s", req.App, err)
+			handler.Response(ctx, http.StatusBadRequest, fmt.Sprintf("目标部门%d信息获取失败", req.App))
+			return nil, false
+		}
+		target.AppId = req.App
+		target.KubeNamespace = info.Namespace.KubeNamespace
+		target.NamespaceId = info.Namespace.Id
+	}
+	if len(req.Namespace) > 0 && req.Namespace != target.KubeNamespace {
+		handler.Response(ctx, http.StatusBadRequest, fmt.Sprintf("命名空间%s不属于目标部门", req.Namespace))
+		return nil, false
+	}
+	req.Namespace = target.KubeNamespace
+
+	if !auth.CheckPermissions(target.NamespaceId, target.AppId, handler.ExtractUserContext(ctx), ImportPermissions...) {
+		handler.Response(ctx, customErrors.StatusCodeUnAuthorized, "未授权")
+		return nil, false
+	}
+	return target, true
+}
+
+// skipMissingWorkloads skips the micro services to be created or updated whose
+// workload, of the kind they are bound to, is not deployed in the namespace,
+// with their traffic policies.
+func skipMissingWorkloads(c context.Context, kube kubernetes.Interface, namespace string, plan *bundle.Plan) error {
+	missing := map[string]string{}
+	for _, step := range plan.Steps {
+		if step.Action != bundle.ActionCreate && step.Action != bundle.ActionUpdate {
+			continue
+		}
+		kind, workload, ok := bundle.Workload(step.Document)
+		if !ok {
+			continue
+		}
+		var err error
+		switch kind {
+		case bundle.WorkloadDeployment:
+			_, err = kube.AppsV1().Deployments(namespace).Get(c, workload, metav1.GetOptions{})
+		case bundle.WorkloadStatefulSet:
+			_, err = kube.AppsV1().StatefulSets(namespace).Get(c, workload, metav1.GetOptions{})
+		case bundle.WorkloadDaemonSet:
+			_, err = kube.AppsV1().DaemonSets(namespace).Get(c, workload, metav1.GetOptions{})
+		default:
+			logger.Warnf("workload %s of micro service %s has unknown kind %s, it is not checked", workload, step.Name, kind)
+			continue
+		}
+		if k8serrors.IsNotFound(err) {
+			missing[step.Name] = workload
+			step.Action = bundle.ActionSkip
+			step.Reason = fmt.Sprintf("工作负载%s在目标集群中不存在", workload)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, step := range plan.Steps {
+		if workload, ok := missing[step.Name]; ok && step.Kind == bundle.KindTrafficPolicy {
+			step.Action = bundle.ActionSkip
+			step.Reason = fmt.Sprintf("工作负载%s在目标集群中不存在", workload)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package microapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/common"
+	"github.com/huhenry/hej/pkg/common/app"
+	"github.com/huhenry/hej/pkg/define"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	canaryhandler "github.com/huhenry/hej/pkg/handler/canary"
+	"github.com/huhenry/hej/pkg/handler/microapp/bundle"
+	micro "github.com/huhenry/hej/pkg/microapp"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/huhenry/hej/pkg/traffic"
+	"github.com/kataras/iris/v12"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	QueryParameterFormat = "format"
+
+	FormatYAML = "yaml"
+	FormatTar  = "tar"
+)
+
+var (
+	microAppGVK = &schema.GroupVersionKind{
+		Group:   common.ServiceMeshGroup,
+		Version: common.ServiceMeshVersion,
+		Kind:    common.MicroAppKind,
+	}
+	microServiceGVK = &schema.GroupVersionKind{
+		Group:   common.ServiceMeshGroup,
+		Version: common.ServiceMeshVersion,
+		Kind:    common.MicroServiceKind,
+	}
+	canaryGVK = &schema.GroupVersionKind{
+		Group:   common.ServiceMeshGroup,
+		Version: common.ServiceMeshVersion,
+		Kind:    common.CanaryKind,
+	}
+)
+
+// exportOptions drop what ties the resources to the tenant and the cluster
+// they were created in.
+var exportOptions = bundle.Options{
+	Labels: []string{define.LabelTpaasApp},
+	Fields: [][]string{
+		{"spec", "appId"},
+		{"spec", "namespaceId"},
+		{"spec", "cluster"},
+		{"spec", "kubeNamespace"},
+		{"spec", "workload", "namespace"},
+	},
+}
+
+// ExportApplication downloads the micro application as a bundle: the MicroApp,
+// its micro services and service entries, gateways, traffic policies and
+// running canaries with their policies.
+func ExportApplication(mgr multiCluster.Manager, ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	format := ctx.URLParamDefault(QueryParameterFormat, FormatYAML)
+	if format != FormatYAML && format != FormatTar {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(fmt.Errorf("不支持的导出格式%s", format)))
+		return
+	}
+
+	documents, err := exportApplication(ctx.Request().Context(), mgr, ctx, name)
+	if err != nil {
+		logger.Errorf("export application %s failed err: %s", name, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("导出应用失败！", err))
+		return
+	}
+
+	b := &bytes.Buffer{}
+	contentType := "application/x-yaml"
+	if format == FormatTar {
+		contentType = "application/x-tar"
+		err = bundle.WriteTar(b, documents)
+	} else {
+		err = bundle.WriteYAML(b, documents)
+	}
+	if err != nil {
+		logger.Errorf("write bundle of application %s failed err: %s", name, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("导出应用失败！", err))
+		return
+	}
+
+	ctx.Header("Content-type", contentType)
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	ctx.Write(b.Bytes())
+}
+
+// exportApplication collects the documents of the application in the order
+// they are to be applied.
+func exportApplication(c context.Context, mgr multiCluster.Manager, ctx iris.Context, name string) ([]*unstructured.Unstructured, error) {
+	appCtx := handler.ExtractAppContext(ctx)
+	clusterName := appCtx.ClusterName
+	namespace := appCtx.KubeNamespace
+	resource := app.AppResources{
+		AppId:         appCtx.AppId,
+		Cluster:       clusterName,
+		KubeNamespace: namespace,
+		NamespaceId:   appCtx.NamespaceId,
+	}
+
+	microAppClient, err := mgr.DynamicClient(clusterName, microAppGVK)
+	if err != nil {
+		return nil, err
+	}
+	microApp, err := microAppClient.Namespace(namespace).Get(c, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	documents := []*unstructured.Unstructured{bundle.Strip(microApp, exportOptions)}
+
+	// the micro services, the service entries and the gateways are those the
+	// console lists, each exported once as the object in place
+	microServiceClient, err := mgr.DynamicClient(clusterName, microServiceGVK)
+	if err != nil {
+		return nil, err
+	}
+	microServices, err := micro.MicroService().List(resource, name, false)
+	if err != nil {
+		return nil, err
+	}
+	serviceEntries, err := micro.MicroServiceEntry().List(resource, name)
+	if err != nil {
+		return nil, err
+	}
+	serviceNames := []string{}
+	seen := map[string]bool{}
+	for i := range microServices {
+		if !seen[microServices[i].Name] {
+			seen[microServices[i].Name] = true
+			serviceNames = append(serviceNames, microServices[i].Name)
+		}
+	}
+	for i := range serviceEntries {
+		if !seen[serviceEntries[i].Name] {
+			seen[serviceEntries[i].Name] = true
+			serviceNames = append(serviceNames, serviceEntries[i].Name)
+		}
+	}
+	for _, serviceName := range serviceNames {
+		document, err := exportObject(c, microServiceClient, namespace, serviceName)
+		if err != nil {
+			return nil, err
+		}
+		if document != nil {
+			documents = append(documents, document)
+		}
+	}
+
+	gatewayClient, err := mgr.DynamicClient(clusterName, gatewayGVK)
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := micro.Gateway().List(resource, name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range gateways {
+		document, err := exportObject(c, gatewayClient, namespace, gateways[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		if document != nil {
+			documents = append(documents, document)
+		}
+	}
+
+	for _, serviceName := range serviceNames {
+		settings, err := traffic.Policy().GetSettings(resource, serviceName)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get traffic policy of %s failed: %s", serviceName, err)
+		}
+		document, err := bundle.Document(bundle.KindTrafficPolicy, serviceName, settings)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	canaryClient, err := mgr.DynamicClient(clusterName, canaryGVK)
+	if err != nil {
+		return nil, err
+	}
+	canaries, err := canaryClient.Namespace(namespace).List(c, metav1.ListOptions{
+		LabelSelector: labels.Set{
+			define.LabelTpaasApp:            strconv.FormatInt(appCtx.AppId, 10),
+			common.LabelMicroApplicationKey: name,
+		}.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range canaries.Items {
+		cr := &canaries.Items[i]
+		if !canaryhandler.IsActive(cr.GetAnnotations()) {
+			continue
+		}
+		policy, err := canary.GetCanaryPolicy(c, canaryClient, microServiceClient, namespace, cr.GetName())
+		if err != nil {
+			return nil, fmt.Errorf("get policy of canary %s failed: %s", cr.GetName(), err)
+		}
+		document, err := bundle.Document(bundle.KindCanaryPolicy, cr.GetName(), policy)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, bundle.Strip(cr, exportOptions), document)
+	}
+
+	return documents, nil
+}
+
+// exportObject returns the stripped object, nil when it was deleted meanwhile.
+func exportObject(c context.Context, client dynamic.NamespaceableResourceInterface, namespace, name string) (*unstructured.Unstructured, error) {
+	un, err := client.Namespace(namespace).Get(c, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bundle.Strip(un, exportOptions), nil
+}
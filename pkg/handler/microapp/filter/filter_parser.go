@@ -1,70 +1,232 @@
 package filter
 
 import (
-	"github.com/huhenry/hej/pkg/log"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huhenry/hej/pkg/define"
 	"github.com/kataras/iris/v12"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-var logger = log.RegisterScope("microapp-filter")
+// Query parameters of the list endpoints.
+const (
+	ParamName          = "name"
+	ParamCreator       = "creator"
+	ParamStartTime     = "start_time"
+	ParamEndTime       = "end_time"
+	ParamLabelSelector = "labelSelector"
+	ParamStatus        = "status"
+	// ParamFilter is repeated, each one is field:op:value with op one of
+	// eq, contains or prefix.
+	ParamFilter = "filter"
+	// ParamSortBy is a comma separated list of field or field:asc|desc, the
+	// fields without direction go in the one of ParamOrder.
+	ParamSortBy = "sortby"
+	ParamOrder  = "order"
+
+	OpEqual    = "eq"
+	OpContains = "contains"
+	OpPrefix   = "prefix"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
 
-type Parser interface {
-	Parse(filterlist []Filter) []Filter
+// Query is the filters and the order of a list request.
+type Query struct {
+	Filters []Filter
+	Orders  []Order
 }
 
-type NameParser struct {
-	NameFilter
+// ParserParams reads the query of a list request for the elements of the
+// schema.
+func ParserParams(ctx iris.Context, schema Schema) (*Query, error) {
+	return Parse(ctx.Request().URL.Query(), schema)
 }
 
-func (parser NameParser) Parse(filterlist []Filter) []Filter {
-	name := parser.Name
-	if len(name) > 0 {
-		filterlist = append(filterlist, NameFilter{Name: name})
+// Parse reads the query of a list request, the errors are meant for the
+// user. Every parameter has to name fields of the schema, a list which cannot
+// be filtered as asked is an error rather than unfiltered. Without sortby the
+// list goes newest first.
+func Parse(values url.Values, schema Schema) (*Query, error) {
+	query := &Query{Filters: []Filter{}, Orders: []Order{}}
+	field := func(name string) error {
+		if _, ok := schema[name]; !ok {
+			return fmt.Errorf("不支持按%s过滤或排序", name)
+		}
+		return nil
+	}
+
+	if name := values.Get(ParamName); len(name) > 0 {
+		if err := field(FieldName); err != nil {
+			return nil, err
+		}
+		query.Filters = append(query.Filters, ContainsFilter{Field: FieldName, Value: name})
+	}
+	if creator := values.Get(ParamCreator); len(creator) > 0 {
+		if err := field(FieldCreator); err != nil {
+			return nil, err
+		}
+		query.Filters = append(query.Filters, CreatorFilter{Creator: creator})
 	}
-	return filterlist
 
+	startTime, endTime := values.Get(ParamStartTime), values.Get(ParamEndTime)
+	if len(startTime) > 0 || len(endTime) > 0 {
+		if err := field(FieldCreateTime); err != nil {
+			return nil, err
+		}
+		timeFilter := TimeFilter{Field: FieldCreateTime}
+		var err error
+		if timeFilter.StartTime, err = parseTime(ParamStartTime, startTime); err != nil {
+			return nil, err
+		}
+		if timeFilter.EndTime, err = parseTime(ParamEndTime, endTime); err != nil {
+			return nil, err
+		}
+		if timeFilter.StartTime > 0 && timeFilter.EndTime > 0 && timeFilter.StartTime > timeFilter.EndTime {
+			return nil, fmt.Errorf("%s不能晚于%s", ParamStartTime, ParamEndTime)
+		}
+		query.Filters = append(query.Filters, timeFilter)
+	}
+
+	if selector := values.Get(ParamLabelSelector); len(selector) > 0 {
+		if err := field(FieldLabels); err != nil {
+			return nil, err
+		}
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("%s格式错误: %s", ParamLabelSelector, err)
+		}
+		query.Filters = append(query.Filters, LabelFilter{Selector: parsed})
+	}
+	if status := values.Get(ParamStatus); len(status) > 0 {
+		if err := field(FieldStatus); err != nil {
+			return nil, err
+		}
+		query.Filters = append(query.Filters, PhaseFilter{Phases: strings.Split(status, ",")})
+	}
+
+	for _, expr := range values[ParamFilter] {
+		parts := strings.SplitN(expr, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s格式错误: %s", ParamFilter, expr)
+		}
+		if err := field(parts[0]); err != nil {
+			return nil, err
+		}
+		switch parts[1] {
+		case OpEqual:
+			query.Filters = append(query.Filters, EqualFilter{Field: parts[0], Value: parts[2]})
+		case OpContains:
+			query.Filters = append(query.Filters, ContainsFilter{Field: parts[0], Value: parts[2]})
+		case OpPrefix:
+			query.Filters = append(query.Filters, PrefixFilter{Field: parts[0], Value: parts[2]})
+		default:
+			return nil, fmt.Errorf("不支持的过滤方式%s", parts[1])
+		}
+	}
+
+	orders, err := parseOrders(values.Get(ParamSortBy), values.Get(ParamOrder), field)
+	if err != nil {
+		return nil, err
+	}
+	query.Orders = orders
+	return query, nil
 }
 
-type CreatorParser struct {
-	CreatorFilter
+func parseTime(param, value string) (int64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	t, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || t < 0 {
+		return 0, fmt.Errorf("%s须为秒级时间戳: %s", param, value)
+	}
+	return t, nil
 }
 
-func (parser CreatorParser) Parse(filterlist []Filter) []Filter {
-	creator := parser.Creator
-	if len(creator) > 0 {
-		filterlist = append(filterlist, CreatorFilter{Creator: creator})
+func parseOrders(sortby, order string, field func(string) error) ([]Order, error) {
+	// the consoles send the oldest first this way
+	if sortby == define.SortByCreateTime {
+		return []Order{{Field: FieldCreateTime}}, nil
+	}
+	if len(sortby) == 0 {
+		if field(FieldCreateTime) != nil {
+			return []Order{}, nil
+		}
+		return []Order{{Field: FieldCreateTime, Desc: true}}, nil
 	}
-	return filterlist
 
+	desc, err := parseDirection(order)
+	if err != nil {
+		return nil, err
+	}
+	orders := []Order{}
+	for _, key := range strings.Split(sortby, ",") {
+		key = strings.TrimSpace(key)
+		if len(key) == 0 {
+			continue
+		}
+		o := Order{Field: key, Desc: desc}
+		if i := strings.Index(key, ":"); i >= 0 {
+			o.Field = key[:i]
+			if o.Desc, err = parseDirection(key[i+1:]); err != nil {
+				return nil, err
+			}
+		}
+		if err := field(o.Field); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
 }
 
-type TimeParser struct {
-	TimeFilter
+func parseDirection(direction string) (bool, error) {
+	switch strings.ToLower(direction) {
+	case "", OrderAsc:
+		return false, nil
+	case OrderDesc:
+		return true, nil
+	}
+	return false, fmt.Errorf("不支持的排序方式%s", direction)
 }
 
-func (parser TimeParser) Parse(filterlist []Filter) []Filter {
-
-	startTime := parser.StartTime
-	endTime := parser.EndTime
-	if len(startTime) > 0 && len(endTime) > 0 {
-		filterlist = append(filterlist, TimeFilter{StartTime: startTime, EndTime: endTime})
+// Apply returns the elements kept by the filters in the order of the query,
+// elements equal on every field keep their order.
+func (q *Query) Apply(schema Schema, items []interface{}) []interface{} {
+	result := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if q.match(schema, item) {
+			result = append(result, item)
+		}
 	}
-	return filterlist
 
+	sort.SliceStable(result, func(i, j int) bool {
+		for _, o := range q.Orders {
+			c := compare(schema.get(o.Field, result[i]), schema.get(o.Field, result[j]))
+			if c == 0 {
+				continue
+			}
+			if o.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return result
 }
 
-func ParserParams(ctx iris.Context) []Filter {
-
-	filterlist := make([]Filter, 0)
-	name := ctx.URLParam("name")
-	creator := ctx.URLParam("creator")
-	startTime := ctx.URLParam("start_time")
-	endTime := ctx.URLParam("end_time")
-
-	nameParser := NameParser{NameFilter{Name: name}}
-	filterlist = nameParser.Parse(filterlist)
-	creatorParser := CreatorParser{CreatorFilter{Creator: creator}}
-	filterlist = creatorParser.Parse(filterlist)
-	timeParser := TimeParser{TimeFilter{StartTime: startTime, EndTime: endTime}}
-	filterlist = timeParser.Parse(filterlist)
-	return filterlist
+func (q *Query) match(schema Schema, item interface{}) bool {
+	for _, filter := range q.Filters {
+		if !filter.Filte(schema, item) {
+			return false
+		}
+	}
+	return true
 }
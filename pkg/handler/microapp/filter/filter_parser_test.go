@@ -1,54 +1,88 @@
 package filter_test
 
 import (
-	. "github.com/onsi/ginkgo"
+	"net/url"
 
 	. "github.com/huhenry/hej/pkg/handler/microapp/filter"
+	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
-var _ = Describe("FilterParser", func() {
-
-	Describe("test PraserPrams", func() {
-		Context("test Praserprams", func() {
-			It("应该是一个filterlist", func() {
-
-			})
-		})
-
-		RegisterFailHandler(Fail)
+func names(items []interface{}) []string {
+	result := []string{}
+	for _, i := range items {
+		result = append(result, i.(*item).name)
+	}
+	return result
+}
 
+var _ = Describe("FilterParser", func() {
+	items := []interface{}{
+		&item{name: "reviews", creator: "lisi", createTime: 300, phase: "Running"},
+		&item{name: "ratings", creator: "zhangsan", createTime: 100, phase: "Progressing"},
+		&item{name: "details", creator: "lisi", createTime: 200, phase: "Running"},
+		&item{name: "productpage", creator: "lisi", createTime: 200, phase: "Running"},
+	}
+
+	apply := func(query string) ([]string, error) {
+		values, err := url.ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+		q, err := Parse(values, schema)
+		if err != nil {
+			return nil, err
+		}
+		return names(q.Apply(schema, items)), nil
+	}
+
+	It("lists the newest first by default", func() {
+		Expect(apply("")).To(Equal([]string{"reviews", "details", "productpage", "ratings"}))
 	})
 
-	Describe("test Prase", func() {
-		Context("test TimePraser", func() {
-			It("应该是一个filterlist", func() {
-
-			})
-		})
-
-		Context("test NamePraser", func() {
-			It("应该是一个filterlist", func() {
-				name := "app"
-				filterlist := make([]Filter, 0)
-				namePraser := NamePraser{NameFilter{Name: name}}
-				filterlist = namePraser.Prase(filterlist)
-
-			})
-		})
-
-		Context("test CreatorPraser", func() {
-			It("应该是一个filterlist", func() {
-				creator := "lisi"
-				filterlist := make([]Filter, 0)
-				creatorPraser := CreatorPraser{CreatorFilter{Creator: creator}}
-				filterlist = creatorPraser.Prase(filterlist)
-
-			})
-		})
+	It("combines the filters", func() {
+		Expect(apply("name=E&status=Running&start_time=150")).To(Equal([]string{"reviews", "details", "productpage"}))
+		Expect(apply("filter=name:prefix:r&filter=creator:eq:lisi")).To(Equal([]string{"reviews"}))
+		Expect(apply("end_time=200&sortby=name")).To(Equal([]string{"details", "productpage", "ratings"}))
+	})
 
-		RegisterFailHandler(Fail)
+	It("sorts on several fields", func() {
+		Expect(apply("sortby=createTime:desc,name")).To(Equal([]string{"reviews", "details", "productpage", "ratings"}))
+		Expect(apply("sortby=createTime,name&order=desc")).To(Equal([]string{"reviews", "productpage", "details", "ratings"}))
+	})
 
+	It("rejects a bad query", func() {
+		for _, query := range []string{
+			"start_time=yesterday",
+			"end_time=-1",
+			"start_time=300&end_time=200",
+			"labelSelector=tier in (",
+			"filter=name:like:r",
+			"filter=name",
+			"filter=version:eq:v1",
+			"sortby=version",
+			"sortby=name:up",
+			"order=random&sortby=name",
+		} {
+			_, err := apply(query)
+			Expect(err).To(HaveOccurred(), query)
+		}
 	})
 
+	It("rejects the parameters of the fields the schema has not", func() {
+		nameOnly := Schema{FieldName: schema[FieldName]}
+		for _, values := range []url.Values{
+			{ParamStatus: {"Running"}},
+			{ParamStartTime: {"100"}},
+			{ParamEndTime: {"100"}},
+			{ParamLabelSelector: {"tier=web"}},
+			{ParamCreator: {"lisi"}},
+		} {
+			_, err := Parse(values, nameOnly)
+			Expect(err).To(HaveOccurred(), values.Encode())
+		}
+
+		q, err := Parse(url.Values{ParamName: {"r"}, ParamStatus: {""}}, nameOnly)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.Filters).To(HaveLen(1))
+		Expect(q.Orders).To(BeEmpty())
+	})
 })
@@ -1,77 +1,177 @@
 package filter
 
 import (
-	"strconv"
+	"fmt"
 	"strings"
 
 	"github.com/huhenry/hej/pkg/common"
-	v1beta1 "github.com/huhenry/hej/pkg/microapp/v1beta1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// Fields the list endpoints share, a schema may add its own.
+const (
+	FieldName       = "name"
+	FieldCreator    = "creator"
+	FieldCreateTime = "createTime"
+	FieldLabels     = "labels"
+	FieldStatus     = "status"
+)
+
+// Getter reads a field of an element of a list. It returns a string, an
+// integer or, for labels, a map[string]string, nil when the element has none.
+type Getter func(item interface{}) interface{}
+
+// Schema is the fields of the elements of a list which can be filtered and
+// sorted on.
+type Schema map[string]Getter
+
+func (s Schema) get(field string, item interface{}) interface{} {
+	if getter, ok := s[field]; ok {
+		return getter(item)
+	}
+	return nil
+}
+
+func (s Schema) getString(field string, item interface{}) string {
+	value := s.get(field, item)
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// Filter tells whether an element of a list is kept.
 type Filter interface {
-	Filte(microApp *v1beta1.MicroApp) *v1beta1.MicroApp
+	Filte(schema Schema, item interface{}) bool
 }
-type CreatorFilter struct {
-	Creator string
+
+// EqualFilter keeps the elements whose field is the value.
+type EqualFilter struct {
+	Field string
+	Value string
 }
 
-func (filter CreatorFilter) Filte(microApp *v1beta1.MicroApp) *v1beta1.MicroApp {
+func (filter EqualFilter) Filte(schema Schema, item interface{}) bool {
+	return schema.getString(filter.Field, item) == filter.Value
+}
 
-	if microApp == nil {
-		return nil
-	}
+// ContainsFilter keeps the elements whose field contains the value, case is
+// ignored.
+type ContainsFilter struct {
+	Field string
+	Value string
+}
 
-	if common.ParamIsFit(filter.Creator, microApp.Creator) {
-		return microApp
-	}
+func (filter ContainsFilter) Filte(schema Schema, item interface{}) bool {
+	return strings.Contains(strings.ToLower(schema.getString(filter.Field, item)), strings.ToLower(filter.Value))
+}
 
-	return nil
+// PrefixFilter keeps the elements whose field starts with the value.
+type PrefixFilter struct {
+	Field string
+	Value string
 }
 
-type NameFilter struct {
-	Name string
+func (filter PrefixFilter) Filte(schema Schema, item interface{}) bool {
+	return strings.HasPrefix(schema.getString(filter.Field, item), filter.Value)
 }
 
-func (filter NameFilter) Filte(microApp *v1beta1.MicroApp) *v1beta1.MicroApp {
-	if microApp == nil {
-		return nil
-	}
+type CreatorFilter struct {
+	Creator string
+}
 
-	if strings.Contains(microApp.Name, filter.Name) {
-		return microApp
+func (filter CreatorFilter) Filte(schema Schema, item interface{}) bool {
+	return common.ParamIsFit(filter.Creator, schema.getString(FieldCreator, item))
+}
+
+// TimeFilter keeps the elements whose field, in seconds, is within the range.
+// A zero bound leaves its side open.
+type TimeFilter struct {
+	Field     string
+	StartTime int64
+	EndTime   int64
+}
+
+func (filter TimeFilter) Filte(schema Schema, item interface{}) bool {
+	value, ok := toInt64(schema.get(filter.Field, item))
+	if !ok {
+		return false
+	}
+	if filter.StartTime > 0 && value < filter.StartTime {
+		return false
 	}
+	if filter.EndTime > 0 && value > filter.EndTime {
+		return false
+	}
+	return true
+}
 
-	return nil
+// LabelFilter keeps the elements whose labels match the selector.
+type LabelFilter struct {
+	Selector labels.Selector
 }
 
-type TimeFilter struct {
-	StartTime string
-	EndTime   string
+func (filter LabelFilter) Filte(schema Schema, item interface{}) bool {
+	set, _ := schema.get(FieldLabels, item).(map[string]string)
+	return filter.Selector.Matches(labels.Set(set))
 }
 
-func (filter TimeFilter) Filte(microApp *v1beta1.MicroApp) *v1beta1.MicroApp {
+// PhaseFilter keeps the elements in one of the phases.
+type PhaseFilter struct {
+	Phases []string
+}
 
-	if microApp == nil {
-		return nil
+func (filter PhaseFilter) Filte(schema Schema, item interface{}) bool {
+	phase := schema.getString(FieldStatus, item)
+	for _, p := range filter.Phases {
+		if strings.EqualFold(p, phase) {
+			return true
+		}
 	}
-	start_time := filter.StartTime
-	end_time := filter.EndTime
+	return false
+}
 
-	startTime, err := strconv.ParseInt(start_time, 10, 64)
-	if err != nil {
-		logger.Errorf("filter starttime convrt err %s", err)
+// Order sorts a list on a field.
+type Order struct {
+	Field string
+	Desc  bool
+}
 
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
 	}
-	endTime, err := strconv.ParseInt(end_time, 10, 64)
-	if err != nil {
-		logger.Errorf("filter endtime convert err %s", err)
+	return 0, false
+}
 
+// compare orders integers by value and anything else by its text, missing
+// values come first.
+func compare(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
 	}
-
-	if microApp.CreateTimeSec >= startTime && microApp.CreateTimeSec <= endTime {
-		return microApp
+	if x, ok := toInt64(a); ok {
+		if y, ok := toInt64(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
 	}
-
-	return nil
-
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
 }
@@ -2,39 +2,48 @@ package filter_test
 
 import (
 	. "github.com/huhenry/hej/pkg/handler/microapp/filter"
-	v1beta1 "github.com/huhenry/hej/pkg/microapp/v1beta1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-var _ = Describe("FilterType", func() {
-
-	Context("测试NameFilter", func() {
-		It("应该返回具有指定name的microapps", func() {
-
-			NameFilter := NameFilter{Name: "app"}
-			microAppAll := make([]v1beta1.MicroApp, 0)
-			microapps := NameFilter.Filte(microAppAll)
-			for _, microappItem := range microapps {
-				Expect(microappItem.Name).To(Equal("app"))
-			}
-		})
-		RegisterFailHandler(Fail)
+type item struct {
+	name       string
+	creator    string
+	createTime int64
+	labels     map[string]string
+	phase      string
+}
+
+var schema = Schema{
+	FieldName:       func(i interface{}) interface{} { return i.(*item).name },
+	FieldCreator:    func(i interface{}) interface{} { return i.(*item).creator },
+	FieldCreateTime: func(i interface{}) interface{} { return i.(*item).createTime },
+	FieldLabels:     func(i interface{}) interface{} { return i.(*item).labels },
+	FieldStatus:     func(i interface{}) interface{} { return i.(*item).phase },
+}
 
+var _ = Describe("FilterType", func() {
+	reviews := &item{name: "Reviews", creator: "lisi", createTime: 200, labels: map[string]string{"tier": "backend"}, phase: "Running"}
+
+	It("matches a field by equality, content and prefix", func() {
+		Expect(EqualFilter{Field: FieldName, Value: "Reviews"}.Filte(schema, reviews)).To(BeTrue())
+		Expect(EqualFilter{Field: FieldName, Value: "reviews"}.Filte(schema, reviews)).To(BeFalse())
+		Expect(ContainsFilter{Field: FieldName, Value: "VIEW"}.Filte(schema, reviews)).To(BeTrue())
+		Expect(PrefixFilter{Field: FieldName, Value: "Rev"}.Filte(schema, reviews)).To(BeTrue())
+		Expect(PrefixFilter{Field: FieldName, Value: "views"}.Filte(schema, reviews)).To(BeFalse())
 	})
 
-	Context("测试CreatorFilter", func() {
-		It("应该返回具有指定creator的microapps", func() {
-
-			CreatorFilter := CreatorFilter{Creator: "lisi"}
-			microAppAll := make([]v1beta1.MicroApp, 0)
-			microapps := CreatorFilter.Filte(microAppAll)
-			for _, microappItem := range microapps {
-				Expect(microappItem.Creator).To(Equal("lisi"))
-			}
-		})
-		RegisterFailHandler(Fail)
-
+	It("keeps a time range with open bounds", func() {
+		Expect(TimeFilter{Field: FieldCreateTime, StartTime: 100, EndTime: 200}.Filte(schema, reviews)).To(BeTrue())
+		Expect(TimeFilter{Field: FieldCreateTime, StartTime: 201}.Filte(schema, reviews)).To(BeFalse())
+		Expect(TimeFilter{Field: FieldCreateTime, EndTime: 150}.Filte(schema, reviews)).To(BeFalse())
 	})
 
+	It("matches labels and phases", func() {
+		Expect(LabelFilter{Selector: labels.SelectorFromSet(labels.Set{"tier": "backend"})}.Filte(schema, reviews)).To(BeTrue())
+		Expect(LabelFilter{Selector: labels.SelectorFromSet(labels.Set{"tier": "web"})}.Filte(schema, reviews)).To(BeFalse())
+		Expect(PhaseFilter{Phases: []string{"Progressing", "running"}}.Filte(schema, reviews)).To(BeTrue())
+		Expect(PhaseFilter{Phases: []string{"Progressing"}}.Filte(schema, reviews)).To(BeFalse())
+	})
 })
@@ -2,7 +2,6 @@ package microapp
 
 import (
 	"context"
-	"sort"
 	"strings"
 
 	"github.com/huhenry/hej/pkg/common/dynamic"
@@ -14,11 +13,11 @@ import (
 	"github.com/huhenry/hej/pkg/common"
 	"github.com/huhenry/hej/pkg/common/app"
 	"github.com/huhenry/hej/pkg/common/page"
-	"github.com/huhenry/hej/pkg/define"
 	customErrors "github.com/huhenry/hej/pkg/errors"
 	errors2 "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler"
 	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/microapp/filter"
 	micro "github.com/huhenry/hej/pkg/microapp"
 	"github.com/huhenry/hej/pkg/microapp/v1beta1"
 	"github.com/huhenry/hej/pkg/multiCluster"
@@ -219,7 +218,7 @@ func DeleteGateway(mgr multiCluster.Manager, ctx iris.Context) {
 	if err != nil {
 		handler.ResponseErr(ctx, err)
 	} else {
-
+		gw.AppResources = resource
 		DropDomainValidation(mgr, ctx, gw)
 		handler.SendAudit(audit.ModuleGateway, audit.ActionDelete, gw.AuditMessage(), ctx)
 		handler.ResponseOk(ctx, nil)
@@ -236,32 +235,37 @@ func ListGateway(ctx iris.Context) {
 		NamespaceId:   appCtx.NamespaceId,
 	}
 	paramQuery := handler.ExtractQueryParam(ctx)
+	query, err := filter.ParserParams(ctx, gatewaySchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, errors2.BadParametersErr(err))
+		return
+	}
 
 	gateways, err := micro.Gateway().List(resource, application)
 	if err != nil {
 		handler.ResponseErr(ctx, err)
 	} else {
-		sort.Slice(gateways, func(i, j int) bool {
-			r := gateways[i].CreateTimeSec > gateways[j].CreateTimeSec
-			if paramQuery.Sortby == define.SortByCreateTime {
-				r = !r
-			}
-
-			return r
-		})
-
 		data := make([]interface{}, 0)
 		for i := range gateways {
-			data = append(data, gateways[i])
+			data = append(data, &gateways[i])
 		}
 
-		handler.ResponseOk(ctx, page.PageInfo(data, paramQuery))
+		handler.ResponseOk(ctx, page.PageInfo(query.Apply(gatewaySchema, data), paramQuery))
 	}
 }
+
+var gatewaySchema = filter.Schema{
+	filter.FieldName:       func(item interface{}) interface{} { return item.(*v1beta1.GatewayEntity).Name },
+	filter.FieldCreator:    func(item interface{}) interface{} { return item.(*v1beta1.GatewayEntity).Creator },
+	filter.FieldCreateTime: func(item interface{}) interface{} { return item.(*v1beta1.GatewayEntity).CreateTimeSec },
+	"domain":               func(item interface{}) interface{} { return item.(*v1beta1.GatewayEntity).Domain },
+}
+
+// DropDomainValidation releases the domain and path of the gateway in the
+// cluster of the gateway.
 func DropDomainValidation(mgr multiCluster.Manager, ctx iris.Context, gw *v1beta1.GatewayEntity) error {
-	appCtx := handler.ExtractAppContext(ctx)
 	domainPath := gw.GetDomainHashPath()
-	domainClient, err := mgr.DynamicClient(appCtx.ClusterName, DomianValidationGVK)
+	domainClient, err := mgr.DynamicClient(gw.AppResources.Cluster, DomianValidationGVK)
 	if err != nil {
 		logger.Errorf("Dynamic Client %+v, %s", *DomianValidationGVK, err)
 		return errors2.CustomClientErr("删除失败", err)
@@ -276,14 +280,16 @@ func DropDomainValidation(mgr multiCluster.Manager, ctx iris.Context, gw *v1beta
 
 }
 
+// DomainValidation checks the domain and path of the gateway are free in the
+// cluster of the gateway.
 func DomainValidation(mgr multiCluster.Manager, ctx iris.Context, gw *v1beta1.GatewayEntity) error {
 
-	appCtx := handler.ExtractAppContext(ctx)
+	clusterName := gw.AppResources.Cluster
 	domain := gw.GetDomain()
 	domainPath := gw.GetDomainHashPath()
 
 	if len(gw.ClusterDomain) > 0 {
-		domainClient, err := mgr.DynamicClient(appCtx.ClusterName, domianGVK)
+		domainClient, err := mgr.DynamicClient(clusterName, domianGVK)
 		if err != nil {
 			logger.Errorf("Dynamic Client %+v, %s", *DomianValidationGVK, err)
 
@@ -311,7 +317,7 @@ func DomainValidation(mgr multiCluster.Manager, ctx iris.Context, gw *v1beta1.Ga
 
 	}
 
-	domainClient, err := mgr.DynamicClient(appCtx.ClusterName, DomianValidationGVK)
+	domainClient, err := mgr.DynamicClient(clusterName, DomianValidationGVK)
 	if err != nil {
 		logger.Errorf("Dynamic Client %+v, %s", *DomianValidationGVK, err)
 
@@ -352,14 +358,16 @@ func DomainValidation(mgr multiCluster.Manager, ctx iris.Context, gw *v1beta1.Ga
 
 }
 
+// RegisteDomainValidation reserves the domain and path of the gateway in the
+// cluster of the gateway.
 func RegisteDomainValidation(mgr multiCluster.Manager, ctx iris.Context, gw *v1beta1.GatewayEntity) error {
 
-	appCtx := handler.ExtractAppContext(ctx)
+	clusterName := gw.AppResources.Cluster
 	domain := gw.GetDomain()
 	hashPath := hash.HashToString(gw.Path)
 	domainPath := gw.GetDomainHashPath()
 
-	domainClient, err := mgr.DynamicClient(appCtx.ClusterName, DomianValidationGVK)
+	domainClient, err := mgr.DynamicClient(clusterName, DomianValidationGVK)
 	if err != nil {
 		logger.Errorf("Dynamic Client %+v, %s", *DomianValidationGVK, err)
 
@@ -0,0 +1,447 @@
+package microapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	backendV1 "github.com/huhenry/hej/pkg/backend/v1"
+	"github.com/huhenry/hej/pkg/canary"
+	"github.com/huhenry/hej/pkg/common"
+	"github.com/huhenry/hej/pkg/common/app"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/auth"
+	canaryhandler "github.com/huhenry/hej/pkg/handler/canary"
+	"github.com/huhenry/hej/pkg/handler/microapp/bundle"
+	micro "github.com/huhenry/hej/pkg/microapp"
+	"github.com/huhenry/hej/pkg/microapp/v1beta1"
+	"github.com/huhenry/hej/pkg/multiCluster"
+	"github.com/huhenry/hej/pkg/traffic"
+	"github.com/huhenry/hej/pkg/traffic/policy"
+	"github.com/kataras/iris/v12"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ImportPermissions are the permissions of the endpoints an import stands in
+// for: creating and editing the application, binding its workloads and
+// editing traffic and canary policies.
+var ImportPermissions = []auth.Permission{auth.MC, auth.MU, auth.SU, auth.DU, auth.CU}
+
+// ImportApplication applies a bundle written by ExportApplication to the
+// namespace of the path. With dryRun only the plan is returned, a bundle with
+// conflicts is not applied at all.
+func ImportApplication(mgr multiCluster.Manager, ctx iris.Context) {
+	dryRun, _ := ctx.URLParamBool(canaryhandler.QueryParameterDryRun)
+
+	documents, err := bundle.Read(http.MaxBytesReader(ctx.ResponseWriter(), ctx.Request().Body, bundle.MaxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			handler.Response(ctx, http.StatusRequestEntityTooLarge, fmt.Sprintf("导入文件不能超过%dMB", bundle.MaxSize>>20))
+			return
+		}
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+	application, err := bundle.Validate(documents)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
+	bundle.Sort(documents)
+
+	appCtx := handler.ExtractAppContext(ctx)
+	target := app.AppResources{
+		AppId:         appCtx.AppId,
+		Cluster:       appCtx.ClusterName,
+		KubeNamespace: appCtx.KubeNamespace,
+		NamespaceId:   appCtx.NamespaceId,
+	}
+	imp, err := newImporter(mgr, ctx, application, target)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.DynamicClientErr(err))
+		return
+	}
+	plan, err := imp.plan(ctx.Request().Context(), documents)
+	if err != nil {
+		logger.Errorf("plan import of application %s failed err: %s", application, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("导入应用失败！", err))
+		return
+	}
+	if dryRun {
+		handler.ResponseOk(ctx, plan)
+		return
+	}
+
+	if conflicts := plan.Conflicts(); len(conflicts) > 0 {
+		msgs := make([]string, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			msgs = append(msgs, conflict.String())
+		}
+		handler.ResponseMessageList(ctx, http.StatusConflict, msgs)
+		return
+	}
+
+	if err := imp.apply(ctx.Request().Context(), plan); err != nil {
+		logger.Errorf("import application %s failed err: %s", application, err)
+		handler.RespondWithDetailedError(ctx, customErrors.CustomClientErr("导入应用失败！", err))
+		return
+	}
+
+	handler.SendAudit(audit.ModuleMicroApplication, audit.ActionImport, application, ctx)
+	handler.ResponseOk(ctx, plan)
+}
+
+// importer plans and applies the documents of a bundle to the namespace of
+// its resource, which may be in another cluster than the request. The objects
+// are created and updated through the same services as the console so they
+// are bound and validated alike.
+type importer struct {
+	mgr         multiCluster.Manager
+	ctx         iris.Context
+	application string
+	namespace   string
+	resource    app.AppResources
+	creator     string
+	clients     map[string]dynamic.NamespaceableResourceInterface
+}
+
+func newImporter(mgr multiCluster.Manager, ctx iris.Context, application string, resource app.AppResources) (*importer, error) {
+	imp := &importer{
+		mgr:         mgr,
+		ctx:         ctx,
+		application: application,
+		namespace:   resource.KubeNamespace,
+		resource:    resource,
+		creator:     handler.ExtractUserContext(ctx).Name,
+		clients:     map[string]dynamic.NamespaceableResourceInterface{},
+	}
+
+	for kind, gvk := range map[string]*schema.GroupVersionKind{
+		bundle.KindMicroApp:     microAppGVK,
+		bundle.KindMicroService: microServiceGVK,
+		bundle.KindGateway:      gatewayGVK,
+		bundle.KindCanary:       canaryGVK,
+	} {
+		client, err := mgr.DynamicClient(resource.Cluster, gvk)
+		if err != nil {
+			return nil, err
+		}
+		imp.clients[kind] = client
+	}
+	return imp, nil
+}
+
+// get returns the object in place, nil when there is none.
+func (imp *importer) get(c context.Context, kind, name string) (*unstructured.Unstructured, error) {
+	un, err := imp.clients[kind].Namespace(imp.namespace).Get(c, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return un, err
+}
+
+func (imp *importer) plan(c context.Context, documents []*unstructured.Unstructured) (*bundle.Plan, error) {
+	plan := &bundle.Plan{Application: imp.application, Steps: []*bundle.Step{}}
+	for _, document := range documents {
+		name := document.GetName()
+
+		switch document.GetKind() {
+		case bundle.KindMicroApp, bundle.KindMicroService, bundle.KindGateway:
+			existing, err := imp.get(c, document.GetKind(), name)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				existing = bundle.Strip(existing, exportOptions)
+			}
+			step := plan.Add(document, existing, common.LabelMicroApplicationKey)
+			if document.GetKind() == bundle.KindGateway {
+				if err := imp.planGateway(step, existing); err != nil {
+					step.Action = bundle.ActionConflict
+					step.Reason = err.Error()
+				}
+			}
+
+		case bundle.KindTrafficPolicy:
+			var existing *unstructured.Unstructured
+			settings, err := traffic.Policy().GetSettings(imp.resource, name)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+			if err == nil {
+				if existing, err = bundle.Document(bundle.KindTrafficPolicy, name, settings); err != nil {
+					return nil, err
+				}
+			}
+			plan.Add(document, existing, "")
+
+		// canaries go with the rollout of their workload, they are never
+		// created by an import, only the policy of a running one is updated
+		case bundle.KindCanary:
+			existing, err := imp.get(c, bundle.KindCanary, name)
+			if err != nil {
+				return nil, err
+			}
+			step := plan.Add(document, nil, "")
+			step.Action = bundle.ActionSkip
+			step.Reason = "灰度任务需随工作负载发布创建"
+			if existing != nil {
+				step.Action = bundle.ActionUnchanged
+				step.Reason = ""
+			}
+
+		case bundle.KindCanaryPolicy:
+			step, err := imp.planCanaryPolicy(c, plan, document)
+			if err != nil {
+				return nil, err
+			}
+			if step.Action != bundle.ActionUpdate {
+				continue
+			}
+			p, err := imp.canaryPolicy(document)
+			if err != nil {
+				step.Action = bundle.ActionConflict
+				step.Reason = err.Error()
+			} else if errs := canaryhandler.ValidatePolicy(p); len(errs) > 0 {
+				step.Action = bundle.ActionConflict
+				step.Reason = errs.Error()
+			}
+		}
+	}
+	return plan, nil
+}
+
+// planGateway checks the domain of a gateway created or moved to another
+// domain is free.
+func (imp *importer) planGateway(step *bundle.Step, existing *unstructured.Unstructured) error {
+	if step.Action != bundle.ActionCreate && step.Action != bundle.ActionUpdate {
+		return nil
+	}
+	gw, err := imp.gateway(step.Document)
+	if err != nil {
+		return err
+	}
+	if err := validateGateway(gw); err != nil {
+		return err
+	}
+	if existing != nil {
+		before, err := imp.gateway(existing)
+		if err == nil && before.GetDomainHashPath() == gw.GetDomainHashPath() {
+			return nil
+		}
+	}
+	return DomainValidation(imp.mgr, imp.ctx, gw)
+}
+
+func (imp *importer) planCanaryPolicy(c context.Context, plan *bundle.Plan, document *unstructured.Unstructured) (*bundle.Step, error) {
+	name := document.GetName()
+	cr, err := imp.get(c, bundle.KindCanary, name)
+	if err != nil {
+		return nil, err
+	}
+	if cr == nil {
+		step := plan.Add(document, nil, "")
+		step.Action = bundle.ActionSkip
+		step.Reason = fmt.Sprintf("灰度任务%s不存在", name)
+		return step, nil
+	}
+	if !canaryhandler.IsActive(cr.GetAnnotations()) {
+		step := plan.Add(document, nil, "")
+		step.Action = bundle.ActionConflict
+		step.Reason = fmt.Sprintf("灰度任务%s已结束", name)
+		return step, nil
+	}
+	if err := canaryhandler.FrozenErr(name, cr.GetAnnotations()); err != nil {
+		step := plan.Add(document, nil, "")
+		step.Action = bundle.ActionConflict
+		step.Reason = err.Error()
+		return step, nil
+	}
+
+	current, err := canary.GetCanaryPolicy(c, imp.clients[bundle.KindCanary], imp.clients[bundle.KindMicroService], imp.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := bundle.Document(bundle.KindCanaryPolicy, name, current)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Add(document, existing, ""), nil
+}
+
+func (imp *importer) apply(c context.Context, plan *bundle.Plan) error {
+	for _, step := range plan.Steps {
+		if step.Action != bundle.ActionCreate && step.Action != bundle.ActionUpdate {
+			continue
+		}
+		if err := imp.applyStep(c, step); err != nil {
+			return fmt.Errorf("%s %s: %s", step.Kind, step.Name, err)
+		}
+	}
+	return nil
+}
+
+func (imp *importer) applyStep(c context.Context, step *bundle.Step) error {
+	document := step.Document
+	name := document.GetName()
+	create := step.Action == bundle.ActionCreate
+
+	switch step.Kind {
+	case bundle.KindMicroApp:
+		description, _, _ := unstructured.NestedString(document.Object, "spec", "description")
+		if !create {
+			if err := micro.MicroApplicaiton().Update(imp.resource, name, description); err != nil {
+				return err
+			}
+			imp.audit(audit.ModuleMicroApplication, audit.ActionUpdate, name)
+			return nil
+		}
+		microapp := &v1beta1.MicroApp{}
+		microapp.Name = name
+		microapp.Description = description
+		microapp.AppId = imp.resource.AppId
+		microapp.Cluster = imp.resource.Cluster
+		microapp.KubeNamespace = imp.resource.KubeNamespace
+		microapp.NamespaceId = imp.resource.NamespaceId
+		microapp.Creator = imp.creator
+		if err := micro.MicroApplicaiton().Create(microapp); err != nil {
+			return err
+		}
+		imp.audit(audit.ModuleMicroApplication, audit.ActionCreate, name)
+
+	case bundle.KindMicroService:
+		ms := &v1beta1.MicroServiceEntity{}
+		if err := common.JsonConvert(document.Object["spec"], ms); err != nil {
+			return err
+		}
+		ms.AppResources = imp.resource
+		ms.Creator = imp.creator
+		ms.Name = name
+		ms.Application = imp.application
+		ms.Workload.Namespace = imp.namespace
+		target := imp.application + "/" + name
+
+		if bundle.IsServiceEntry(document) {
+			if !create {
+				if err := micro.MicroServiceEntry().Update(imp.resource, ms); err != nil {
+					return err
+				}
+				imp.audit(audit.ModuleMicroApplication, audit.ActionPut+audit.ModuleServiceEntry, target)
+				return nil
+			}
+			if err := micro.MicroServiceEntry().Create(ms); err != nil {
+				return err
+			}
+			for _, host := range ms.ServiceEntry.Hosts {
+				createDomainValidation(imp.mgr, imp.resource.Cluster, host)
+			}
+			imp.audit(audit.ModuleMicroApplication, audit.ActionCreate+audit.ModuleServiceEntry, target)
+			return nil
+		}
+
+		if !create {
+			if err := convertCheckingBindingError(micro.MicroService().Update(imp.resource, ms)); err != nil {
+				return err
+			}
+			imp.audit(audit.ModuleMicroService, audit.ActionUpdate, target)
+			return nil
+		}
+		if err := convertCheckingBindingError(micro.MicroService().Create(ms)); err != nil {
+			return err
+		}
+		imp.audit(audit.ModuleMicroService, audit.ActionCreate, target)
+
+	case bundle.KindGateway:
+		gw, err := imp.gateway(document)
+		if err != nil {
+			return err
+		}
+		if err := validateGateway(gw); err != nil {
+			return err
+		}
+		if !create {
+			return imp.updateGateway(gw)
+		}
+		if err := micro.Gateway().Create(gw); err != nil {
+			return err
+		}
+		RegisteDomainValidation(imp.mgr, imp.ctx, gw)
+		imp.audit(audit.ModuleGateway, audit.ActionCreate, gw.AuditMessage())
+
+	case bundle.KindTrafficPolicy:
+		settings := &policy.Settings{}
+		if err := common.JsonConvert(document.Object["spec"], settings); err != nil {
+			return err
+		}
+		if err := traffic.Policy().SetSettings(imp.resource, imp.application, name, settings); err != nil {
+			return err
+		}
+		imp.audit(audit.ModuleMicroService, audit.ActionTrafficPolicy, imp.application+"/"+name)
+
+	case bundle.KindCanaryPolicy:
+		p, err := imp.canaryPolicy(document)
+		if err != nil {
+			return err
+		}
+		return canaryhandler.ApplyCanaryPolicy(c, imp.clients[bundle.KindCanary], imp.namespace, name, *p, imp.ctx)
+	}
+	return nil
+}
+
+// audit records an operation on the app the importer writes to, which is not
+// the app of the request when cloning.
+func (imp *importer) audit(module, action, target string) {
+	audit.Send([]*backendV1.Audit{handler.NewAppAudit(module, action, target,
+		imp.resource.AppId, imp.resource.Cluster, imp.resource.NamespaceId, imp.ctx)})
+}
+
+// updateGateway updates the gateway through the gateway service, a gateway
+// moved to another domain reserves the new one and releases the old one.
+func (imp *importer) updateGateway(gw *v1beta1.GatewayEntity) error {
+	before, err := micro.Gateway().Get(imp.resource, imp.application, gw.Name)
+	if err != nil {
+		return err
+	}
+	if err := micro.Gateway().Update(imp.resource, gw); err != nil {
+		return err
+	}
+	if before.GetDomainHashPath() != gw.GetDomainHashPath() {
+		if err := RegisteDomainValidation(imp.mgr, imp.ctx, gw); err != nil {
+			logger.Errorf("reserve domain of gateway %s failed err: %s", gw.Name, err)
+		}
+		before.AppResources = imp.resource
+		if err := DropDomainValidation(imp.mgr, imp.ctx, before); err != nil {
+			logger.Errorf("release domain of gateway %s failed err: %s", gw.Name, err)
+		}
+	}
+	imp.audit(audit.ModuleGateway, audit.ActionUpdate, gw.AuditMessage())
+	return nil
+}
+
+func (imp *importer) gateway(document *unstructured.Unstructured) (*v1beta1.GatewayEntity, error) {
+	gw := &v1beta1.GatewayEntity{}
+	if err := common.JsonConvert(document.Object["spec"], &gw.GatewaySpec); err != nil {
+		return nil, fmt.Errorf("网关%s格式错误: %s", document.GetName(), err)
+	}
+	gw.Name = document.GetName()
+	gw.AppResources = imp.resource
+	gw.Creator = imp.creator
+	gw.Application = imp.application
+	return gw, nil
+}
+
+func (imp *importer) canaryPolicy(document *unstructured.Unstructured) (*canary.CanaryPolicy, error) {
+	p := &canary.CanaryPolicy{}
+	if err := common.JsonConvert(document.Object["spec"], p); err != nil {
+		return nil, fmt.Errorf("灰度任务%s的策略格式错误: %s", document.GetName(), err)
+	}
+	return p, nil
+}
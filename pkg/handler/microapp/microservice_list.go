@@ -2,15 +2,15 @@ package microapp
 
 import (
 	"reflect"
-	"sort"
 
 	"github.com/huhenry/hej/pkg/microapp/resource"
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/huhenry/hej/pkg/common/app"
 	"github.com/huhenry/hej/pkg/common/page"
-	"github.com/huhenry/hej/pkg/define"
+	customErrors "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/microapp/filter"
 	micro "github.com/huhenry/hej/pkg/microapp"
 	microappcommon "github.com/huhenry/hej/pkg/microapp/common"
 	"github.com/huhenry/hej/pkg/microapp/v1beta1"
@@ -49,6 +49,11 @@ func ListMicroService(ctx iris.Context) {
 		NamespaceId:   appCtx.NamespaceId,
 	}
 	paramQuery := handler.ExtractQueryParam(ctx)
+	query, err := filter.ParserParams(ctx, microServiceSchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return
+	}
 
 	microservices, err := micro.MicroService().List(resource, application, true)
 	if err != nil {
@@ -62,24 +67,20 @@ func ListMicroService(ctx iris.Context) {
 	}
 	list := entity2List(microservices, application, stats)
 
-	if err != nil {
-		handler.ResponseErr(ctx, err)
-	} else {
-		sort.Slice(list, func(i, j int) bool {
-			r := list[i].CreateTimeSec > list[j].CreateTimeSec
-			if paramQuery.Sortby == define.SortByCreateTime {
-				r = !r
-			}
+	data := make([]interface{}, 0)
+	for i := range list {
+		data = append(data, list[i])
+	}
 
-			return r
-		})
-		data := make([]interface{}, 0)
-		for i := range list {
-			data = append(data, list[i])
-		}
+	handler.ResponseOk(ctx, page.PageInfo(query.Apply(microServiceSchema, data), paramQuery))
+}
 
-		handler.ResponseOk(ctx, page.PageInfo(data, paramQuery))
-	}
+var microServiceSchema = filter.Schema{
+	filter.FieldName:       func(item interface{}) interface{} { return item.(*MicroServiceListItem).Name },
+	filter.FieldCreator:    func(item interface{}) interface{} { return item.(*MicroServiceListItem).Creator },
+	filter.FieldCreateTime: func(item interface{}) interface{} { return item.(*MicroServiceListItem).CreateTimeSec },
+	filter.FieldStatus:     func(item interface{}) interface{} { return string(item.(*MicroServiceListItem).Phase) },
+	"version":              func(item interface{}) interface{} { return item.(*MicroServiceListItem).Version },
 }
 
 func entity2List(source []v1beta1.MicroServiceEntity, application string, stats *resource.ServiceWorkloadStats) []*MicroServiceListItem {
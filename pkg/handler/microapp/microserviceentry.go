@@ -4,17 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/huhenry/hej/pkg/common"
 	"github.com/huhenry/hej/pkg/common/app"
 	"github.com/huhenry/hej/pkg/common/page"
-	"github.com/huhenry/hej/pkg/define"
 	microapierrors "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler"
 	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/handler/microapp/filter"
 	micro "github.com/huhenry/hej/pkg/microapp"
 	"github.com/huhenry/hej/pkg/microapp/v1beta1"
 	"github.com/huhenry/hej/pkg/multiCluster"
@@ -98,7 +97,7 @@ func CreateMicroServiceEntry(mgr multiCluster.Manager, ctx iris.Context) {
 		return
 	} else {
 		for _, host := range ms.ServiceEntry.Hosts {
-			createDomainValidation(mgr, ctx, host)
+			createDomainValidation(mgr, ms.AppResources.Cluster, host)
 		}
 		handler.SendAudit(audit.ModuleMicroApplication, audit.ActionCreate+audit.ModuleServiceEntry, ms.Application+"/"+ms.Name, ctx)
 		handler.ResponseOk(ctx, nil)
@@ -126,12 +125,19 @@ func UpdateMicroServiceEntry(mgr multiCluster.Manager, ctx iris.Context) {
 		return
 	}
 	ms := buildMicroServiceEntryEntity(ctx, creation)
+	before := handler.AuditBefore(micro.MicroServiceEntry().Get(resource, ms.Name, ms.Application))
 	err = micro.MicroServiceEntry().Update(resource, ms)
 	if err != nil {
 		handler.ResponseErr(ctx, err)
 		return
 	} else {
-		handler.SendAudit(audit.ModuleMicroApplication, audit.ActionPut+audit.ModuleServiceEntry, ms.Application+"/"+ms.Name, ctx)
+		after, err := micro.MicroServiceEntry().Get(resource, ms.Name, ms.Application)
+		if err != nil {
+			logger.Warnf("get service entry %s after update failed err: %s", ms.Name, err)
+			handler.SendAudit(audit.ModuleMicroApplication, audit.ActionPut+audit.ModuleServiceEntry, ms.Application+"/"+ms.Name, ctx)
+		} else {
+			handler.SendAuditWithDiff(audit.ModuleMicroApplication, audit.ActionPut+audit.ModuleServiceEntry, ms.Application+"/"+ms.Name, before, after, ctx)
+		}
 		handler.ResponseOk(ctx, nil)
 	}
 }
@@ -268,32 +274,34 @@ func ListMicroServiceEntry(ctx iris.Context) {
 		NamespaceId:   appCtx.NamespaceId,
 	}
 	paramQuery := handler.ExtractQueryParam(ctx)
+	query, err := filter.ParserParams(ctx, serviceEntrySchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, microapierrors.BadParametersErr(err))
+		return
+	}
 
 	microservices, err := micro.MicroServiceEntry().List(resource, application)
 	if err != nil {
 		handler.ResponseErr(ctx, err)
 		return
 	}
-	keyName := strings.ToLower(paramQuery.Name)
-	list := serviceEntryEntity2List(microservices, application, keyName)
-
-	sort.Slice(list, func(i, j int) bool {
-		r := list[i].CreateTimeSec > list[j].CreateTimeSec
-		if paramQuery.Sortby == define.SortByCreateTime {
-			r = !r
-		}
+	list := serviceEntryEntity2List(microservices, application)
 
-		return r
-	})
 	data := make([]interface{}, 0)
 	for i := range list {
 		data = append(data, list[i])
 	}
 
-	handler.ResponseOk(ctx, page.PageInfo(data, paramQuery))
+	handler.ResponseOk(ctx, page.PageInfo(query.Apply(serviceEntrySchema, data), paramQuery))
 
 }
 
+var serviceEntrySchema = filter.Schema{
+	filter.FieldName:       func(item interface{}) interface{} { return item.(*MicroServiceEntryItem).Name },
+	filter.FieldCreator:    func(item interface{}) interface{} { return item.(*MicroServiceEntryItem).Creator },
+	filter.FieldCreateTime: func(item interface{}) interface{} { return item.(*MicroServiceEntryItem).CreateTimeSec },
+}
+
 func GetMicroServiceEntry(ctx iris.Context) {
 	application := ctx.Params().GetString("application")
 	serviceName := ctx.Params().GetString("name")
@@ -343,19 +351,11 @@ func convertServiceEntry(source *v1beta1.MicroServiceEntity, application string)
 	item.Description = source.ServiceEntry.Description
 	return item
 }
-func serviceEntryEntity2List(source []v1beta1.MicroServiceEntity, application, name string) []*MicroServiceEntryItem {
+func serviceEntryEntity2List(source []v1beta1.MicroServiceEntity, application string) []*MicroServiceEntryItem {
 
 	list := make([]*MicroServiceEntryItem, 0, len(source))
 	for i := range source {
-		item := convertServiceEntry(&source[i], application)
-		if name != "" {
-			if strings.Contains(item.Name, name) {
-				list = append(list, item)
-			}
-		} else {
-			list = append(list, item)
-		}
-
+		list = append(list, convertServiceEntry(&source[i], application))
 	}
 
 	return list
@@ -431,11 +431,10 @@ func DomainValidationServiceEntry(mgr multiCluster.Manager, ctx iris.Context, ho
 	return nil
 }
 
-func createDomainValidation(mgr multiCluster.Manager, ctx iris.Context, host string) error {
-	appCtx := handler.ExtractAppContext(ctx)
+func createDomainValidation(mgr multiCluster.Manager, clusterName string, host string) error {
 	domain := host
 
-	var domainClient, err = mgr.DynamicClient(appCtx.ClusterName, DomianValidationGVK)
+	var domainClient, err = mgr.DynamicClient(clusterName, DomianValidationGVK)
 	if err != nil {
 		logger.Errorf("Dynamic Client %+v, %s", *DomianValidationGVK, err)
 		return err
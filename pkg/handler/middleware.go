@@ -1,13 +1,15 @@
 package handler
 
 import (
-	"strings"
+	stderrors "errors"
+	"net/http"
 
 	"github.com/huhenry/hej/pkg/errors"
 
 	"github.com/huhenry/hej/pkg/backend"
 	"github.com/huhenry/hej/pkg/define"
 	"github.com/huhenry/hej/pkg/log"
+	"github.com/huhenry/hej/pkg/token"
 	"github.com/kataras/iris/v12"
 )
 
@@ -57,32 +59,46 @@ func ExtractAppCluster(ctx iris.Context) {
 	ctx.Next()
 }
 
-func VerifyToken(ctx iris.Context) {
-	token := ctx.Request().Header.Get("Authorization")
-	if token == "" {
-		Response(ctx, 401, "token非法")
-		return
+var tokenRejectedHooks []func(token string)
+
+// OnTokenRejected registers a hook called with every token VerifyToken
+// rejects, caches keyed by token use it to drop their entries.
+func OnTokenRejected(hook func(token string)) {
+	tokenRejectedHooks = append(tokenRejectedHooks, hook)
+}
+
+func rejectToken(token string) {
+	for _, hook := range tokenRejectedHooks {
+		hook(token)
 	}
-	tokens := strings.Split(token, " ")
-	if len(tokens) != 2 {
-		Response(ctx, 401, "token非法")
+}
+
+// VerifyToken resolves the user of the request token. Read requests may be
+// served from a recently verified identity while the backend is unavailable.
+func VerifyToken(ctx iris.Context) {
+	raw, ok := token.FromHeader(ctx.Request().Header.Get("Authorization"))
+	if !ok {
+		RespondWithDetailedError(ctx, errors.UnauthorizedErr("token非法", nil))
 		return
 	}
-	user, err := backend.GetClient().V1().User().VerifyUserByToken(tokens[1])
+	allowStale := ctx.Method() == http.MethodGet || ctx.Method() == http.MethodHead
+	identity, err := token.Verify(raw, allowStale)
 	if err != nil {
+		if stderrors.Is(err, token.ErrInvalidToken) {
+			logger.Warnf("reject token err: %s", err)
+			rejectToken(raw)
+			RespondWithDetailedError(ctx, errors.UnauthorizedErr("token非法", err))
+			return
+		}
+		logger.Errorf("verify token failed err: %s", err)
 		RespondWithDetailedError(ctx, errors.OriginErr(err))
 		return
 	}
-	if user == nil {
-		logger.Warnf("got nil user from backend via VerifyUserByToken")
-		Response(ctx, 401, "token非法")
-		return
-	}
 
 	ctx.Values().Set(define.UserContextKey, &UserContext{
-		Name:    user.Name,
-		Token:   tokens[1],
-		IsAdmin: user.Admin,
+		Name:    identity.Name,
+		Token:   raw,
+		IsAdmin: identity.Admin,
 	})
 	ctx.Next()
 }
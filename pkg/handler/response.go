@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
@@ -9,7 +10,9 @@ import (
 	"github.com/huhenry/hej/pkg/define"
 	customerrors "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler/audit"
+	"github.com/huhenry/hej/pkg/jsondiff"
 	"github.com/kataras/iris/v12"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 var MsgTrans = map[int]string{
@@ -57,6 +60,20 @@ func ResponseErr(ctx iris.Context, err error) {
 	responseLog(ctx, err.Error())
 }
 
+// ResponseFieldErrors returns every validation failure, the message is the
+// first failure so clients only reading the message keep working.
+func ResponseFieldErrors(ctx iris.Context, code int, errs FieldErrors) {
+	resp := &RespFieldErrorsJson{
+		Status: code,
+		Errors: errs,
+	}
+	if len(errs) > 0 {
+		resp.Msg = errs[0].Message
+	}
+	ctx.JSON(resp)
+	responseLog(ctx, errs.Error())
+}
+
 func RespondWithError(ctx iris.Context, code int, err error) {
 	resp := &RespJson{
 		Status: code,
@@ -118,7 +135,116 @@ func SendAudit(module, action, target string, ctx iris.Context) {
 	entry.Action = action
 	entry.Target = target
 
-	list := []*backendV1.Audit{&entry}
+	sendAudit(&entry, ctx)
+}
+
+// AuditDetail is the detail of an audit entry changing a resource.
+type AuditDetail struct {
+	Changes []jsondiff.Change `json:"changes"`
+}
+
+// AuditBefore returns what a change is diffed from, an empty object when the
+// state before the change could not be read, it is missing the first time.
+// The change goes on either way.
+func AuditBefore(before interface{}, err error) interface{} {
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			logger.Warnf("read state before the change failed, it is diffed from nothing err: %s", err)
+		}
+		return map[string]interface{}{}
+	}
+	return before
+}
+
+// SendAuditWithDiff records what the change altered, the detail is the json
+// diff of the resource before and after the change. The entry is still sent
+// without detail when the diff fails.
+func SendAuditWithDiff(module, action, target string, before, after interface{}, ctx iris.Context) {
+	entry := backendV1.Audit{}
+	entry.Module = module
+	entry.Action = action
+	entry.Target = target
+
+	changes, err := jsondiff.Diff(before, after)
+	if err != nil {
+		logger.Warnf("diff %s %s failed err: %s", module, target, err)
+	} else if detail, err := json.Marshal(&AuditDetail{Changes: changes}); err != nil {
+		logger.Warnf("marshal audit detail of %s %s failed err: %s", module, target, err)
+	} else {
+		entry.Detail = string(detail)
+	}
+
+	sendAudit(&entry, ctx)
+}
+
+// ClusterAuditDetail is the detail of an audit entry on a whole cluster. The
+// operations run as a job record the job and how it ended.
+type ClusterAuditDetail struct {
+	Operation string      `json:"operation"`
+	Options   interface{} `json:"options,omitempty"`
+	Job       string      `json:"job,omitempty"`
+	State     string      `json:"state,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// SendClusterAudit records an operation of a cluster route, these routes have
+// no app context so the entry only carries the cluster, the operation and its
+// options.
+func SendClusterAudit(module, action, cluster string, options interface{}, ctx iris.Context) {
+	audit.Send([]*backendV1.Audit{NewClusterAudit(module, action, cluster, &ClusterAuditDetail{Operation: action, Options: options}, ctx)})
+}
+
+// NewClusterAudit returns the entry SendClusterAudit sends, for an operation
+// which is audited once it is over, after the request is gone.
+func NewClusterAudit(module, action, cluster string, detail *ClusterAuditDetail, ctx iris.Context) *backendV1.Audit {
+	entry := &backendV1.Audit{}
+	entry.Module = module
+	entry.Action = action
+	entry.Target = cluster
+	entry.Cluster = cluster
+	entry.UserIp = getIp(ctx)
+	if userContext, ok := ctx.Values().Get(define.UserContextKey).(*UserContext); ok {
+		entry.User = userContext.Name
+	} else {
+		logger.Warnf("no user context found for audit %s %s of cluster %s", module, action, cluster)
+	}
+
+	SetClusterAuditDetail(entry, detail)
+	return entry
+}
+
+// NewAppAudit returns an entry of the given app, cluster and namespace rather
+// than the ones of the request, for an operation of the request acting on
+// another app, like a clone into another cluster.
+func NewAppAudit(module, action, target string, appId int64, cluster string, namespaceId int, ctx iris.Context) *backendV1.Audit {
+	entry := &backendV1.Audit{}
+	entry.Module = module
+	entry.Action = action
+	entry.Target = target
+	entry.AppId = appId
+	entry.Cluster = cluster
+	entry.NamespaceId = int64(namespaceId)
+	entry.UserIp = getIp(ctx)
+	if userContext, ok := ctx.Values().Get(define.UserContextKey).(*UserContext); ok {
+		entry.User = userContext.Name
+	} else {
+		logger.Warnf("no user context found for audit %s %s of app %d", module, action, appId)
+	}
+	return entry
+}
+
+// SetClusterAuditDetail replaces the detail of the entry, a detail which does
+// not marshal is left out.
+func SetClusterAuditDetail(entry *backendV1.Audit, detail *ClusterAuditDetail) {
+	if data, err := json.Marshal(detail); err != nil {
+		logger.Warnf("marshal audit detail of cluster %s failed err: %s", entry.Cluster, err)
+	} else {
+		entry.Detail = string(data)
+	}
+}
+
+func sendAudit(entry *backendV1.Audit, ctx iris.Context) {
+	list := []*backendV1.Audit{entry}
 	err := auditFill(list, ctx)
 	if err != nil {
 		logger.Errorf("try to generate entry message failed: %v", err)
@@ -4,16 +4,15 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/huhenry/hej/pkg/common"
 	"github.com/huhenry/hej/pkg/common/page"
-	"github.com/huhenry/hej/pkg/define"
 	customErrors "github.com/huhenry/hej/pkg/errors"
 	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/microapp/filter"
 	"github.com/huhenry/hej/pkg/log"
 	"github.com/huhenry/hej/pkg/multiCluster"
 	"github.com/huhenry/hej/pkg/prometheus"
@@ -44,7 +43,6 @@ const (
 	PathParameterStep    = "step"
 
 	DefaultInstallNamespace = "istio-system"
-	DefaultTag              = "1.6.0"
 	DefaultProfile          = "default"
 
 	JaegerPort = "5066"
@@ -179,37 +177,66 @@ func ParseParams(ctx iris.Context) (*serviceGovern.ServiceEventQueryOptions, err
 	return options, nil
 }
 
-func FetchServiceGovernEvents(mgr multiCluster.Manager, ctx iris.Context) {
+// eventSchema is what the service govern events are filtered and sorted on,
+// the create time is the time of the event.
+var eventSchema = filter.Schema{
+	filter.FieldCreateTime: func(item interface{}) interface{} {
+		eventAt, err := strconv.ParseInt(item.(*serviceGovern.ServiceGovernedEvent).EventAt, 10, 64)
+		if err != nil {
+			return nil
+		}
+		return eventAt
+	},
+	"event":         func(item interface{}) interface{} { return string(item.(*serviceGovern.ServiceGovernedEvent).Event) },
+	"sourceService": func(item interface{}) interface{} { return item.(*serviceGovern.ServiceGovernedEvent).SourceService },
+	"destinationService": func(item interface{}) interface{} {
+		return item.(*serviceGovern.ServiceGovernedEvent).DestinationService
+	},
+	"destinationWorkload": func(item interface{}) interface{} {
+		return item.(*serviceGovern.ServiceGovernedEvent).DestinationWorkload
+	},
+	"destinationVersion": func(item interface{}) interface{} {
+		return item.(*serviceGovern.ServiceGovernedEvent).DestinationVersion
+	},
+	"protocol":     func(item interface{}) interface{} { return item.(*serviceGovern.ServiceGovernedEvent).Protocol },
+	"responseCode": func(item interface{}) interface{} { return item.(*serviceGovern.ServiceGovernedEvent).ResponseCode },
+}
 
+// fetchEvents returns the events of the query, filtered and sorted through
+// eventSchema. The response is written when it fails.
+func fetchEvents(mgr multiCluster.Manager, ctx iris.Context) ([]interface{}, bool) {
 	opts, err := ParseOptions(mgr, ctx)
-
-	paramQuery := handler.ExtractQueryParam(ctx)
 	if err != nil {
 		handler.Response(ctx, customErrors.StatusCodeUnProcessableEntity, err.Error())
-		return
+		return nil, false
+	}
+	query, err := filter.ParserParams(ctx, eventSchema)
+	if err != nil {
+		handler.RespondWithDetailedError(ctx, customErrors.BadParametersErr(err))
+		return nil, false
 	}
+
 	result, err := serviceGovern.FetchServiceEvent(ctx.Request().Context(), opts)
 	if err != nil {
 		logger.Errorf("fetch ServiceEvent err %s", err)
-		//fmt.Printf("servicegraph get graph error %s", err)
 		handler.ResponseErr(ctx, err)
-		return
+		return nil, false
 	}
-	sort.Slice(result, func(i, j int) bool {
-		r := result[i].EventAt > result[j].EventAt
-		if paramQuery.Sortby == define.SortByCreateTime {
-			r = !r
-		}
 
-		return r
-	})
-	data := make([]interface{}, 0)
+	data := make([]interface{}, 0, len(result))
 	for i := range result {
-		dto := &result[i]
-		data = append(data, dto)
+		data = append(data, result[i])
 	}
-	handler.ResponseOk(ctx, page.PageInfo(data, paramQuery))
-	//handler.ResponseOk(ctx, result)
+	return query.Apply(eventSchema, data), true
+}
+
+func FetchServiceGovernEvents(mgr multiCluster.Manager, ctx iris.Context) {
+	events, ok := fetchEvents(mgr, ctx)
+	if !ok {
+		return
+	}
+
+	handler.ResponseOk(ctx, page.PageInfo(events, handler.ExtractQueryParam(ctx)))
 }
 
 func FetchSourceServiceLabels(mgr multiCluster.Manager, ctx iris.Context) {
@@ -247,35 +274,18 @@ func FetchDestWorkloadLabels(mgr multiCluster.Manager, ctx iris.Context) {
 }
 
 func ExportServiceGoverned(mgr multiCluster.Manager, ctx iris.Context) {
-	opts, err := ParseOptions(mgr, ctx)
-
-	paramQuery := handler.ExtractQueryParam(ctx)
-	if err != nil {
-		handler.Response(ctx, customErrors.StatusCodeUnProcessableEntity, err.Error())
+	events, ok := fetchEvents(mgr, ctx)
+	if !ok {
 		return
 	}
-	result, err := serviceGovern.FetchServiceEvent(ctx.Request().Context(), opts)
-	if err != nil {
-		logger.Errorf("fetch ServiceEvent err %s", err)
-		//fmt.Printf("servicegraph get graph error %s", err)
-		handler.ResponseErr(ctx, err)
-		return
-	}
-	sort.Slice(result, func(i, j int) bool {
-		r := result[i].EventAt > result[j].EventAt
-		if paramQuery.Sortby == define.SortByCreateTime {
-			r = !r
-		}
 
-		return r
-	})
 	b := &bytes.Buffer{}
 	w := csv.NewWriter(b)
 	// 写入UTF-8 BOM，防止乱码
 	b.WriteString("\xEF\xBB\xBF")
 	w.Write([]string{"事件类型", "请求服务", "作用服务", "作用工作负载", "版本", "服务协议", "响应码", "事件时间"})
-	for _, record := range result {
-		record := convertArray(record)
+	for _, event := range events {
+		record := convertArray(event.(*serviceGovern.ServiceGovernedEvent))
 		if err := w.Write(record); err != nil {
 			logger.Error("error writing record to csv:", err)
 		}
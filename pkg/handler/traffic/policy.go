@@ -68,11 +68,19 @@ func SetPolicy(ctx iris.Context) {
 		}
 	}
 
+	before := handler.AuditBefore(traffic.Policy().GetSettings(resource, name))
+
 	err = traffic.Policy().SetSettings(resource, application, name, settings)
 	if err != nil {
 		handler.ResponseErr(ctx, err)
 	} else {
-		handler.SendAudit(audit.ModuleMicroService, audit.ActionTrafficPolicy, application+"/"+name, ctx)
+		after, err := traffic.Policy().GetSettings(resource, name)
+		if err != nil {
+			logger.Warnf("get policy of %s after update failed err: %s", name, err)
+			handler.SendAudit(audit.ModuleMicroService, audit.ActionTrafficPolicy, application+"/"+name, ctx)
+		} else {
+			handler.SendAuditWithDiff(audit.ModuleMicroService, audit.ActionTrafficPolicy, application+"/"+name, before, after, ctx)
+		}
 		handler.ResponseOk(ctx, nil)
 	}
 }
@@ -0,0 +1,128 @@
+// Package jsondiff compares two values by their JSON representation and
+// reports the differences as JSON pointer paths.
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Operation string
+
+const (
+	OperationAdd     Operation = "add"
+	OperationRemove  Operation = "remove"
+	OperationReplace Operation = "replace"
+)
+
+// Change is one difference between two documents, Path is a JSON pointer
+// (RFC 6901) into the documents.
+type Change struct {
+	Op    Operation   `json:"op"`
+	Path  string      `json:"path"`
+	From  interface{} `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Diff returns the changes turning before into after, map keys are visited in
+// sorted order so the result is stable.
+func Diff(before, after interface{}) ([]Change, error) {
+	a, err := normalize(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := normalize(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	diff("", a, b, &changes)
+	return changes, nil
+}
+
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func diff(path string, a, b interface{}, changes *[]Change) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			diffMap(path, av, bv, changes)
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			diffSlice(path, av, bv, changes)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, Change{Op: OperationReplace, Path: rootPath(path), From: a, Value: b})
+	}
+}
+
+func diffMap(path string, a, b map[string]interface{}, changes *[]Change) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := path + "/" + escape(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			*changes = append(*changes, Change{Op: OperationAdd, Path: child, Value: bv})
+		case !inB:
+			*changes = append(*changes, Change{Op: OperationRemove, Path: child, From: av})
+		default:
+			diff(child, av, bv, changes)
+		}
+	}
+}
+
+func diffSlice(path string, a, b []interface{}, changes *[]Change) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		child := path + "/" + strconv.Itoa(i)
+		switch {
+		case i >= len(a):
+			*changes = append(*changes, Change{Op: OperationAdd, Path: child, Value: b[i]})
+		case i >= len(b):
+			*changes = append(*changes, Change{Op: OperationRemove, Path: child, From: a[i]})
+		default:
+			diff(child, a[i], b[i], changes)
+		}
+	}
+}
+
+func rootPath(path string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	return path
+}
+
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,59 @@
+package jsondiff_test
+
+import (
+	. "github.com/huhenry/hej/pkg/jsondiff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type weight struct {
+	Version string `json:"version"`
+	Weight  int32  `json:"weight"`
+}
+
+type policy struct {
+	Weights []weight          `json:"weights,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+var _ = Describe("Diff", func() {
+	It("should report nothing for equal documents", func() {
+		p := policy{Weights: []weight{{"v1", 90}, {"v2", 10}}}
+		changes, err := Diff(p, p)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(BeEmpty())
+	})
+
+	It("should report replaced values by path", func() {
+		before := policy{Weights: []weight{{"v1", 90}, {"v2", 10}}}
+		after := policy{Weights: []weight{{"v1", 50}, {"v2", 50}}}
+
+		changes, err := Diff(before, after)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]Change{
+			{Op: OperationReplace, Path: "/weights/0/weight", From: 90.0, Value: 50.0},
+			{Op: OperationReplace, Path: "/weights/1/weight", From: 10.0, Value: 50.0},
+		}))
+	})
+
+	It("should report added and removed entries", func() {
+		before := policy{Weights: []weight{{"v1", 100}}, Headers: map[string]string{"user": "a"}}
+		after := policy{Weights: []weight{{"v1", 100}, {"v2", 0}}, Headers: map[string]string{"x/y": "b"}}
+
+		changes, err := Diff(before, after)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]Change{
+			{Op: OperationRemove, Path: "/headers/user", From: "a"},
+			{Op: OperationAdd, Path: "/headers/x~1y", Value: "b"},
+			{Op: OperationAdd, Path: "/weights/1", Value: map[string]interface{}{"version": "v2", "weight": 0.0}},
+		}))
+	})
+
+	It("should replace the whole document when types differ", func() {
+		changes, err := Diff(nil, policy{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]Change{
+			{Op: OperationReplace, Path: "/", From: nil, Value: map[string]interface{}{}},
+		}))
+	})
+})
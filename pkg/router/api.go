@@ -30,6 +30,7 @@ var Writetimeout time.Duration = 20 * time.Second
 type API struct {
 	*iris.Application
 	grouprouter map[string]*ChildRouter
+	routes      []Route
 }
 
 type ChildRouter struct {
@@ -0,0 +1,256 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/huhenry/hej/pkg/handler"
+	"github.com/huhenry/hej/pkg/handler/auditlog"
+	"github.com/huhenry/hej/pkg/handler/auth"
+	"github.com/huhenry/hej/pkg/handler/canary"
+	"github.com/huhenry/hej/pkg/handler/graph"
+	"github.com/huhenry/hej/pkg/handler/installation"
+	"github.com/huhenry/hej/pkg/handler/installation/bookinfo"
+	"github.com/huhenry/hej/pkg/handler/license"
+	"github.com/huhenry/hej/pkg/handler/metrics"
+	workloadMetrics "github.com/huhenry/hej/pkg/handler/metrics/workload"
+	"github.com/huhenry/hej/pkg/handler/microapp"
+	"github.com/huhenry/hej/pkg/handler/servicegovern"
+	"github.com/huhenry/hej/pkg/handler/traffic"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/middleware/pprof"
+)
+
+// MethodAny registers the route for every http method.
+const MethodAny = "ANY"
+
+// Group is the party a route is registered on.
+type Group string
+
+const (
+	GroupRoot    Group = "/api/v1"
+	GroupCluster Group = "/api/v1/clusters/{cluster}"
+	GroupApp     Group = "/api/v1/apps/{app}/clusters/{cluster}"
+)
+
+const (
+	msgWorkloadCreate = "权限不足。需拥有无状态负载的创建和编辑权限才可进行当前操作。"
+	msgWorkloadUpdate = "权限不足。需拥有无状态负载的编辑权限才可进行当前操作。"
+	msgWorkloadDelete = "权限不足。需拥有无状态负载的删除权限才可进行当前操作。"
+	msgCanaryOffline  = "权限不足。需拥有灰度发布的下线权限和无状态负载的删除权限才可进行当前操作。"
+	msgCanaryAnalysis = "权限不足。需拥有灰度发布的编辑和下线权限才可进行当前操作，分析失败时灰度版本会被下线。"
+)
+
+var (
+	mc = []auth.Permission{auth.MC}
+	mr = []auth.Permission{auth.MR}
+	mu = []auth.Permission{auth.MU}
+	cr = []auth.Permission{auth.CR}
+	cu = []auth.Permission{auth.CU, auth.DU}
+	co = []auth.Permission{auth.CO, auth.DD}
+)
+
+// authExemptPrefixes are routes which change nothing but accept every method.
+var authExemptPrefixes = []string{
+	string(GroupRoot) + "/debug/",
+}
+
+var pprofHandler = pprof.New()
+
+// Route declares one api together with the permissions it requires.
+type Route struct {
+	Method string
+	Group  Group
+	Path   string
+	// Permissions are all required, they are checked before the handler.
+	Permissions []auth.Permission
+	// Message replaces the default message when a permission is missing.
+	Message string
+	// Admin restricts the route to administrators, cluster routes have no
+	// application to check permissions against.
+	Admin bool
+	// Authenticated verifies the token of root routes, cluster and app
+	// routes always verify it.
+	Authenticated bool
+
+	Handler      context.Handler
+	MultiCluster MultiClusterHandler
+}
+
+func (r Route) FullPath() string {
+	return string(r.Group) + r.Path
+}
+
+// HandlerName is the name of the function serving the route.
+func (r Route) HandlerName() string {
+	var fn interface{} = r.Handler
+	if r.MultiCluster != nil {
+		fn = r.MultiCluster
+	}
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	return strings.TrimPrefix(f.Name(), "github.com/huhenry/hej/pkg/")
+}
+
+// PermissionNames lists the permissions as resource:action.
+func (r Route) PermissionNames() []string {
+	names := make([]string, 0, len(r.Permissions)+1)
+	if r.Admin {
+		names = append(names, "admin")
+	}
+	for _, permission := range r.Permissions {
+		names = append(names, fmt.Sprintf("%s:%s", permission.Resource, permission.Action))
+	}
+	return names
+}
+
+func (r Route) handlers(a *API) []context.Handler {
+	handlers := []context.Handler{}
+	if r.Authenticated {
+		handlers = append(handlers, handler.VerifyToken)
+	}
+	if r.Admin {
+		handlers = append(handlers, handler.MustAdmin)
+	}
+	if len(r.Permissions) > 0 {
+		handlers = append(handlers, auth.HandlerWithMsg(r.Message, r.Permissions...))
+	}
+	if r.MultiCluster != nil {
+		return append(handlers, RegisterMultiClusterHandler(a.Manager, r.MultiCluster))
+	}
+	return append(handlers, r.Handler)
+}
+
+func isAuthExempt(route Route) bool {
+	for _, prefix := range authExemptPrefixes {
+		if strings.HasPrefix(route.FullPath(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyRoutes refuses routes which change state without any permission.
+func VerifyRoutes(routes []Route) error {
+	missing := []string{}
+	for _, route := range routes {
+		if route.Method == http.MethodGet || isAuthExempt(route) {
+			continue
+		}
+		if len(route.Permissions) == 0 && !route.Admin {
+			missing = append(missing, route.Method+" "+route.FullPath())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("routes without permission: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Routes is the table of every api served by hej.
+func (a *API) Routes() []Route {
+	return []Route{
+		{Method: http.MethodGet, Group: GroupApp, Path: "/resources/available/services", Permissions: []auth.Permission{auth.SR}, Handler: microapp.GetAvailableServices},
+
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications", Permissions: mc, Handler: microapp.CreateApplication},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{name}", Permissions: mr, Handler: microapp.GetApplication},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{name}", Permissions: mu, Handler: microapp.UpdateApplication},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/applications/{name}", Permissions: []auth.Permission{auth.MD, auth.SD, auth.DD}, Handler: microapp.DeleteApplication},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{name}/export", Permissions: mr, MultiCluster: microapp.ExportApplication},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/import", Permissions: microapp.ImportPermissions, MultiCluster: microapp.ImportApplication},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{name}/clone", Permissions: mr, MultiCluster: microapp.CloneApplication},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications", Permissions: mr, Handler: microapp.ListApplication},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/microapps", Permissions: mr, Handler: microapp.ListApplicationNames},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/microservices", Permissions: []auth.Permission{auth.MU, auth.SU, auth.DU}, Handler: microapp.CreateMicroService},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/microservice_batch", Permissions: []auth.Permission{auth.MU, auth.SU, auth.DU}, Handler: microapp.BatchCreateMicroService},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/microservices", Permissions: mr, Handler: microapp.ListMicroService},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/applications/{application}/microservices/{name}", Permissions: mu, Handler: microapp.DeleteMicroService},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/servicenames", Permissions: mr, Handler: microapp.ListApplicationServiceNames},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/microservices/{name}/workload", Permissions: mr, MultiCluster: microapp.GetWorkloadContainers},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/microservices/{name}/availableworkloads", Permissions: mr, Handler: microapp.GetAvailableWorkloads},
+
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/serviceEntries", Permissions: mu, MultiCluster: microapp.CreateMicroServiceEntry},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/serviceEntries", Permissions: mu, MultiCluster: microapp.UpdateMicroServiceEntry},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/serviceEntries", Permissions: mr, Handler: microapp.ListMicroServiceEntry},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/serviceEntries/{name}", Permissions: mr, Handler: microapp.GetMicroServiceEntry},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/applications/{application}/serviceEntries/{name}", Permissions: mu, MultiCluster: microapp.DeleteMicroServiceEntry},
+
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/microservices/{name}/policy", Permissions: mr, Handler: traffic.GetPolicy},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/microservices/{name}/policy", Permissions: []auth.Permission{auth.MU, auth.SU}, Handler: traffic.SetPolicy},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/gateways", Permissions: mu, Handler: microapp.CreateGateway},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/gateway_batch", Permissions: mu, MultiCluster: microapp.BatchCreateGateway},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/gateways", Permissions: mr, Handler: microapp.ListGateway},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/applications/{application}/gateways/{name}", Permissions: mu, MultiCluster: microapp.DeleteGateway},
+
+		{Method: http.MethodGet, Group: GroupApp, Path: "/metrics/service/{service}", Permissions: mr, MultiCluster: metrics.GetMetrics},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/metrics/unknown/{nodeType}", Permissions: mr, MultiCluster: metrics.GetMetrics},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/metrics/edge", Permissions: mr, MultiCluster: metrics.GetEdgeMetrics},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/metrics/app/{name}/{version}", Permissions: mr, MultiCluster: metrics.GetAppMetrics},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/metrics/workload/{name}", Permissions: mr, MultiCluster: workloadMetrics.GetMetrics},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/graphs", Permissions: mr, MultiCluster: graph.GetGraphs},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/graphs", Permissions: mr, MultiCluster: graph.GetGraphs},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/serviceevent", Permissions: mr, MultiCluster: servicegovern.FetchServiceGovernEvents},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/serviceevent/export", Permissions: mr, MultiCluster: servicegovern.ExportServiceGoverned},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/sourceservice/list", Permissions: mr, MultiCluster: servicegovern.FetchSourceServiceLabels},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/destservice/list", Permissions: mr, MultiCluster: servicegovern.FetchDestServiceLabels},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/destworkload/list", Permissions: mr, MultiCluster: servicegovern.FetchDestWorkloadLabels},
+
+		{Method: http.MethodPost, Group: GroupApp, Path: "/demo", Permissions: []auth.Permission{auth.MC, auth.MU, auth.SC, auth.DC}, Handler: bookinfo.InstallHandler(a.Manager)},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/demo", Permissions: mr, MultiCluster: bookinfo.ListDemos},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/demo/{name}", Permissions: mr, MultiCluster: bookinfo.GetDemo},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/demo/{name}", Permissions: []auth.Permission{auth.MD, auth.SD, auth.DD}, MultiCluster: bookinfo.DeleteDemo},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/demos/catalog", Permissions: mr, Handler: bookinfo.Catalog},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/demos/{template}", Permissions: []auth.Permission{auth.MC, auth.MU, auth.SC, auth.DC}, MultiCluster: bookinfo.InstallTemplate},
+
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/canary", Permissions: []auth.Permission{auth.CC, auth.DC, auth.DU}, Message: msgWorkloadCreate, MultiCluster: canary.CreateCanary},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canaries", Permissions: cr, MultiCluster: canary.ListCanary},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/canaries", Permissions: cr, MultiCluster: canary.ListCanary},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}", Permissions: cr, MultiCluster: canary.GetCanaryDetail},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/metricscount", Permissions: cr, MultiCluster: canary.GetCanaryMetriceSummary},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/metricsweight", Permissions: cr, MultiCluster: canary.GetCanaryMetriceWeight},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/metrics", Permissions: cr, MultiCluster: canary.GetCanaryMetrics},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/applications/{application}/canary/{canary}", Permissions: []auth.Permission{auth.CD, auth.DD}, Message: msgWorkloadDelete, MultiCluster: canary.DeleteCanary},
+
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/policy", Permissions: cr, MultiCluster: canary.GetCanaryPolicy},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/policy", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.UpdateCanaryPolicy},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/revisions", Permissions: cr, MultiCluster: canary.ListCanaryRevisions},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/rollback", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.RollbackCanary},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/takeoveralltraffic", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.TakeOverAllTraffic},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/gooffline", Permissions: co, Message: msgCanaryOffline, MultiCluster: canary.GoOffline},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/phase", Permissions: cr, MultiCluster: canary.GetCanaryPhase},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/pause", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.PauseCanary},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/resume", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.ResumeCanary},
+		{Method: http.MethodPut, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/promote", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.PromoteCanary},
+		{Method: http.MethodPost, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/analysis", Permissions: []auth.Permission{auth.CU, auth.DU, auth.CO}, Message: msgCanaryAnalysis, MultiCluster: canary.StartCanaryAnalysis},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/analysis", Permissions: cr, MultiCluster: canary.GetCanaryAnalysis},
+		{Method: http.MethodDelete, Group: GroupApp, Path: "/applications/{application}/canary/{canary}/analysis", Permissions: cu, Message: msgWorkloadUpdate, MultiCluster: canary.StopCanaryAnalysis},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/workload/{name}/validation", Permissions: cr, MultiCluster: canary.WorkloadValidation},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/microservice/{name}/validation", Permissions: cr, MultiCluster: canary.MicroServiceValidation},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/microservice/{service}/availableworkload/{name}/validation", Permissions: cr, MultiCluster: canary.AvailableWorkloadValidation},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/applications/{application}/workload/{workload}/pods", Permissions: mr, Handler: microapp.ListWorkloadPods},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/healthz/{node_type}/{name}", Permissions: mr, Handler: microapp.Healthz},
+		{Method: http.MethodGet, Group: GroupApp, Path: "/audits", Permissions: mr, Handler: auditlog.ListAudits},
+
+		{Method: http.MethodPost, Group: GroupCluster, Path: "/servicemesh/preflight", Admin: true, MultiCluster: installation.Preflight},
+		{Method: http.MethodPost, Group: GroupCluster, Path: "/servicemesh/install", Admin: true, MultiCluster: installation.Install},
+		{Method: http.MethodGet, Group: GroupCluster, Path: "/servicemesh/egress/status", MultiCluster: installation.EgressStatus},
+		{Method: http.MethodPost, Group: GroupCluster, Path: "/servicemesh/uninstall", Admin: true, MultiCluster: installation.Uninstall},
+		{Method: http.MethodPost, Group: GroupCluster, Path: "/servicemesh/egress/{operation}", Admin: true, MultiCluster: installation.EgressEnable},
+		{Method: http.MethodGet, Group: GroupCluster, Path: "/servicemesh/status", MultiCluster: installation.Status},
+		{Method: http.MethodPost, Group: GroupCluster, Path: "/servicemesh/upgrade", Admin: true, MultiCluster: installation.Upgrade},
+		{Method: http.MethodPost, Group: GroupCluster, Path: "/servicemesh/upgrade/rollback", Admin: true, MultiCluster: installation.Rollback},
+		{Method: http.MethodGet, Group: GroupCluster, Path: "/servicemesh/upgrade/status", MultiCluster: installation.UpgradeStatus},
+		{Method: http.MethodGet, Group: GroupCluster, Path: "/servicemesh/jobs/{id}", MultiCluster: installation.GetJob},
+
+		{Method: http.MethodGet, Group: GroupRoot, Path: "/healthz", Handler: handler.Healthz},
+		{Method: http.MethodGet, Group: GroupRoot, Path: "/license", Authenticated: true, Handler: license.GetLicense},
+		{Method: MethodAny, Group: GroupRoot, Path: "/debug/pprof", Handler: pprofHandler},
+		{Method: MethodAny, Group: GroupRoot, Path: "/debug/pprof/{action}", Handler: pprofHandler},
+	}
+}
@@ -0,0 +1,148 @@
+package token
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+var verificationCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "hej_token_cache_requests_total",
+	Help: "Token verifications served by the verification cache, by result hit, miss or stale.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(verificationCacheRequests)
+}
+
+type verificationEntry struct {
+	identity   *Identity
+	verifiedAt time.Time
+}
+
+// VerificationCache keeps the identities verified by the backend. An entry is
+// fresh for ttl, then it may still be served for staleTTL when the backend
+// fails, so a brief outage of the user service does not fail read traffic.
+type VerificationCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	staleTTL time.Duration
+	size     int
+	entries  map[string]*verificationEntry
+	group    singleflight.Group
+	now      func() time.Time
+}
+
+func NewVerificationCache(ttl, staleTTL time.Duration, size int) *VerificationCache {
+	return &VerificationCache{
+		ttl:      ttl,
+		staleTTL: staleTTL,
+		size:     size,
+		entries:  make(map[string]*verificationEntry),
+		now:      time.Now,
+	}
+}
+
+// Get returns the fresh cached identity or calls load once for all
+// concurrent callers of the token. When load fails with anything but
+// ErrInvalidToken and allowStale is set, a stale identity is returned.
+func (c *VerificationCache) Get(token string, allowStale bool, load func() (*Identity, error)) (*Identity, error) {
+	if identity, ok := c.lookup(token, c.ttl); ok {
+		verificationCacheRequests.WithLabelValues("hit").Inc()
+		return identity, nil
+	}
+	verificationCacheRequests.WithLabelValues("miss").Inc()
+
+	value, err, _ := c.group.Do(token, func() (interface{}, error) {
+		identity, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.store(token, identity)
+		return identity, nil
+	})
+	if err == nil {
+		return value.(*Identity), nil
+	}
+
+	if errors.Is(err, ErrInvalidToken) {
+		c.Evict(token)
+		return nil, err
+	}
+	if allowStale {
+		if identity, ok := c.lookup(token, c.ttl+c.staleTTL); ok {
+			logger.Warnf("verify token of %s failed, serve the cached identity err: %s", identity.Name, err)
+			verificationCacheRequests.WithLabelValues("stale").Inc()
+			return identity, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *VerificationCache) lookup(token string, maxAge time.Duration) (*Identity, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[token]
+	if !ok || c.now().Sub(entry.verifiedAt) >= maxAge {
+		return nil, false
+	}
+	return entry.identity, true
+}
+
+func (c *VerificationCache) store(token string, identity *Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	now := c.now()
+	if _, ok := c.entries[token]; !ok && len(c.entries) >= c.size {
+		for key, entry := range c.entries {
+			if now.Sub(entry.verifiedAt) >= c.ttl+c.staleTTL {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+	c.entries[token] = &verificationEntry{identity: identity, verifiedAt: now}
+}
+
+// Evict drops the token, it is called when the token is rejected.
+func (c *VerificationCache) Evict(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, token)
+}
+
+func (c *VerificationCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// BackendVerifier verifies tokens with the backend user service through a
+// VerificationCache.
+type BackendVerifier struct {
+	cache  *VerificationCache
+	lookup func(token string) (*Identity, error)
+}
+
+func NewBackendVerifier(cache *VerificationCache, lookup func(token string) (*Identity, error)) *BackendVerifier {
+	return &BackendVerifier{cache: cache, lookup: lookup}
+}
+
+func (v *BackendVerifier) Verify(token string, allowStale bool) (*Identity, error) {
+	return v.cache.Get(token, allowStale, func() (*Identity, error) {
+		return v.lookup(token)
+	})
+}
@@ -0,0 +1,99 @@
+package token_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/huhenry/hej/pkg/token"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VerificationCache", func() {
+	var (
+		calls   int32
+		failure error
+		load    func() (*Identity, error)
+	)
+
+	BeforeEach(func() {
+		calls = 0
+		failure = nil
+		load = func() (*Identity, error) {
+			atomic.AddInt32(&calls, 1)
+			if failure != nil {
+				return nil, failure
+			}
+			return &Identity{Name: "alice"}, nil
+		}
+	})
+
+	It("should serve repeated verifications from cache", func() {
+		cache := NewVerificationCache(time.Minute, time.Minute, 10)
+
+		for i := 0; i < 3; i++ {
+			identity, err := cache.Get("token", false, load)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(identity.Name).To(Equal("alice"))
+		}
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("should serve a stale identity to read requests when the backend fails", func() {
+		cache := NewVerificationCache(10*time.Millisecond, time.Minute, 10)
+
+		_, _ = cache.Get("token", true, load)
+		time.Sleep(20 * time.Millisecond)
+		failure = errors.New("connection refused")
+
+		identity, err := cache.Get("token", true, load)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity.Name).To(Equal("alice"))
+
+		_, err = cache.Get("token", false, load)
+		Expect(err).To(MatchError("connection refused"))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(3))
+	})
+
+	It("should not serve identities older than the stale window", func() {
+		cache := NewVerificationCache(10*time.Millisecond, 10*time.Millisecond, 10)
+
+		_, _ = cache.Get("token", true, load)
+		time.Sleep(30 * time.Millisecond)
+		failure = errors.New("connection refused")
+
+		_, err := cache.Get("token", true, load)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should evict rejected tokens", func() {
+		cache := NewVerificationCache(10*time.Millisecond, time.Minute, 10)
+
+		_, _ = cache.Get("token", true, load)
+		time.Sleep(20 * time.Millisecond)
+		failure = fmt.Errorf("%w: revoked", ErrInvalidToken)
+
+		_, err := cache.Get("token", true, load)
+		Expect(errors.Is(err, ErrInvalidToken)).To(BeTrue())
+		Expect(cache.Len()).To(Equal(0))
+	})
+
+	It("should not grow beyond its size", func() {
+		cache := NewVerificationCache(time.Minute, time.Minute, 2)
+
+		for i := 0; i < 3; i++ {
+			_, _ = cache.Get(fmt.Sprintf("token-%d", i), false, load)
+		}
+		Expect(cache.Len()).To(Equal(2))
+	})
+
+	It("should not cache when the ttl is 0", func() {
+		cache := NewVerificationCache(0, time.Minute, 10)
+
+		_, _ = cache.Get("token", false, load)
+		_, _ = cache.Get("token", false, load)
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+})
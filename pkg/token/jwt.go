@@ -0,0 +1,214 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// KeySet maps the key id to the public key, a key loaded from PEM has the
+// empty key id.
+type KeySet map[string]crypto.PublicKey
+
+// LoadJWKS reads the RSA and EC signing keys of a JWKS file.
+func LoadJWKS(path string) (KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func ParseJWKS(data []byte) (KeySet, error) {
+	jwks := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := KeySet{}
+	for _, jwk := range jwks.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		if !jwk.Valid() || !jwk.IsPublic() {
+			return nil, fmt.Errorf("key %q is not a valid public key", jwk.KeyID)
+		}
+		switch jwk.Key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys[jwk.KeyID] = jwk.Key
+		default:
+			return nil, fmt.Errorf("key %q: unsupported key %T", jwk.KeyID, jwk.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing key found")
+	}
+	return keys, nil
+}
+
+// LoadPEM reads a PKIX public key or a certificate.
+func LoadPEM(path string) (KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePEM(data)
+}
+
+func ParsePEM(data []byte) (KeySet, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = rsaKey
+	default:
+		pkixKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = pkixKey
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return KeySet{"": key}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key %T", key)
+}
+
+// algorithms are the signatures accepted, the ones of RSA and EC keys.
+var algorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+}
+
+// JWTVerifier validates signed JWTs locally, RS256/384/512 and ES256/384/512
+// are supported. The name is read from the name, preferred_username or sub
+// claim. Tokens carry no admin flag unless AdminClaim names the boolean
+// claim of the identity provider holding it.
+type JWTVerifier struct {
+	Keys     KeySet
+	Issuer   string
+	Audience string
+	// AdminClaim is off when empty.
+	AdminClaim string
+	// Leeway tolerates clock skew on exp and nbf.
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+func NewJWTVerifier(keys KeySet) *JWTVerifier {
+	return &JWTVerifier{
+		Keys:   keys,
+		Leeway: 30 * time.Second,
+		now:    time.Now,
+	}
+}
+
+type userClaims struct {
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidToken, fmt.Sprintf(format, args...))
+}
+
+// Verify checks the signature and the time and audience claims, the cache is
+// not used since the check is local.
+func (v *JWTVerifier) Verify(token string, allowStale bool) (*Identity, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, invalid("malformed jwt: %s", err)
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, invalid("exactly one signature is expected")
+	}
+	header := parsed.Headers[0]
+	if !algorithms[header.Algorithm] {
+		return nil, invalid("unsupported algorithm %q", header.Algorithm)
+	}
+	key, err := v.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	standard := jwt.Claims{}
+	user := userClaims{}
+	custom := map[string]interface{}{}
+	if err := parsed.Claims(key, &standard, &user, &custom); err != nil {
+		return nil, invalid("%s", err)
+	}
+
+	if standard.Expiry == nil {
+		return nil, invalid("exp claim is required")
+	}
+	expected := jwt.Expected{Issuer: v.Issuer, Time: v.now()}
+	if len(v.Audience) > 0 {
+		expected.Audience = jwt.Audience{v.Audience}
+	}
+	if err := standard.ValidateWithLeeway(expected, v.Leeway); err != nil {
+		return nil, invalid("%s", err)
+	}
+
+	name := user.Name
+	if len(name) == 0 {
+		name = user.PreferredUsername
+	}
+	if len(name) == 0 {
+		name = standard.Subject
+	}
+	if len(name) == 0 {
+		return nil, invalid("no user name claim")
+	}
+
+	identity := &Identity{Name: name}
+	if len(v.AdminClaim) > 0 {
+		identity.Admin, _ = custom[v.AdminClaim].(bool)
+	}
+	return identity, nil
+}
+
+// key returns the key of kid, a token without kid or a key set without
+// ids is fine as long as there is a single key.
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := v.Keys[kid]; ok {
+		return key, nil
+	}
+	if len(v.Keys) == 1 {
+		for id, key := range v.Keys {
+			if len(id) == 0 || len(kid) == 0 {
+				return key, nil
+			}
+		}
+	}
+	return nil, invalid("unknown key %q", kid)
+}
@@ -0,0 +1,181 @@
+package token_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	. "github.com/huhenry/hej/pkg/token"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func encodeSegment(v interface{}) string {
+	data, err := json.Marshal(v)
+	Expect(err).NotTo(HaveOccurred())
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).NotTo(HaveOccurred())
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": "ES256"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	Expect(err).NotTo(HaveOccurred())
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWKS(kid string, key *rsa.PublicKey) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return data
+}
+
+var _ = Describe("JWTVerifier", func() {
+	var (
+		rsaKey *rsa.PrivateKey
+		claims map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		claims = map[string]interface{}{
+			"sub":   "42",
+			"name":  "alice",
+			"admin": true,
+			"iss":   "tpaas",
+			"aud":   []string{"hej"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+	})
+
+	It("should verify tokens signed by a JWKS key", func() {
+		keys, err := ParseJWKS(rsaJWKS("k1", &rsaKey.PublicKey))
+		Expect(err).NotTo(HaveOccurred())
+		verifier := NewJWTVerifier(keys)
+		verifier.Issuer = "tpaas"
+		verifier.Audience = "hej"
+
+		identity, err := verifier.Verify(signRS256(rsaKey, "k1", claims), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity).To(Equal(&Identity{Name: "alice"}))
+	})
+
+	It("should read the admin flag only from the configured claim", func() {
+		keys, err := ParseJWKS(rsaJWKS("k1", &rsaKey.PublicKey))
+		Expect(err).NotTo(HaveOccurred())
+		verifier := NewJWTVerifier(keys)
+		verifier.AdminClaim = "hej_admin"
+
+		identity, err := verifier.Verify(signRS256(rsaKey, "k1", claims), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity.Admin).To(BeFalse())
+
+		claims["hej_admin"] = true
+		identity, err = verifier.Verify(signRS256(rsaKey, "k1", claims), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity.Admin).To(BeTrue())
+	})
+
+	It("should verify tokens signed by a PEM EC key", func() {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		keys, err := ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		Expect(err).NotTo(HaveOccurred())
+
+		delete(claims, "name")
+		identity, err := NewJWTVerifier(keys).Verify(signES256(ecKey, claims), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity.Name).To(Equal("42"))
+	})
+
+	DescribeRejection := func(description string, mutate func(token string) string) {
+		It("should reject "+description, func() {
+			keys, err := ParseJWKS(rsaJWKS("k1", &rsaKey.PublicKey))
+			Expect(err).NotTo(HaveOccurred())
+			verifier := NewJWTVerifier(keys)
+			verifier.Audience = "hej"
+
+			_, err = verifier.Verify(mutate(signRS256(rsaKey, "k1", claims)), false)
+			Expect(errors.Is(err, ErrInvalidToken)).To(BeTrue(), fmt.Sprint(err))
+		})
+	}
+
+	DescribeRejection("a tampered token", func(token string) string {
+		parts := strings.Split(token, ".")
+		claims["name"] = "mallory"
+		return parts[0] + "." + encodeSegment(claims) + "." + parts[2]
+	})
+	DescribeRejection("an expired token", func(string) string {
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		return signRS256(rsaKey, "k1", claims)
+	})
+	DescribeRejection("a token which is not valid yet", func(string) string {
+		claims["nbf"] = time.Now().Add(time.Hour).Unix()
+		return signRS256(rsaKey, "k1", claims)
+	})
+	DescribeRejection("a token of another audience", func(string) string {
+		claims["aud"] = "other"
+		return signRS256(rsaKey, "k1", claims)
+	})
+	DescribeRejection("a token of an unknown key", func(string) string {
+		return signRS256(rsaKey, "k2", claims)
+	})
+	DescribeRejection("a token which is not a jwt", func(string) string {
+		return "opaque"
+	})
+	DescribeRejection("an unsigned token", func(string) string {
+		return encodeSegment(map[string]string{"alg": "none"}) + "." + encodeSegment(claims) + "."
+	})
+})
+
+var _ = Describe("FromHeader", func() {
+	It("should accept bare and scheme prefixed tokens", func() {
+		raw, ok := FromHeader("Bearer abc")
+		Expect(ok).To(BeTrue())
+		Expect(raw).To(Equal("abc"))
+
+		raw, ok = FromHeader("abc")
+		Expect(ok).To(BeTrue())
+		Expect(raw).To(Equal("abc"))
+
+		_, ok = FromHeader("")
+		Expect(ok).To(BeFalse())
+		_, ok = FromHeader("Bearer a b")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,136 @@
+package token
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/huhenry/hej/pkg/backend"
+	customErrors "github.com/huhenry/hej/pkg/errors"
+	"github.com/huhenry/hej/pkg/log"
+	"github.com/spf13/viper"
+)
+
+var logger = log.RegisterScope("token")
+
+const (
+	tokenMode      = "token.mode"
+	tokenJWKSFile  = "token.jwks_file"
+	tokenPEMFile   = "token.public_key_file"
+	tokenIssuer    = "token.issuer"
+	tokenAudience  = "token.audience"
+	tokenAdmin     = "token.admin_claim"
+	tokenCacheTTL  = "token.cache_ttl"
+	tokenStaleTTL  = "token.stale_ttl"
+	tokenCacheSize = "token.cache_size"
+
+	// ModeBackend verifies tokens with the backend user service.
+	ModeBackend = "backend"
+	// ModeJWT verifies signed JWTs locally, the backend is not called.
+	ModeJWT = "jwt"
+
+	defaultCacheTTL  = 10 * time.Second
+	defaultStaleTTL  = 5 * time.Minute
+	defaultCacheSize = 4096
+)
+
+// ErrInvalidToken is wrapped by every error meaning the token itself is
+// rejected, other errors mean the token could not be verified.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is the user a token belongs to.
+type Identity struct {
+	Name  string
+	Admin bool
+}
+
+// Verifier resolves the identity of a token. allowStale lets a verifier
+// answer from an expired cache entry when the source of truth is unreachable.
+type Verifier interface {
+	Verify(token string, allowStale bool) (*Identity, error)
+}
+
+var defaultVerifier Verifier = NewBackendVerifier(
+	NewVerificationCache(defaultCacheTTL, defaultStaleTTL, defaultCacheSize), lookupBackend)
+
+// AddFlags adds the token verification flags
+func AddFlags(flagSet *flag.FlagSet) {
+	flagSet.String(tokenMode, ModeBackend, "How tokens are verified, backend or jwt.")
+	flagSet.String(tokenJWKSFile, "", "JWKS file holding the keys of signed tokens, used in jwt mode.")
+	flagSet.String(tokenPEMFile, "", "PEM public key of signed tokens, used in jwt mode when no JWKS file is set.")
+	flagSet.String(tokenIssuer, "", "Expected iss claim of signed tokens, empty accepts any issuer.")
+	flagSet.String(tokenAudience, "", "Expected aud claim of signed tokens, empty accepts any audience.")
+	flagSet.String(tokenAdmin, "", "Boolean claim of signed tokens marking administrators, empty leaves admin rights to the backend.")
+	flagSet.Duration(tokenCacheTTL, defaultCacheTTL, "How long backend verification results are cached, 0 disables the cache.")
+	flagSet.Duration(tokenStaleTTL, defaultStaleTTL, "How long after expiry a cached result still serves read requests while the backend is unavailable.")
+	flagSet.Int(tokenCacheSize, defaultCacheSize, "Maximal number of tokens kept in the verification cache.")
+}
+
+// InitFromViper configures the token verifier with properties from viper
+func InitFromViper(v *viper.Viper) error {
+	switch mode := v.GetString(tokenMode); mode {
+	case ModeBackend, "":
+		defaultVerifier = NewBackendVerifier(
+			NewVerificationCache(v.GetDuration(tokenCacheTTL), v.GetDuration(tokenStaleTTL), v.GetInt(tokenCacheSize)),
+			lookupBackend)
+	case ModeJWT:
+		var keys KeySet
+		var err error
+		if path := v.GetString(tokenJWKSFile); len(path) > 0 {
+			keys, err = LoadJWKS(path)
+		} else if path := v.GetString(tokenPEMFile); len(path) > 0 {
+			keys, err = LoadPEM(path)
+		} else {
+			err = fmt.Errorf("%s or %s is required in %s mode", tokenJWKSFile, tokenPEMFile, ModeJWT)
+		}
+		if err != nil {
+			return err
+		}
+		verifier := NewJWTVerifier(keys)
+		verifier.Issuer = v.GetString(tokenIssuer)
+		verifier.Audience = v.GetString(tokenAudience)
+		verifier.AdminClaim = v.GetString(tokenAdmin)
+		defaultVerifier = verifier
+		logger.Infof("tokens are verified locally with %d keys", len(keys))
+	default:
+		return fmt.Errorf("unknown %s %q", tokenMode, mode)
+	}
+	return nil
+}
+
+// Verify resolves the identity of a token with the configured verifier.
+func Verify(token string, allowStale bool) (*Identity, error) {
+	return defaultVerifier.Verify(token, allowStale)
+}
+
+// FromHeader extracts the token of an Authorization header, both a bare
+// token and "<scheme> <token>" are accepted.
+func FromHeader(header string) (string, bool) {
+	fields := strings.Fields(header)
+	switch len(fields) {
+	case 1:
+		return fields[0], true
+	case 2:
+		return fields[1], true
+	}
+	return "", false
+}
+
+func lookupBackend(token string) (*Identity, error) {
+	user, err := backend.GetClient().V1().User().VerifyUserByToken(token)
+	if err != nil {
+		var upError *customErrors.UpstreamError
+		if errors.As(err, &upError) && (upError.Code == http.StatusUnauthorized || upError.Code == http.StatusForbidden) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+		}
+		return nil, err
+	}
+	if user == nil {
+		logger.Warnf("got nil user from backend via VerifyUserByToken")
+		return nil, ErrInvalidToken
+	}
+	return &Identity{Name: user.Name, Admin: user.Admin}, nil
+}